@@ -16,3 +16,6 @@ var TemplateFS embed.FS
 
 //go:embed manuf
 var ManufTxt []byte
+
+//go:embed services
+var ServicesTxt []byte
@@ -10,11 +10,12 @@ var __awaiter = (this && this.__awaiter) || function (thisArg, _arguments, P, ge
 define(["require", "exports"], function (require, exports) {
     "use strict";
     Object.defineProperty(exports, "__esModule", { value: true });
-    exports.setOrderBy = void 0;
+    exports.resetPeriod = exports.setOrderBy = void 0;
     var orderBy = "ip";
+    const filterInterface = new URLSearchParams(window.location.search).get("interface");
     const setOrderBy = (o) => {
         orderBy = o;
-        updateData();
+        subscribe();
     };
     exports.setOrderBy = setOrderBy;
     const fmtRate = function (bytes, decimals = 2) {
@@ -27,9 +28,65 @@ define(["require", "exports"], function (require, exports) {
         const v = parseFloat((bytes / Math.pow(k, i)).toFixed(dm));
         return `${v} ${sizes[i]}/s`;
     };
-    const updateData = () => __awaiter(void 0, void 0, void 0, function* () {
-        const resp = yield fetch(`/v1/stats/?order_by=${orderBy}`);
-        const data = yield resp.json();
+    const resetPeriod = () => __awaiter(void 0, void 0, void 0, function* () {
+        if (!confirm("Reset the traffic totals of all clients?")) {
+            return;
+        }
+        yield fetch("/v1/stats/reset", { method: "POST" });
+    });
+    exports.resetPeriod = resetPeriod;
+    const fmtBytes = function (bytes, decimals = 2) {
+        if (bytes < 1)
+            return "";
+        const k = 1024;
+        const dm = decimals < 0 ? 0 : decimals;
+        const sizes = ["B", "KB", "MB", "GB", "TB", "PB", "EB", "ZB", "YB"];
+        const i = Math.floor(Math.log(bytes) / Math.log(k));
+        const v = parseFloat((bytes / Math.pow(k, i)).toFixed(dm));
+        return `${v} ${sizes[i]}`;
+    };
+    const fmtPacketRate = function (packets) {
+        if (packets < 0.01)
+            return "";
+        return `${packets.toFixed(1)} p/s`;
+    };
+    // quotaCell shows how much of the quota of a client has been used.
+    const quotaCell = (q) => {
+        if (!q) {
+            return "<td></td>";
+        }
+        const cls = q.exceeded ? ' class="failed"' : "";
+        const title = `${q.name} per ${q.period}${q.enforced ? ", restricted" : ""}`;
+        return `<td${cls} title="${title}">${fmtBytes(q.used) || "0 B"} / ${fmtBytes(q.limit)}</td>`;
+    };
+    // renderAlerts shows a banner for each firing alert.
+    const renderAlerts = (alerts) => {
+        const container = document.getElementById("alerts");
+        container.textContent = "";
+        for (const a of alerts) {
+            const div = document.createElement("div");
+            div.className = "failed-invert banner";
+            const value = a.metric.endsWith("rate")
+                ? fmtRate(a.value)
+                : fmtBytes(a.value);
+            const client = a.ip
+                ? `<a href="/client?ip=${a.ip}">${a.name || a.ip}</a>`
+                : a.subject;
+            div.innerHTML = `${a.rule}: ${client} ${a.expr} (${value})`;
+            container.appendChild(div);
+        }
+    };
+    // pollAlerts refreshes the alert banners until the alerts are not available,
+    // the API only exists when alert rules are configured.
+    const pollAlerts = () => __awaiter(void 0, void 0, void 0, function* () {
+        const resp = yield fetch("/v1/alerts?state=firing");
+        if (!resp.ok) {
+            return;
+        }
+        renderAlerts(yield resp.json());
+        setTimeout(pollAlerts, 10000);
+    });
+    const render = (data) => {
         const el = document.createElement("tbody");
         const header = document.createElement("tr");
         header.innerHTML = `
@@ -37,37 +94,58 @@ define(["require", "exports"], function (require, exports) {
 <th><button onclick="app.setOrderBy('name')">Hostname</a></th>
 <th><button onclick="app.setOrderBy('rate_in')">IN rate</a></th>
 <th><button onclick="app.setOrderBy('rate_out')">OUT rate</a></th>
+<th><button onclick="app.setOrderBy('period_in')">IN total</a></th>
+<th><button onclick="app.setOrderBy('period_out')">OUT total</a></th>
+<th>Quota</th>
+<th>IN pkts</th>
+<th>OUT pkts</th>
 <th><button onclick="app.setOrderBy('hwaddr')">MAC</a></th>
 <th><button onclick="app.setOrderBy('manufacturer')">Manufacturer</a></th>
+<th><button onclick="app.setOrderBy('interface')">Interface</a></th>
 `;
         el.appendChild(header);
         for (const v of data) {
             const tr = document.createElement("tr");
             tr.innerHTML = `
- <td><a href="/conntrack?ip=${v.ip}">${v.ip}</a></td>
+ <td><a href="/client?ip=${v.ip}">${v.ip}</a></td>
  <td>${v.name}</td>
  <td class="success">${fmtRate(v.in_rate)}</td>
  <td class="failed">${fmtRate(v.out_rate)}</td>
+ <td class="success" title="${fmtBytes(v.in_bytes)} since first seen">${fmtBytes(v.period_in_bytes)}</td>
+ <td class="failed" title="${fmtBytes(v.out_bytes)} since first seen">${fmtBytes(v.period_out_bytes)}</td>
+ ${quotaCell(v.quota)}
+ <td>${fmtPacketRate(v.in_packet_rate)}</td>
+ <td>${fmtPacketRate(v.out_packet_rate)}</td>
  <td>${v.hwaddr}</td>
  <td>${v.manufacturer}</td>
+ <td><a href="/?interface=${v.interface}">${v.interface}</a></td>
 `;
             el.appendChild(tr);
         }
         const container = document.getElementById("hosts");
         container.textContent = "";
         container.appendChild(el);
-    });
-    updateData();
-    setInterval(function () {
-        var _a;
-        return __awaiter(this, void 0, void 0, function* () {
-            if (((_a = document.getSelection()) === null || _a === void 0 ? void 0 : _a.type) !== "Range") {
-                if (!document.hidden) {
-                    yield updateData();
-                }
+    };
+    var source = null;
+    // subscribe (re)opens the stats stream with the current order.
+    const subscribe = () => {
+        if (source) {
+            source.close();
+        }
+        const params = new URLSearchParams({ order_by: orderBy });
+        if (filterInterface) {
+            params.set("interface", filterInterface);
+        }
+        source = new EventSource(`/v1/stats/stream?${params}`);
+        source.onmessage = (e) => {
+            var _a;
+            if (((_a = document.getSelection()) === null || _a === void 0 ? void 0 : _a.type) === "Range" || document.hidden) {
+                return;
             }
-        });
-    }, 900);
+            render(JSON.parse(e.data));
+        };
+    };
+    subscribe();
+    pollAlerts();
     window.app = this;
-});
-//# sourceMappingURL=data:application/json;base64,eyJ2ZXJzaW9uIjozLCJmaWxlIjoiY2xpZW50cy5qcyIsInNvdXJjZVJvb3QiOiIiLCJzb3VyY2VzIjpbIi4uLy4uL3RzL2NsaWVudHMudHMiXSwibmFtZXMiOltdLCJtYXBwaW5ncyI6Ijs7Ozs7Ozs7Ozs7OztJQVNBLElBQUksT0FBTyxHQUFHLElBQUksQ0FBQztJQUVaLE1BQU0sVUFBVSxHQUFHLENBQUMsQ0FBUyxFQUFFLEVBQUU7UUFDdEMsT0FBTyxHQUFHLENBQUMsQ0FBQztRQUNaLFVBQVUsRUFBRSxDQUFDO0lBQ2YsQ0FBQyxDQUFDO0lBSFcsUUFBQSxVQUFVLGNBR3JCO0lBRUYsTUFBTSxPQUFPLEdBQUcsVUFBVSxLQUFhLEVBQUUsUUFBUSxHQUFHLENBQUM7UUFDbkQsSUFBSSxLQUFLLEdBQUcsSUFBSTtZQUFFLE9BQU8sRUFBRSxDQUFDO1FBQzVCLE1BQU0sQ0FBQyxHQUFHLElBQUksQ0FBQztRQUNmLE1BQU0sRUFBRSxHQUFHLFFBQVEsR0FBRyxDQUFDLENBQUMsQ0FBQyxDQUFDLENBQUMsQ0FBQyxDQUFDLENBQUMsUUFBUSxDQUFDO1FBQ3ZDLE1BQU0sS0FBSyxHQUFHLENBQUMsR0FBRyxFQUFFLElBQUksRUFBRSxJQUFJLEVBQUUsSUFBSSxFQUFFLElBQUksRUFBRSxJQUFJLEVBQUUsSUFBSSxFQUFFLElBQUksRUFBRSxJQUFJLENBQUMsQ0FBQztRQUNwRSxNQUFNLENBQUMsR0FBRyxJQUFJLENBQUMsS0FBSyxDQUFDLElBQUksQ0FBQyxHQUFHLENBQUMsS0FBSyxDQUFDLEdBQUcsSUFBSSxDQUFDLEdBQUcsQ0FBQyxDQUFDLENBQUMsQ0FBQyxDQUFDO1FBQ3BELE1BQU0sQ0FBQyxHQUFHLFVBQVUsQ0FBQyxDQUFDLEtBQUssR0FBRyxJQUFJLENBQUMsR0FBRyxDQUFDLENBQUMsRUFBRSxDQUFDLENBQUMsQ0FBQyxDQUFDLE9BQU8sQ0FBQyxFQUFFLENBQUMsQ0FBQyxDQUFDO1FBQzNELE9BQU8sR0FBRyxDQUFDLElBQUksS0FBSyxDQUFDLENBQUMsQ0FBQyxJQUFJLENBQUM7SUFDOUIsQ0FBQyxDQUFDO0lBRUYsTUFBTSxVQUFVLEdBQUcsR0FBUyxFQUFFO1FBQzVCLE1BQU0sSUFBSSxHQUFHLE1BQU0sS0FBSyxDQUFDLHVCQUF1QixPQUFPLEVBQUUsQ0FBQyxDQUFDO1FBQzNELE1BQU0sSUFBSSxHQUFlLE1BQU0sSUFBSSxDQUFDLElBQUksRUFBRSxDQUFDO1FBRTNDLE1BQU0sRUFBRSxHQUFHLFFBQVEsQ0FBQyxhQUFhLENBQUMsT0FBTyxDQUFDLENBQUM7UUFDM0MsTUFBTSxNQUFNLEdBQUcsUUFBUSxDQUFDLGFBQWEsQ0FBQyxJQUFJLENBQUMsQ0FBQztRQUM1QyxNQUFNLENBQUMsU0FBUyxHQUFHOzs7Ozs7O0NBT3BCLENBQUM7UUFDQSxFQUFFLENBQUMsV0FBVyxDQUFDLE1BQU0sQ0FBQyxDQUFDO1FBRXZCLEtBQUssTUFBTSxDQUFDLElBQUksSUFBSSxFQUFFO1lBQ3BCLE1BQU0sRUFBRSxHQUFHLFFBQVEsQ0FBQyxhQUFhLENBQUMsSUFBSSxDQUFDLENBQUM7WUFDeEMsRUFBRSxDQUFDLFNBQVMsR0FBRzs4QkFDVyxDQUFDLENBQUMsRUFBRSxLQUFLLENBQUMsQ0FBQyxFQUFFO09BQ3BDLENBQUMsQ0FBQyxJQUFJO3VCQUNVLE9BQU8sQ0FBQyxDQUFDLENBQUMsT0FBTyxDQUFDO3NCQUNuQixPQUFPLENBQUMsQ0FBQyxDQUFDLFFBQVEsQ0FBQztPQUNsQyxDQUFDLENBQUMsTUFBTTtPQUNSLENBQUMsQ0FBQyxZQUFZO0NBQ3BCLENBQUM7WUFDRSxFQUFFLENBQUMsV0FBVyxDQUFDLEVBQUUsQ0FBQyxDQUFDO1NBQ3BCO1FBRUQsTUFBTSxTQUFTLEdBQUcsUUFBUSxDQUFDLGNBQWMsQ0FBQyxPQUFPLENBQUUsQ0FBQztRQUNwRCxTQUFTLENBQUMsV0FBVyxHQUFHLEVBQUUsQ0FBQztRQUMzQixTQUFTLENBQUMsV0FBVyxDQUFDLEVBQUUsQ0FBQyxDQUFDO0lBQzVCLENBQUMsQ0FBQSxDQUFDO0lBRUYsVUFBVSxFQUFFLENBQUM7SUFFYixXQUFXLENBQUM7OztZQUNWLElBQUksQ0FBQSxNQUFBLFFBQVEsQ0FBQyxZQUFZLEVBQUUsMENBQUUsSUFBSSxNQUFLLE9BQU8sRUFBRTtnQkFDN0MsSUFBSSxDQUFDLFFBQVEsQ0FBQyxNQUFNLEVBQUU7b0JBQ3BCLE1BQU0sVUFBVSxFQUFFLENBQUM7aUJBQ3BCO2FBQ0Y7O0tBQ0YsRUFBRSxHQUFHLENBQUMsQ0FBQztJQVFSLE1BQU0sQ0FBQyxHQUFHLEdBQUcsSUFBSSxDQUFDIn0=
\ No newline at end of file
+});
\ No newline at end of file
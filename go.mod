@@ -10,11 +10,17 @@ require (
 	github.com/florianl/go-conntrack v0.4.0
 	github.com/gizak/termui/v3 v3.1.0
 	github.com/go-pa/flagutil v0.1.0
+	github.com/google/nftables v0.3.0
+	github.com/gorilla/websocket v1.5.3
+	github.com/jsimonetti/rtnetlink v1.4.2
 	github.com/justinas/alice v1.2.0
 	github.com/matryer/is v1.4.0
+	github.com/mdlayher/netlink v1.8.0
 	github.com/mxmCherry/movavg v1.1.3
+	github.com/oschwald/maxminddb-golang v1.13.1
 	github.com/peterbourgon/ff/v3 v3.4.0
 	github.com/rs/zerolog v1.34.0
+	golang.org/x/sys v0.38.0
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1
 )
 
@@ -25,12 +31,10 @@ require (
 	github.com/mattn/go-colorable v0.1.14 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/mattn/go-runewidth v0.0.19 // indirect
-	github.com/mdlayher/netlink v1.8.0 // indirect
 	github.com/mdlayher/socket v0.5.1 // indirect
 	github.com/mitchellh/go-wordwrap v1.0.1 // indirect
 	github.com/nsf/termbox-go v1.1.1 // indirect
 	github.com/rs/xid v1.6.0 // indirect
 	golang.org/x/net v0.46.0 // indirect
 	golang.org/x/sync v0.18.0 // indirect
-	golang.org/x/sys v0.38.0 // indirect
 )
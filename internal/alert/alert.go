@@ -0,0 +1,377 @@
+// Package alert evaluates bandwidth alert rules against the client stats and
+// notifies when alerts start and stop firing.
+//
+// An alert is pending while the condition of its rule holds for a shorter
+// time than the rule requires, it then fires until the condition no longer
+// holds and it is resolved. Alerts are kept per rule and device, or per rule
+// for rules on the total of all matching clients. The clients of a device with
+// several IPv4 addresses are combined into one subject.
+package alert
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/some-programs/natbwmon/internal/clientstats"
+	"github.com/some-programs/natbwmon/internal/log"
+)
+
+// resolvedRetention is how long resolved alerts are listed.
+const resolvedRetention = time.Hour
+
+// queueSize is the number of notifications that can wait for delivery, later
+// notifications are dropped.
+const queueSize = 64
+
+// State is the state of an alert.
+type State string
+
+const (
+	Pending  State = "pending"
+	Firing   State = "firing"
+	Resolved State = "resolved"
+)
+
+// TotalSubject is the subject of the alerts of rules on totals.
+const TotalSubject = "total"
+
+// Alert is the state of a rule for a client or the total of all clients.
+type Alert struct {
+	Rule       string    `json:"rule"`
+	Expr       string    `json:"expr"`
+	Metric     Metric    `json:"metric"`
+	Subject    string    `json:"subject"` // the hardware or IP address of the client or total
+	IP         string    `json:"ip"`
+	Name       string    `json:"name"`
+	State      State     `json:"state"`
+	Value      float64   `json:"value"` // the last evaluated value while the condition holds
+	Threshold  float64   `json:"threshold"`
+	ActiveAt   time.Time `json:"active_at"` // when the condition started to hold
+	FiredAt    time.Time `json:"fired_at,omitzero"`
+	ResolvedAt time.Time `json:"resolved_at,omitzero"`
+}
+
+// String returns a one line description of the alert.
+func (a Alert) String() string {
+	subject := a.Subject
+	if a.Name != "" {
+		subject = fmt.Sprintf("%s (%s)", a.Subject, a.Name)
+	}
+	return fmt.Sprintf("%s %s %s: %s, value %s", a.State, a.Rule, subject, a.Expr, a.Metric.Format(a.Value))
+}
+
+// Notifier delivers alerts that start firing or are resolved.
+type Notifier interface {
+	Notify(ctx context.Context, a Alert) error
+}
+
+// Manager evaluates the rules and keeps track of the alerts.
+type Manager struct {
+	rules     []Rule
+	notifiers []Notifier
+	queue     chan Alert
+
+	mu     sync.Mutex
+	alerts map[alertKey]*Alert
+	usage  map[string]*usage   // traffic today by client subject
+	last   map[string]counters // the last seen totals by client IP address
+	day    time.Time           // start of the day the usage is counted for
+	prev   time.Time           // time of the previous evaluation
+}
+
+type alertKey struct {
+	rule    string
+	subject string
+}
+
+// usage is the traffic of a subject during the current day.
+type usage struct {
+	stat              clientstats.Stat   // the last seen clients combined, with the rates summed
+	clients           []clientstats.Stat // the last seen clients
+	inToday, outToday uint64
+}
+
+// counters are the totals of a client.
+type counters struct {
+	in, out uint64
+}
+
+// NewManager returns a manager that evaluates the rules and notifies the
+// notifiers when alerts fire and are resolved once Run is called.
+func NewManager(rules []Rule, notifiers ...Notifier) *Manager {
+	return &Manager{
+		rules:     rules,
+		notifiers: notifiers,
+		queue:     make(chan Alert, queueSize),
+		alerts:    make(map[alertKey]*Alert),
+		usage:     make(map[string]*usage),
+		last:      make(map[string]counters),
+	}
+}
+
+// Run delivers notifications until ctx is cancelled.
+func (m *Manager) Run(ctx context.Context) {
+	for {
+		select {
+		case a := <-m.queue:
+			for _, n := range m.notifiers {
+				if err := n.Notify(ctx, a); err != nil && ctx.Err() == nil {
+					log.Warn().Err(err).Str("rule", a.Rule).Msg("alert notification failed")
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// subject returns the subject of the alerts about the client.
+func subject(s clientstats.Stat) string {
+	if s.HWAddr != "" {
+		return s.HWAddr
+	}
+	return s.IP
+}
+
+// Evaluate evaluates the rules against the stats at t.
+func (m *Manager) Evaluate(stats clientstats.Stats, t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	subjects := m.updateUsage(stats, t)
+	active := make(map[alertKey]bool)
+	for _, r := range m.rules {
+		if r.Between != nil && !r.Between.Contains(t) {
+			continue
+		}
+		if r.Total {
+			// rates are summed over the current clients and the traffic of
+			// the day also over the clients that have left.
+			var total float64
+			if r.Metric.IsRate() {
+				for _, s := range stats {
+					if r.Filter.Match(s) {
+						total += rate(s, r.Metric)
+					}
+				}
+			} else {
+				for _, u := range m.usage {
+					if u.matches(r.Filter) {
+						total += u.value(r.Metric)
+					}
+				}
+			}
+			if r.compare(total) {
+				k := alertKey{rule: r.Name, subject: TotalSubject}
+				active[k] = true
+				m.activate(k, r, clientstats.Stat{}, total, t)
+			}
+			continue
+		}
+		for _, sub := range subjects {
+			u := m.usage[sub]
+			if !u.matches(r.Filter) {
+				continue
+			}
+			v := u.value(r.Metric)
+			if r.compare(v) {
+				k := alertKey{rule: r.Name, subject: sub}
+				active[k] = true
+				m.activate(k, r, u.stat, v, t)
+			}
+		}
+	}
+
+	for k, a := range m.alerts {
+		if active[k] {
+			continue
+		}
+		switch a.State {
+		case Pending:
+			delete(m.alerts, k)
+		case Firing:
+			a.State = Resolved
+			a.ResolvedAt = t
+			m.notify(*a)
+		case Resolved:
+			if t.Sub(a.ResolvedAt) > resolvedRetention {
+				delete(m.alerts, k)
+			}
+		}
+	}
+}
+
+// activate updates the alert of a rule whose condition holds, m.mu must be
+// held.
+func (m *Manager) activate(k alertKey, r Rule, s clientstats.Stat, v float64, t time.Time) {
+	a, ok := m.alerts[k]
+	if !ok || a.State == Resolved {
+		a = &Alert{
+			Rule:      r.Name,
+			Expr:      r.Expr,
+			Subject:   k.subject,
+			State:     Pending,
+			Threshold: r.Threshold,
+			ActiveAt:  t,
+			Metric:    r.Metric,
+		}
+		m.alerts[k] = a
+	}
+	a.IP, a.Name, a.Value = s.IP, s.Name, v
+	if a.State == Pending && t.Sub(a.ActiveAt) >= r.For {
+		a.State = Firing
+		a.FiredAt = t
+		m.notify(*a)
+	}
+}
+
+// notify queues a notification without blocking, m.mu must be held.
+func (m *Manager) notify(a Alert) {
+	select {
+	case m.queue <- a:
+	default:
+		log.Warn().Str("rule", a.Rule).Msg("alert notification queue full, dropping notification")
+	}
+}
+
+// updateUsage adds the traffic since the last evaluation to the traffic of
+// the day and returns the subjects of the clients in stats in order, m.mu must
+// be held.
+//
+// A client address that has not been seen before is only counted from zero if
+// the client was first seen after the previous evaluation. Otherwise the
+// client has changed its primary address and its traffic has already been
+// counted, its current totals are only kept to count from.
+func (m *Manager) updateUsage(stats clientstats.Stats, t time.Time) []string {
+	prev := m.prev
+	m.prev = t
+	y, mo, d := t.Date()
+	day := time.Date(y, mo, d, 0, 0, 0, 0, t.Location())
+	if !day.Equal(m.day) {
+		m.day = day
+		current := make(map[string]bool, len(stats))
+		for _, s := range stats {
+			current[subject(s)] = true
+			current[s.IP] = true
+		}
+		for k, u := range m.usage {
+			if !current[k] {
+				delete(m.usage, k)
+				continue
+			}
+			u.inToday, u.outToday = 0, 0
+		}
+		for ip := range m.last {
+			if !current[ip] {
+				delete(m.last, ip)
+			}
+		}
+	}
+	var subjects []string
+	seen := make(map[string]bool, len(stats))
+	for _, s := range stats {
+		k := subject(s)
+		u, ok := m.usage[k]
+		if !ok {
+			u = &usage{}
+			m.usage[k] = u
+		}
+		if !seen[k] {
+			seen[k] = true
+			subjects = append(subjects, k)
+			u.stat = s
+			u.clients = u.clients[:0]
+		} else {
+			u.stat.InRate += s.InRate
+			u.stat.OutRate += s.OutRate
+		}
+		u.clients = append(u.clients, s)
+		last, ok := m.last[s.IP]
+		m.last[s.IP] = counters{in: s.InBytes, out: s.OutBytes}
+		if !ok && !prev.IsZero() && !s.FirstSeen.After(prev) {
+			continue
+		}
+		u.inToday += since(s.InBytes, last.in)
+		u.outToday += since(s.OutBytes, last.out)
+	}
+	return subjects
+}
+
+// matches returns true if any of the last seen clients of the subject
+// matches f.
+func (u *usage) matches(f clientstats.Filter) bool {
+	for _, s := range u.clients {
+		if f.Match(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// since returns the bytes counted since the last total, the total has been
+// reset if it is smaller than last.
+func since(total, last uint64) uint64 {
+	if total < last {
+		return total
+	}
+	return total - last
+}
+
+// value returns the value of the metric.
+func (u *usage) value(metric Metric) float64 {
+	if u == nil {
+		return 0
+	}
+	switch metric {
+	case InRate, OutRate, Rate:
+		return rate(u.stat, metric)
+	case InBytesToday:
+		return float64(u.inToday)
+	case OutBytesToday:
+		return float64(u.outToday)
+	case BytesToday:
+		return float64(u.inToday + u.outToday)
+	}
+	return 0
+}
+
+// rate returns the value of a rate metric of the client.
+func rate(s clientstats.Stat, metric Metric) float64 {
+	switch metric {
+	case InRate:
+		return s.InRate
+	case OutRate:
+		return s.OutRate
+	case Rate:
+		return s.InRate + s.OutRate
+	}
+	return 0
+}
+
+// stateOrder orders firing alerts before pending and resolved alerts.
+var stateOrder = map[State]int{Firing: 0, Pending: 1, Resolved: 2}
+
+// Alerts returns the pending, firing and recently resolved alerts, the firing
+// alerts first and the most recently active first within each state.
+func (m *Manager) Alerts() []Alert {
+	m.mu.Lock()
+	res := make([]Alert, 0, len(m.alerts))
+	for _, a := range m.alerts {
+		res = append(res, *a)
+	}
+	m.mu.Unlock()
+	slices.SortFunc(res, func(a, b Alert) int {
+		if c := cmp.Compare(stateOrder[a.State], stateOrder[b.State]); c != 0 {
+			return c
+		}
+		if c := b.ActiveAt.Compare(a.ActiveAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Rule+a.Subject, b.Rule+b.Subject)
+	})
+	return res
+}
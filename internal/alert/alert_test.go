@@ -0,0 +1,197 @@
+package alert
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/some-programs/natbwmon/internal/clientstats"
+)
+
+func TestParseRules(t *testing.T) {
+	is := is.New(t)
+	rules, err := ParseRules(strings.NewReader(`
+# comment
+download: in_rate > 50MiB/s for 2m
+night_upload: hwaddr=00:11:22:33:44:55 out_rate >= 1MiB/s between 01:00-06:00
+wan_today: total_bytes_today > 20GiB
+kids: group=kids total_rate > 1.5MB/s between 22:00-07:00 for 30s
+`))
+	is.NoErr(err)
+	is.Equal(len(rules), 4)
+
+	is.Equal(rules[0].Name, "download")
+	is.Equal(rules[0].Expr, "in_rate > 50MiB/s for 2m")
+	is.Equal(rules[0].Metric, InRate)
+	is.Equal(rules[0].Threshold, float64(50<<20))
+	is.Equal(rules[0].For, 2*time.Minute)
+	is.True(rules[0].Between == nil)
+
+	is.Equal(rules[1].Filter.HWAddrs, []string{"00:11:22:33:44:55"})
+	is.Equal(rules[1].Op, ">=")
+	is.Equal(*rules[1].Between, TimeWindow{From: 60, To: 360})
+
+	is.True(rules[2].Total)
+	is.Equal(rules[2].Metric, BytesToday)
+	is.Equal(rules[2].Threshold, float64(20<<30))
+
+	is.Equal(rules[3].Filter.Groups, []string{"kids"})
+	is.Equal(rules[3].Threshold, 1.5e6)
+	is.Equal(rules[3].For, 30*time.Second)
+
+	for _, s := range []string{
+		"in_rate > 1MiB/s",
+		"x: in_rate > 1MiB",
+		"x: bytes_today > 1MiB/s",
+		"x: in_rate > 1XB/s",
+		"x: in_rate = 1MiB/s",
+		"x: packets > 1",
+		"x: mac=00:11:22:33:44:55 in_rate > 1MiB/s",
+		"x: in_rate > 1MiB/s for",
+		"x: in_rate > 1MiB/s between 1-2",
+	} {
+		_, err := ParseRule(s)
+		is.True(err != nil) // invalid rule
+	}
+
+	_, err = ParseRules(strings.NewReader("a: rate > 1/s\na: rate > 2/s\n"))
+	is.True(err != nil) // duplicate name
+}
+
+func TestTimeWindow(t *testing.T) {
+	is := is.New(t)
+	at := func(h, m int) time.Time { return time.Date(2024, 1, 1, h, m, 0, 0, time.Local) }
+	w := TimeWindow{From: 60, To: 360}
+	is.True(w.Contains(at(1, 0)))
+	is.True(!w.Contains(at(6, 0)))
+	is.True(!w.Contains(at(0, 59)))
+	night := TimeWindow{From: 22 * 60, To: 7 * 60}
+	is.True(night.Contains(at(23, 0)))
+	is.True(night.Contains(at(3, 0)))
+	is.True(!night.Contains(at(12, 0)))
+}
+
+type recorder chan Alert
+
+func (r recorder) Notify(ctx context.Context, a Alert) error {
+	r <- a
+	return nil
+}
+
+func TestManager(t *testing.T) {
+	is := is.New(t)
+	rules, err := ParseRules(strings.NewReader(`
+download: in_rate > 1MiB/s for 2m
+wan_today: total_bytes_today > 1GiB
+`))
+	is.NoErr(err)
+	notified := make(recorder, 10)
+	m := NewManager(rules, notified)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	tv := clientstats.Stat{IP: "192.168.0.10", HWAddr: "00:00:00:00:00:01", Name: "tv", InRate: 2 << 20}
+	nas := clientstats.Stat{IP: "192.168.0.11", InBytes: 600 << 20}
+
+	m.Evaluate(clientstats.Stats{tv, nas}, t0)
+	as := m.Alerts()
+	is.Equal(len(as), 1)
+	is.Equal(as[0].State, Pending)
+	is.Equal(as[0].Subject, "00:00:00:00:00:01")
+	is.Equal(as[0].Name, "tv")
+
+	// the condition has held long enough
+	m.Evaluate(clientstats.Stats{tv, nas}, t0.Add(2*time.Minute))
+	is.Equal(m.Alerts()[0].State, Firing)
+	a := <-notified
+	is.Equal(a.State, Firing)
+	is.Equal(a.Rule, "download")
+
+	// the nas has left but its traffic still counts towards the total of the day
+	tv.InRate = 0
+	tv.InBytes = 500 << 20
+	m.Evaluate(clientstats.Stats{tv}, t0.Add(3*time.Minute))
+	a = <-notified
+	is.Equal(a.State, Firing)
+	is.Equal(a.Rule, "wan_today")
+	is.Equal(a.Subject, TotalSubject)
+	is.Equal(a.Value, float64(1100<<20))
+	a = <-notified
+	is.Equal(a.State, Resolved)
+	is.Equal(a.Rule, "download")
+
+	as = m.Alerts()
+	is.Equal(len(as), 2)
+	is.Equal(as[0].Rule, "wan_today") // firing first
+	is.Equal(as[1].State, Resolved)
+
+	// a new day starts from zero
+	m.Evaluate(clientstats.Stats{tv}, t0.Add(12*time.Hour))
+	is.Equal((<-notified).State, Resolved)
+	is.Equal(len(m.Alerts()), 1) // the download alert has expired
+
+	// a pending alert that stops holding is dropped without notifications
+	tv.InRate = 2 << 20
+	m.Evaluate(clientstats.Stats{tv}, t0.Add(13*time.Hour))
+	tv.InRate = 0
+	m.Evaluate(clientstats.Stats{tv}, t0.Add(13*time.Hour+time.Minute))
+	is.Equal(len(m.Alerts()), 0) // and the resolved total alert has expired
+	is.Equal(len(notified), 0)
+}
+
+func TestManagerSharedHWAddr(t *testing.T) {
+	is := is.New(t)
+	rules, err := ParseRules(strings.NewReader(`
+download: in_rate > 1MiB/s
+today: bytes_today > 2000
+`))
+	is.NoErr(err)
+	m := NewManager(rules)
+
+	// a device with two IPv4 addresses is two clients
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	a := clientstats.Stat{IP: "192.168.0.10", HWAddr: "00:00:00:00:00:01", InBytes: 1000, InRate: 600 << 10}
+	b := clientstats.Stat{IP: "192.168.0.20", HWAddr: "00:00:00:00:00:01", InBytes: 10, InRate: 600 << 10}
+	for i := range 10 {
+		m.Evaluate(clientstats.Stats{a, b}, t0.Add(time.Duration(i)*time.Second))
+	}
+	as := m.Alerts()
+	is.Equal(len(as), 1) // the combined rate, the traffic of the day is 1010 bytes
+	is.Equal(as[0].Rule, "download")
+	is.Equal(as[0].Value, float64(1200<<10))
+
+	// a filter on either address matches the device
+	rules, err = ParseRules(strings.NewReader(`download: ip=192.168.0.20 in_rate > 1MiB/s`))
+	is.NoErr(err)
+	m = NewManager(rules)
+	m.Evaluate(clientstats.Stats{a, b}, t0)
+	is.Equal(len(m.Alerts()), 1)
+}
+
+func TestManagerAddressChange(t *testing.T) {
+	is := is.New(t)
+	rules, err := ParseRules(strings.NewReader(`wan_today: total_bytes_today > 1500`))
+	is.NoErr(err)
+	m := NewManager(rules)
+
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	tv := clientstats.Stat{IP: "2001:db8::10", HWAddr: "00:00:00:00:00:01", InBytes: 1000, FirstSeen: t0}
+	m.Evaluate(clientstats.Stats{tv}, t0)
+
+	// the IPv4 address becomes the primary address of the client
+	tv.IP = "192.168.0.10"
+	tv.InBytes = 1200
+	m.Evaluate(clientstats.Stats{tv}, t0.Add(time.Second))
+	is.Equal(len(m.Alerts()), 0) // the totals are not counted again
+
+	// a client that was first seen since is counted from zero
+	phone := clientstats.Stat{IP: "192.168.0.20", InBytes: 600, FirstSeen: t0.Add(1500 * time.Millisecond)}
+	m.Evaluate(clientstats.Stats{tv, phone}, t0.Add(2*time.Second))
+	as := m.Alerts()
+	is.Equal(len(as), 1)
+	is.Equal(as[0].Value, float64(1600))
+}
@@ -0,0 +1,99 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/syslog"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/some-programs/natbwmon/internal/webhook"
+)
+
+// Webhook posts alerts as JSON to HTTP endpoints.
+type Webhook struct {
+	urls   []string
+	sender *webhook.Sender
+}
+
+// NewWebhook returns a notifier that posts to the urls, retrying failed
+// deliveries at most retries times.
+func NewWebhook(urls []string, retries int, backoff time.Duration) *Webhook {
+	return &Webhook{
+		urls:   urls,
+		sender: webhook.New(urls, nil, retries, backoff),
+	}
+}
+
+func (w *Webhook) Notify(ctx context.Context, a Alert) error {
+	var errs []string
+	for _, url := range w.urls {
+		if err := w.sender.Deliver(ctx, url, &a); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("webhook: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// Syslog writes alerts to syslog, firing alerts with the warning severity and
+// resolved alerts as info.
+type Syslog struct {
+	w *syslog.Writer
+}
+
+// NewSyslog connects to the local syslog daemon if addr is local, otherwise
+// addr is network://host:port, ex: udp://192.168.0.2:514.
+func NewSyslog(addr string) (*Syslog, error) {
+	var network, raddr string
+	if addr != "local" {
+		var ok bool
+		network, raddr, ok = strings.Cut(addr, "://")
+		if !ok {
+			return nil, fmt.Errorf("syslog address must be local or network://host:port: %s", addr)
+		}
+	}
+	w, err := syslog.Dial(network, raddr, syslog.LOG_WARNING|syslog.LOG_DAEMON, "natbwmon")
+	if err != nil {
+		return nil, err
+	}
+	return &Syslog{w: w}, nil
+}
+
+func (s *Syslog) Notify(ctx context.Context, a Alert) error {
+	if a.State == Resolved {
+		return s.w.Info(a.String())
+	}
+	return s.w.Warning(a.String())
+}
+
+// SMTP mails alerts through an SMTP server without authentication, such as
+// a local mail relay.
+type SMTP struct {
+	Addr string // host:port
+	From string
+	To   []string
+}
+
+func (s *SMTP) Notify(ctx context.Context, a Alert) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&b, "Subject: [natbwmon] %s %s %s\r\n", strings.ToUpper(string(a.State)), a.Rule, a.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&b, "%s\r\n\r\n", a.String())
+	fmt.Fprintf(&b, "rule:      %s: %s\r\n", a.Rule, a.Expr)
+	fmt.Fprintf(&b, "client:    %s %s %s\r\n", a.Subject, a.IP, a.Name)
+	fmt.Fprintf(&b, "value:     %s\r\n", a.Metric.Format(a.Value))
+	fmt.Fprintf(&b, "threshold: %s\r\n", a.Metric.Format(a.Threshold))
+	fmt.Fprintf(&b, "active at: %s\r\n", a.ActiveAt.Format(time.RFC3339))
+	if !a.ResolvedAt.IsZero() {
+		fmt.Fprintf(&b, "resolved:  %s\r\n", a.ResolvedAt.Format(time.RFC3339))
+	}
+	return smtp.SendMail(s.Addr, nil, s.From, s.To, b.Bytes())
+}
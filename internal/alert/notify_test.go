@@ -0,0 +1,137 @@
+package alert
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+var testAlert = Alert{
+	Rule:      "download",
+	Expr:      "in_rate > 1MiB/s",
+	Metric:    InRate,
+	Subject:   "00:00:00:00:00:01",
+	IP:        "192.168.0.10",
+	Name:      "tv",
+	State:     Firing,
+	Value:     2 << 20,
+	Threshold: 1 << 20,
+	ActiveAt:  time.Now(),
+	FiredAt:   time.Now(),
+}
+
+func TestWebhook(t *testing.T) {
+	is := is.New(t)
+	received := make(chan Alert, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a Alert
+		is.NoErr(json.NewDecoder(r.Body).Decode(&a))
+		received <- a
+	}))
+	defer srv.Close()
+
+	is.NoErr(NewWebhook([]string{srv.URL}, 0, time.Millisecond).Notify(context.Background(), testAlert))
+	a := <-received
+	is.Equal(a.Rule, "download")
+	is.Equal(a.State, Firing)
+	is.Equal(a.Value, float64(2<<20))
+}
+
+func TestSyslog(t *testing.T) {
+	is := is.New(t)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	is.NoErr(err)
+	defer conn.Close()
+
+	s, err := NewSyslog("udp://" + conn.LocalAddr().String())
+	is.NoErr(err)
+	is.NoErr(s.Notify(context.Background(), testAlert))
+
+	buf := make([]byte, 1024)
+	is.NoErr(conn.SetReadDeadline(time.Now().Add(5 * time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	is.NoErr(err)
+	msg := string(buf[:n])
+	is.True(strings.HasPrefix(msg, fmt.Sprintf("<%d>", 3<<3|4))) // daemon.warning
+	is.True(strings.Contains(msg, "firing download 00:00:00:00:00:01 (tv)"))
+
+	_, err = NewSyslog("192.168.0.2:514")
+	is.True(err != nil)
+}
+
+// smtpServer is a minimal SMTP server that accepts one message and sends its
+// data to ch.
+func smtpServer(t *testing.T, ch chan<- string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { fmt.Fprintf(conn, "%s\r\n", s) }
+		reply("220 localhost")
+		var data strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"),
+				strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"):
+				reply("250 ok")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				reply("250 ok")
+				ch <- data.String()
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestSMTP(t *testing.T) {
+	is := is.New(t)
+	ch := make(chan string, 1)
+	s := &SMTP{
+		Addr: smtpServer(t, ch),
+		From: "natbwmon@localhost",
+		To:   []string{"admin@localhost"},
+	}
+	is.NoErr(s.Notify(context.Background(), testAlert))
+	msg := <-ch
+	is.True(strings.Contains(msg, "Subject: [natbwmon] FIRING download 00:00:00:00:00:01\r\n"))
+	is.True(strings.Contains(msg, "To: admin@localhost\r\n"))
+	is.True(strings.Contains(msg, "value:     2.00 MiB/s\r\n"))
+}
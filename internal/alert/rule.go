@@ -0,0 +1,233 @@
+package alert
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/some-programs/natbwmon/internal/clientstats"
+)
+
+// Metric is a value of a client that rules compare against a threshold.
+type Metric string
+
+const (
+	InRate        Metric = "in_rate"  // bytes per second to the client
+	OutRate       Metric = "out_rate" // bytes per second from the client
+	Rate          Metric = "rate"     // in_rate + out_rate
+	InBytesToday  Metric = "in_bytes_today"
+	OutBytesToday Metric = "out_bytes_today"
+	BytesToday    Metric = "bytes_today" // in_bytes_today + out_bytes_today
+)
+
+// IsRate returns true if the metric is in bytes per second.
+func (m Metric) IsRate() bool {
+	return m == InRate || m == OutRate || m == Rate
+}
+
+// Format formats a value of the metric.
+func (m Metric) Format(v float64) string {
+	if m.IsRate() {
+		return clientstats.FmtBytes(v, "/s")
+	}
+	return clientstats.FmtBytes(v, "")
+}
+
+// Rule is an alert rule, for example
+//
+//	download: in_rate > 50MiB/s for 2m
+//
+// alerts for every client that receives more than 50 MiB/s for two minutes.
+type Rule struct {
+	Name      string
+	Expr      string             // the rule as written, without the name
+	Filter    clientstats.Filter // the clients the rule applies to, all if empty
+	Metric    Metric
+	Total     bool // compare the sum of the metric of all matching clients
+	Op        string
+	Threshold float64
+	For       time.Duration // how long the condition must hold before the alert fires
+	Between   *TimeWindow   // when the rule is evaluated, always if nil
+}
+
+// compare returns true if v compared to the threshold satisfies the rule.
+func (r Rule) compare(v float64) bool {
+	switch r.Op {
+	case ">":
+		return v > r.Threshold
+	case ">=":
+		return v >= r.Threshold
+	case "<":
+		return v < r.Threshold
+	case "<=":
+		return v <= r.Threshold
+	}
+	return false
+}
+
+// TimeWindow is a daily period of local time in minutes since midnight. The
+// window wraps around midnight if From is after To.
+type TimeWindow struct {
+	From, To int
+}
+
+// Contains returns true if t is within the window.
+func (w TimeWindow) Contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if w.From <= w.To {
+		return m >= w.From && m < w.To
+	}
+	return m >= w.From || m < w.To
+}
+
+// ParseRules reads rules, one per line. Empty lines and lines starting with #
+// are ignored. See ParseRule for the format of a rule.
+func ParseRules(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+	names := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := ParseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("alert rules line %d: %w", n, err)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("alert rules line %d: duplicate rule name: %s", n, rule.Name)
+		}
+		names[rule.Name] = true
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// ParseRule parses a rule in the format
+//
+//	name: [ip=|hwaddr=|name=|group=VALUE ...] [total_]METRIC OP THRESHOLD [for DURATION] [between HH:MM-HH:MM]
+//
+// where METRIC is one of in_rate, out_rate, rate, in_bytes_today,
+// out_bytes_today or bytes_today, OP is one of >, >=, < or <= and THRESHOLD
+// is an amount of bytes with an optional unit (KB, MB, GB, TB, KiB, MiB, GiB
+// or TiB), followed by /s for rates. Ex:
+//
+//	download: in_rate > 50MiB/s for 2m
+//	night_upload: hwaddr=00:11:22:33:44:55 out_rate > 1MiB/s between 01:00-06:00
+//	wan_today: total_bytes_today > 20GiB
+func ParseRule(s string) (Rule, error) {
+	name, expr, ok := strings.Cut(s, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return Rule{}, fmt.Errorf("rule must start with a name followed by a colon: %s", s)
+	}
+	r := Rule{Name: name, Expr: strings.TrimSpace(expr)}
+	fields := strings.Fields(expr)
+
+	for len(fields) > 0 {
+		key, value, ok := strings.Cut(fields[0], "=")
+		if !ok {
+			break
+		}
+		switch key {
+		case "ip":
+			r.Filter.IPs = append(r.Filter.IPs, value)
+		case "hwaddr":
+			r.Filter.HWAddrs = append(r.Filter.HWAddrs, value)
+		case "name":
+			r.Filter.Names = append(r.Filter.Names, value)
+		case "group":
+			r.Filter.Groups = append(r.Filter.Groups, value)
+		default:
+			return Rule{}, fmt.Errorf("unknown client selector: %s", fields[0])
+		}
+		fields = fields[1:]
+	}
+
+	if len(fields) < 3 {
+		return Rule{}, fmt.Errorf("expected metric, operator and threshold: %s", expr)
+	}
+	metric, total := strings.CutPrefix(fields[0], "total_")
+	r.Metric, r.Total = Metric(metric), total
+	switch r.Metric {
+	case InRate, OutRate, Rate, InBytesToday, OutBytesToday, BytesToday:
+	default:
+		return Rule{}, fmt.Errorf("unknown metric: %s", fields[0])
+	}
+	switch fields[1] {
+	case ">", ">=", "<", "<=":
+		r.Op = fields[1]
+	default:
+		return Rule{}, fmt.Errorf("unknown operator: %s", fields[1])
+	}
+	var err error
+	if r.Threshold, err = parseAmount(fields[2], r.Metric.IsRate()); err != nil {
+		return Rule{}, err
+	}
+	fields = fields[3:]
+
+	for len(fields) > 0 {
+		if len(fields) < 2 {
+			return Rule{}, fmt.Errorf("missing value for %s", fields[0])
+		}
+		switch fields[0] {
+		case "for":
+			if r.For, err = time.ParseDuration(fields[1]); err != nil {
+				return Rule{}, err
+			}
+		case "between":
+			w, err := parseTimeWindow(fields[1])
+			if err != nil {
+				return Rule{}, err
+			}
+			r.Between = &w
+		default:
+			return Rule{}, fmt.Errorf("unexpected %s", fields[0])
+		}
+		fields = fields[2:]
+	}
+	return r, nil
+}
+
+// parseAmount parses an amount of bytes with an optional unit, rates must be
+// followed by /s.
+func parseAmount(s string, rate bool) (float64, error) {
+	v := s
+	if rate {
+		var ok bool
+		if v, ok = strings.CutSuffix(v, "/s"); !ok {
+			return 0, fmt.Errorf("rate threshold must end with /s: %s", s)
+		}
+	}
+	n, err := clientstats.ParseBytes(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid threshold: %w", err)
+	}
+	return n, nil
+}
+
+// parseTimeWindow parses HH:MM-HH:MM.
+func parseTimeWindow(s string) (TimeWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return TimeWindow{}, fmt.Errorf("expected HH:MM-HH:MM: %s", s)
+	}
+	var w TimeWindow
+	for _, v := range []struct {
+		s string
+		m *int
+	}{{from, &w.From}, {to, &w.To}} {
+		t, err := time.Parse("15:04", v.s)
+		if err != nil {
+			return TimeWindow{}, fmt.Errorf("invalid time: %s", v.s)
+		}
+		*v.m = t.Hour()*60 + t.Minute()
+	}
+	return w, nil
+}
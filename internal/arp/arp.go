@@ -1,9 +1,11 @@
-// Package arp parse ARP file from /proc/net/arp
+// Package arp parse ARP file from /proc/net/arp and IPv6 neighbors from `ip -6
+// neigh`.
 package arp
 
 import (
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"strings"
 )
@@ -69,6 +71,17 @@ func (as Entries) FilterDeviceName(name string) Entries {
 	return filtered
 }
 
+// FilterGlobalUnicast returns the entries with a global unicast IP address.
+func (as Entries) FilterGlobalUnicast() Entries {
+	filtered := make(Entries, 0, len(as))
+	for _, v := range as {
+		if ip := net.ParseIP(v.IPAddress); ip != nil && ip.IsGlobalUnicast() {
+			filtered = append(filtered, v)
+		}
+	}
+	return filtered
+}
+
 func (as Entries) HWAddrByIP() map[string]string {
 	m := make(map[string]string, len(as))
 	for _, v := range as {
@@ -12,6 +12,9 @@ import (
 //go:embed testdata/arp
 var testData []byte
 
+//go:embed testdata/neigh6
+var testDataNeigh6 []byte
+
 func TestParse(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
 		is := is.New(t)
@@ -37,6 +40,20 @@ func TestParse(t *testing.T) {
 	})
 }
 
+func TestParseNeigh(t *testing.T) {
+	is := is.New(t)
+	vs, err := ReadNeigh(bytes.NewReader(testDataNeigh6))
+	is.NoErr(err)
+	is.Equal(3, len(vs))
+	is.Equal("2001:db8:0:1::5", vs[0].IPAddress)
+	is.Equal("38:c9:86:2c:2f:97", vs[0].HWAddress)
+	is.Equal("br0", vs[0].Device)
+	is.Equal("REACHABLE", vs[0].Flags)
+	is.Equal("STALE", vs[1].Flags)
+	is.Equal(2, len(vs.FilterDeviceName("br0")))
+	is.Equal(1, len(vs.FilterGlobalUnicast()))
+}
+
 func TestGet(t *testing.T) {
 	if runtime.GOOS != "linux" {
 		t.Skip("only test on linux")
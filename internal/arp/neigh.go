@@ -0,0 +1,66 @@
+package arp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+// ReadNeigh parses IPv6 neighbor entries from the output of `ip -6 neigh
+// show`.
+//
+// Entries without a link layer address (INCOMPLETE, FAILED) are skipped.
+func ReadNeigh(r io.Reader) (Entries, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(data), "\n")
+	entries := make(Entries, 0, len(lines))
+	for _, line := range lines {
+		rows := strings.Fields(line)
+		if len(rows) == 0 {
+			continue
+		}
+		ip := net.ParseIP(rows[0])
+		if ip == nil {
+			return nil, fmt.Errorf("could not parse ip address: '%s'", line)
+		}
+		e := Entry{
+			IPAddress: ip.String(),
+			Flags:     rows[len(rows)-1],
+		}
+		for i := 1; i < len(rows)-1; i++ {
+			switch rows[i] {
+			case "dev":
+				e.Device = rows[i+1]
+				i++
+			case "lladdr":
+				e.HWAddress = rows[i+1]
+				i++
+			}
+		}
+		if e.HWAddress == "" {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// GetIPv6 lists the IPv6 neighbors that can have traffic forwarded through
+// this host, link local and multicast addresses are excluded.
+func GetIPv6() (Entries, error) {
+	out, err := exec.Command("ip", "-6", "neigh", "show").Output()
+	if err != nil {
+		return nil, fmt.Errorf("ip -6 neigh show: %w", err)
+	}
+	entries, err := ReadNeigh(bytes.NewReader(out))
+	if err != nil {
+		return nil, err
+	}
+	return entries.FilterGlobalUnicast(), nil
+}
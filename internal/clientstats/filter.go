@@ -0,0 +1,54 @@
+package clientstats
+
+import (
+	"net/url"
+	"slices"
+)
+
+// Filter selects clients. A client matches if it is on one of the interfaces
+// and matches any of the IP addresses, hardware addresses, names or groups.
+// Empty lists are ignored.
+type Filter struct {
+	Interfaces []string
+	IPs        []string
+	HWAddrs    []string
+	Names      []string
+	Groups     []string
+}
+
+// ParseFilter returns a filter from the interface, ip, hwaddr, name and group
+// query parameters.
+func ParseFilter(q url.Values) Filter {
+	return Filter{
+		Interfaces: q["interface"],
+		IPs:        q["ip"],
+		HWAddrs:    q["hwaddr"],
+		Names:      q["name"],
+		Groups:     q["group"],
+	}
+}
+
+// Match returns true if s matches the filter.
+func (f Filter) Match(s Stat) bool {
+	if len(f.Interfaces) > 0 && !slices.Contains(f.Interfaces, s.Interface) {
+		return false
+	}
+	if len(f.IPs) == 0 && len(f.HWAddrs) == 0 && len(f.Names) == 0 && len(f.Groups) == 0 {
+		return true
+	}
+	return slices.Contains(f.IPs, s.IP) ||
+		slices.Contains(f.Names, s.Name) ||
+		slices.Contains(f.HWAddrs, s.HWAddr) ||
+		(s.Group != "" && slices.Contains(f.Groups, s.Group))
+}
+
+// Filter returns the stats that match f.
+func (s Stats) Filter(f Filter) Stats {
+	res := make(Stats, 0, len(s))
+	for _, v := range s {
+		if f.Match(v) {
+			res = append(res, v)
+		}
+	}
+	return res
+}
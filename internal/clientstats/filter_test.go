@@ -0,0 +1,71 @@
+package clientstats
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestFilterMatch(t *testing.T) {
+	s := Stat{
+		IP:        "192.168.0.10",
+		HWAddr:    "00:00:00:00:00:01",
+		Name:      "tv",
+		Group:     "media",
+		Interface: "br0",
+	}
+	noGroup := s
+	noGroup.Group = ""
+
+	tests := []struct {
+		name   string
+		filter Filter
+		stat   Stat
+		match  bool
+	}{
+		{"empty", Filter{}, s, true},
+		{"ip", Filter{IPs: []string{"192.168.0.10"}}, s, true},
+		{"other ip", Filter{IPs: []string{"192.168.0.20"}}, s, false},
+		{"hwaddr", Filter{HWAddrs: []string{"00:00:00:00:00:01"}}, s, true},
+		{"name", Filter{Names: []string{"nas", "tv"}}, s, true},
+		{"group", Filter{Groups: []string{"media"}}, s, true},
+		{"other group", Filter{Groups: []string{"kids"}}, s, false},
+		{"empty group never matches", Filter{Groups: []string{""}}, noGroup, false},
+		{"any of ip or name", Filter{IPs: []string{"192.168.0.20"}, Names: []string{"tv"}}, s, true},
+		{"none of ip or group", Filter{IPs: []string{"192.168.0.20"}, Groups: []string{"kids"}}, s, false},
+		{"interface only", Filter{Interfaces: []string{"br0"}}, s, true},
+		{"other interface only", Filter{Interfaces: []string{"wg0"}}, s, false},
+		{"interface and ip", Filter{Interfaces: []string{"br0"}, IPs: []string{"192.168.0.10"}}, s, true},
+		{"interface and other ip", Filter{Interfaces: []string{"br0"}, IPs: []string{"192.168.0.20"}}, s, false},
+		{"other interface and ip", Filter{Interfaces: []string{"wg0"}, IPs: []string{"192.168.0.10"}}, s, false},
+		{"any interface and group", Filter{Interfaces: []string{"wg0", "br0"}, Groups: []string{"media"}}, s, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(tt.filter.Match(tt.stat), tt.match)
+		})
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	is := is.New(t)
+	q, err := url.ParseQuery("interface=br0&ip=192.168.0.10&ip=192.168.0.20&hwaddr=00:00:00:00:00:01&name=tv&group=media")
+	is.NoErr(err)
+	f := ParseFilter(q)
+	is.Equal(f.Interfaces, []string{"br0"})
+	is.Equal(f.IPs, []string{"192.168.0.10", "192.168.0.20"})
+	is.Equal(f.HWAddrs, []string{"00:00:00:00:00:01"})
+	is.Equal(f.Names, []string{"tv"})
+	is.Equal(f.Groups, []string{"media"})
+
+	stats := Stats{
+		{IP: "192.168.0.10", Interface: "br0"},
+		{IP: "192.168.0.20", Interface: "wg0"},
+		{IP: "192.168.0.30", Interface: "br0"},
+	}
+	res := stats.Filter(f)
+	is.Equal(len(res), 1)
+	is.Equal(res[0].IP, "192.168.0.10")
+}
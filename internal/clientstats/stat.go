@@ -2,16 +2,63 @@ package clientstats
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Stat
 type Stat struct {
-	IP           string  `json:"ip"`
-	Name         string  `json:"name"`
-	HWAddr       string  `json:"hwaddr"`
-	InRate       float64 `json:"in_rate"`
-	OutRate      float64 `json:"out_rate"`
-	Manufacturer string  `json:"manufacturer"`
+	IP           string   `json:"ip"`
+	IP6          []string `json:"ip6"`
+	Name         string   `json:"name"`
+	HWAddr       string   `json:"hwaddr"`
+	Interface    string   `json:"interface"`
+	Group        string   `json:"group"`
+	InRate       float64  `json:"in_rate"`
+	OutRate      float64  `json:"out_rate"`
+	InRateV4     float64  `json:"in_rate_v4"`
+	OutRateV4    float64  `json:"out_rate_v4"`
+	InRateV6     float64  `json:"in_rate_v6"`
+	OutRateV6    float64  `json:"out_rate_v6"`
+	Manufacturer string   `json:"manufacturer"`
+
+	InPacketRate  float64 `json:"in_packet_rate"`
+	OutPacketRate float64 `json:"out_packet_rate"`
+
+	// total traffic since the client was first seen
+	InBytes    uint64 `json:"in_bytes"`
+	OutBytes   uint64 `json:"out_bytes"`
+	InPackets  uint64 `json:"in_packets"`
+	OutPackets uint64 `json:"out_packets"`
+
+	// traffic since PeriodStart which is the last reset or when the client
+	// was first seen
+	PeriodStart      time.Time `json:"period_start"`
+	PeriodInBytes    uint64    `json:"period_in_bytes"`
+	PeriodOutBytes   uint64    `json:"period_out_bytes"`
+	PeriodInPackets  uint64    `json:"period_in_packets"`
+	PeriodOutPackets uint64    `json:"period_out_packets"`
+
+	// when the client was first seen and when it last had traffic or was
+	// seen as a neighbor
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+
+	// the data quota of the client that is closest to being used up, nil if
+	// no quota applies
+	Quota *Quota `json:"quota,omitempty"`
+}
+
+// Quota is the consumption of a data quota.
+type Quota struct {
+	Name     string    `json:"name"`
+	Period   string    `json:"period"` // day or month
+	Start    time.Time `json:"start"`
+	Limit    uint64    `json:"limit"`
+	Used     uint64    `json:"used"`
+	Exceeded bool      `json:"exceeded"`
+	Enforced bool      `json:"enforced"` // true if the client is restricted
 }
 
 func (s Stat) HWAddrPrefix() string {
@@ -29,6 +76,14 @@ func (s Stat) OutFmt() string {
 	return FmtBytes(s.OutRate, "/s")
 }
 
+func (s Stat) PeriodInFmt() string {
+	return FmtBytes(float64(s.PeriodInBytes), "")
+}
+
+func (s Stat) PeriodOutFmt() string {
+	return FmtBytes(float64(s.PeriodOutBytes), "")
+}
+
 func FmtBytes(b float64, suffix string) string {
 	if b < 0.01 {
 		return ""
@@ -45,3 +100,35 @@ func FmtBytes(b float64, suffix string) string {
 	return fmt.Sprintf("%.2f %ciB%s",
 		float64(b)/float64(div), "KMGTPE"[exp], suffix)
 }
+
+var byteUnits = map[string]float64{
+	"":    1,
+	"B":   1,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+	"TiB": 1 << 40,
+}
+
+// ParseBytes parses an amount of bytes with an optional unit, ex: 1.5GiB.
+func ParseBytes(s string) (float64, error) {
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+	unit, ok := byteUnits[s[i:]]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit: %s", s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount: %s", s)
+	}
+	return n * unit, nil
+}
@@ -8,6 +8,38 @@ import (
 
 type Stats []Stat
 
+// OrderBy orders the stats by IP address and then by the named order, one of
+// rate_in, rate_out, bytes_in, bytes_out, period_in, period_out, hwaddr,
+// name, manufacturer, interface or group. Unknown orders are ignored.
+func (s Stats) OrderBy(orderBy string) {
+	s.OrderByIP()
+	switch orderBy {
+	case "rate_in":
+		s.OrderByInRate()
+	case "rate_out":
+		s.OrderByOutRate()
+	case "bytes_in":
+		s.OrderByInBytes()
+	case "bytes_out":
+		s.OrderByOutBytes()
+	case "period_in":
+		s.OrderByPeriodInBytes()
+	case "period_out":
+		s.OrderByPeriodOutBytes()
+	case "hwaddr":
+		s.OrderByHWAddr()
+	case "name":
+		s.OrderByName()
+	case "manufacturer":
+		s.OrderByHWAddr()
+		s.OrderByManufacturer()
+	case "interface":
+		s.OrderByInterface()
+	case "group":
+		s.OrderByGroup()
+	}
+}
+
 func (s Stats) OrderByIP() {
 	sort.SliceStable(s, func(i, j int) bool {
 		return bytes.Compare(net.ParseIP(s[i].IP), net.ParseIP(s[j].IP)) < 0
@@ -22,6 +54,22 @@ func (s Stats) OrderByOutRate() {
 	sort.SliceStable(s, func(i, j int) bool { return s[i].OutRate > s[j].OutRate })
 }
 
+func (s Stats) OrderByInBytes() {
+	sort.SliceStable(s, func(i, j int) bool { return s[i].InBytes > s[j].InBytes })
+}
+
+func (s Stats) OrderByOutBytes() {
+	sort.SliceStable(s, func(i, j int) bool { return s[i].OutBytes > s[j].OutBytes })
+}
+
+func (s Stats) OrderByPeriodInBytes() {
+	sort.SliceStable(s, func(i, j int) bool { return s[i].PeriodInBytes > s[j].PeriodInBytes })
+}
+
+func (s Stats) OrderByPeriodOutBytes() {
+	sort.SliceStable(s, func(i, j int) bool { return s[i].PeriodOutBytes > s[j].PeriodOutBytes })
+}
+
 func (s Stats) OrderByHWAddr() {
 	sort.SliceStable(s, func(i, j int) bool { return s[i].HWAddr < s[j].HWAddr })
 }
@@ -49,3 +97,19 @@ func (s Stats) OrderByManufacturer() {
 		return s[i].Manufacturer < s[j].Manufacturer
 	})
 }
+
+func (s Stats) OrderByInterface() {
+	sort.SliceStable(s, func(i, j int) bool { return s[i].Interface < s[j].Interface })
+}
+
+func (s Stats) OrderByGroup() {
+	sort.SliceStable(s, func(i, j int) bool {
+		if s[i].Group == "" && s[j].Group != "" {
+			return false
+		}
+		if s[i].Group != "" && s[j].Group == "" {
+			return true
+		}
+		return s[i].Group < s[j].Group
+	})
+}
@@ -0,0 +1,96 @@
+// Package events distributes client and device events, such as a device
+// joining the network, to the parts of the application that are interested in
+// them.
+package events
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/some-programs/natbwmon/internal/clientstats"
+)
+
+// Type is the kind of an event.
+type Type string
+
+const (
+	Join  Type = "join"  // a client was seen for the first time
+	Leave Type = "leave" // a client was expired after being idle
+)
+
+// Device events are about devices in the inventory, which are identified by
+// their hardware address and outlive the clients they are seen as.
+const (
+	DeviceNew       Type = "device.new"        // a hardware address was seen for the first time
+	DeviceOnline    Type = "device.online"     // a known device was seen again after being offline
+	DeviceOffline   Type = "device.offline"    // a device has not been seen for a while
+	DeviceIPChanged Type = "device.ip_changed" // a device has a new IPv4 address
+)
+
+// types are all event types.
+var types = []Type{Join, Leave, DeviceNew, DeviceOnline, DeviceOffline, DeviceIPChanged}
+
+// ParseType returns the event type named s.
+func ParseType(s string) (Type, error) {
+	if t := Type(s); slices.Contains(types, t) {
+		return t, nil
+	}
+	return "", fmt.Errorf("unknown event type: %s", s)
+}
+
+// Event is something that happened to a client or device.
+type Event struct {
+	Type   Type             `json:"type"`
+	Time   time.Time        `json:"time"`
+	Client clientstats.Stat `json:"client"`
+	PrevIP string           `json:"prev_ip,omitempty"` // the previous address for DeviceIPChanged
+}
+
+// Bus delivers published events to all subscribers. A nil Bus discards
+// published events and has no events to subscribe to.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[chan Event]struct{})}
+}
+
+// Publish sends the event to all subscribers without blocking, subscribers
+// that are not keeping up miss the event.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel with room for size events that receives the
+// published events until cancel is called.
+func (b *Bus) Subscribe(size int) (events <-chan Event, cancel func()) {
+	if b == nil {
+		return nil, func() {}
+	}
+	ch := make(chan Event, size)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+		})
+	}
+}
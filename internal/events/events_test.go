@@ -0,0 +1,52 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/some-programs/natbwmon/internal/clientstats"
+)
+
+func TestBus(t *testing.T) {
+	is := is.New(t)
+	b := NewBus()
+	a, cancelA := b.Subscribe(1)
+	c, cancelC := b.Subscribe(2)
+	defer cancelC()
+
+	e := Event{Type: Join, Time: time.Now(), Client: clientstats.Stat{IP: "192.168.0.2"}}
+	b.Publish(e)
+	is.Equal((<-a).Client.IP, "192.168.0.2")
+	is.Equal((<-c).Type, Join)
+
+	// a full subscriber misses events instead of blocking the publisher.
+	b.Publish(Event{Type: Leave})
+	b.Publish(Event{Type: Join})
+	is.Equal((<-a).Type, Leave)
+	is.Equal(len(a), 0)
+	is.Equal(len(c), 2)
+
+	cancelA()
+	cancelA()
+	b.Publish(e)
+	is.Equal(len(a), 0)
+}
+
+func TestNilBus(t *testing.T) {
+	is := is.New(t)
+	var b *Bus
+	b.Publish(Event{Type: Join})
+	ch, cancel := b.Subscribe(1)
+	defer cancel()
+	is.True(ch == nil)
+}
+
+func TestParseType(t *testing.T) {
+	is := is.New(t)
+	typ, err := ParseType("device.new")
+	is.NoErr(err)
+	is.Equal(typ, DeviceNew)
+	_, err = ParseType("device.unknown")
+	is.True(err != nil)
+}
@@ -0,0 +1,126 @@
+// Package geoip looks up the country and autonomous system of addresses in
+// local MaxMind format (mmdb) databases such as GeoLite2-Country and
+// GeoLite2-ASN.
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Info is what is known about an address, fields missing from the databases
+// are empty.
+type Info struct {
+	Country string `json:"country,omitempty"` // ISO 3166-1 country code
+	ASN     uint   `json:"asn,omitempty"`
+	ASOrg   string `json:"as_org,omitempty"`
+}
+
+// String returns the info as text like "US AS15169 Google LLC".
+func (i Info) String() string {
+	var ss []string
+	if i.Country != "" {
+		ss = append(ss, i.Country)
+	}
+	if i.ASN != 0 {
+		ss = append(ss, fmt.Sprintf("AS%d", i.ASN))
+	}
+	if i.ASOrg != "" {
+		ss = append(ss, i.ASOrg)
+	}
+	return strings.Join(ss, " ")
+}
+
+// record is the subset of the country, city and ASN database records that is
+// used.
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	ASN   uint   `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+// DB looks up addresses in a set of databases, the first database that has a
+// value for a field is used. A nil DB finds nothing.
+type DB struct {
+	paths []string
+
+	mu      sync.RWMutex
+	readers []*maxminddb.Reader
+}
+
+// Open opens the databases at paths.
+func Open(paths ...string) (*DB, error) {
+	db := &DB{paths: paths}
+	if err := db.Reload(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Reload reopens the database files, the previously opened databases are
+// kept if any of them fails to open.
+func (db *DB) Reload() error {
+	readers := make([]*maxminddb.Reader, 0, len(db.paths))
+	for _, path := range db.paths {
+		r, err := maxminddb.Open(path)
+		if err != nil {
+			for _, r := range readers {
+				r.Close()
+			}
+			return fmt.Errorf("open geoip database: %w", err)
+		}
+		readers = append(readers, r)
+	}
+	db.mu.Lock()
+	old := db.readers
+	db.readers = readers
+	db.mu.Unlock()
+	return closeAll(old)
+}
+
+// Lookup returns the info about ip.
+func (db *DB) Lookup(ip net.IP) Info {
+	var info Info
+	if db == nil || ip == nil {
+		return info
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for _, r := range db.readers {
+		var rec record
+		if err := r.Lookup(ip, &rec); err != nil {
+			continue
+		}
+		if info.Country == "" {
+			info.Country = rec.Country.ISOCode
+		}
+		if info.ASN == 0 {
+			info.ASN, info.ASOrg = rec.ASN, rec.ASOrg
+		}
+	}
+	return info
+}
+
+// Close closes the databases.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	err := closeAll(db.readers)
+	db.readers = nil
+	return err
+}
+
+func closeAll(readers []*maxminddb.Reader) error {
+	var errs []error
+	for _, r := range readers {
+		errs = append(errs, r.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,97 @@
+package geoip
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+// mmdb encodes the subset of the MaxMind DB data types used by the tests.
+type mmdb []byte
+
+func (b mmdb) str(s string) mmdb {
+	if len(s) < 29 {
+		b = append(b, 2<<5|byte(len(s)))
+	} else {
+		b = append(b, 2<<5|29, byte(len(s)-29))
+	}
+	return append(b, s...)
+}
+
+func (b mmdb) uint(typ byte, v uint32) mmdb {
+	var bs []byte
+	for ; v > 0; v >>= 8 {
+		bs = append([]byte{byte(v)}, bs...)
+	}
+	return append(append(b, typ<<5|byte(len(bs))), bs...)
+}
+
+func (b mmdb) mapHeader(n int) mmdb {
+	return append(b, 7<<5|byte(n))
+}
+
+// writeDB writes an IPv4 database where the addresses in 1.0.0.0/8 have the
+// record data and no other addresses are found.
+func writeDB(t *testing.T, path string, data mmdb) {
+	t.Helper()
+	const nodeCount = 8
+	var tree []byte
+	record := func(v uint32) {
+		tree = append(tree, byte(v>>16), byte(v>>8), byte(v))
+	}
+	for i := range uint32(nodeCount) {
+		// the path to the data follows the bits of 00000001.
+		if i < nodeCount-1 {
+			record(i + 1)
+			record(nodeCount)
+		} else {
+			record(nodeCount)
+			record(nodeCount + 16)
+		}
+	}
+	buf := append(tree, make([]byte, 16)...)
+	buf = append(buf, data...)
+	buf = append(buf, "\xAB\xCD\xEFMaxMind.com"...)
+	meta := mmdb{}.mapHeader(3).
+		str("node_count").uint(6, nodeCount).
+		str("record_size").uint(5, 24).
+		str("ip_version").uint(5, 4)
+	buf = append(buf, meta...)
+	if err := os.WriteFile(path, buf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDB(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	country := filepath.Join(dir, "country.mmdb")
+	asn := filepath.Join(dir, "asn.mmdb")
+	writeDB(t, country, mmdb{}.mapHeader(1).str("country").mapHeader(1).str("iso_code").str("AU"))
+	writeDB(t, asn, mmdb{}.mapHeader(2).
+		str("autonomous_system_number").uint(6, 13335).
+		str("autonomous_system_organization").str("Cloudflare"))
+
+	db, err := Open(country, asn)
+	is.NoErr(err)
+	defer db.Close()
+	info := db.Lookup(net.ParseIP("1.1.1.1"))
+	is.Equal(Info{Country: "AU", ASN: 13335, ASOrg: "Cloudflare"}, info)
+	is.Equal("AU AS13335 Cloudflare", info.String())
+	is.Equal(Info{}, db.Lookup(net.ParseIP("8.8.8.8")))
+
+	writeDB(t, country, mmdb{}.mapHeader(1).str("country").mapHeader(1).str("iso_code").str("NZ"))
+	is.NoErr(db.Reload())
+	is.Equal("NZ", db.Lookup(net.ParseIP("1.1.1.1")).Country)
+
+	// a failed reload keeps the open databases
+	is.NoErr(os.Remove(asn))
+	is.True(db.Reload() != nil)
+	is.Equal(uint(13335), db.Lookup(net.ParseIP("1.1.1.1")).ASN)
+
+	var nilDB *DB
+	is.Equal(Info{}, nilDB.Lookup(net.ParseIP("1.1.1.1")))
+}
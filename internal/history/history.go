@@ -0,0 +1,490 @@
+// Package history is an embedded append only store for per client traffic
+// over time.
+//
+// Samples are kept in a number of tiers with different resolutions and
+// retention times, for example every 10 seconds for a day and every hour for
+// a year. Each tier is downsampled from the live data and stored as segment
+// files in its own directory. Segments older than the retention time of the
+// tier are removed and the oldest segments of the finest tiers are removed
+// first if the store grows larger than the configured maximum size.
+package history
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/some-programs/natbwmon/internal/clientstats"
+	"github.com/some-programs/natbwmon/internal/log"
+)
+
+// segmentSteps is the number of steps of a tier that are stored in one
+// segment file.
+const segmentSteps = 360
+
+// Series identifies the client a sample belongs to. A client is identified
+// by its IP and hardware address, Name is only the latest name of the client
+// so that a renamed client keeps its series.
+type Series struct {
+	IP     string `json:"ip"`
+	HWAddr string `json:"hwaddr"`
+	Name   string `json:"name"`
+}
+
+func (s Series) key() clientKey {
+	return clientKey{ip: s.IP, hwaddr: s.HWAddr}
+}
+
+// Counts is an amount of traffic.
+type Counts struct {
+	InBytes    uint64 `json:"in_bytes"`
+	OutBytes   uint64 `json:"out_bytes"`
+	InPackets  uint64 `json:"in_packets"`
+	OutPackets uint64 `json:"out_packets"`
+}
+
+func (c Counts) add(o Counts) Counts {
+	return Counts{
+		InBytes:    c.InBytes + o.InBytes,
+		OutBytes:   c.OutBytes + o.OutBytes,
+		InPackets:  c.InPackets + o.InPackets,
+		OutPackets: c.OutPackets + o.OutPackets,
+	}
+}
+
+// since returns the traffic counted since prev. If any counter is smaller
+// than in prev the counters have been reset and c is returned.
+func (c Counts) since(prev Counts) Counts {
+	if c.InBytes < prev.InBytes || c.OutBytes < prev.OutBytes ||
+		c.InPackets < prev.InPackets || c.OutPackets < prev.OutPackets {
+		return c
+	}
+	return Counts{
+		InBytes:    c.InBytes - prev.InBytes,
+		OutBytes:   c.OutBytes - prev.OutBytes,
+		InPackets:  c.InPackets - prev.InPackets,
+		OutPackets: c.OutPackets - prev.OutPackets,
+	}
+}
+
+// Sample is the traffic of a client during the step starting at Time.
+type Sample struct {
+	Time time.Time `json:"time"`
+	Series
+	Counts
+}
+
+// Tier is a resolution that samples are stored at and for how long they are
+// kept.
+type Tier struct {
+	Step      time.Duration
+	Retention time.Duration
+}
+
+func (t Tier) String() string {
+	return fmt.Sprintf("%s:%s", t.Step, t.Retention)
+}
+
+// ParseTiers parses a comma separated list of step:retention pairs, ex:
+// 10s:24h,5m:720h,1h:8760h.
+func ParseTiers(s string) ([]Tier, error) {
+	var tiers []Tier
+	for _, v := range strings.Split(s, ",") {
+		step, retention, ok := strings.Cut(strings.TrimSpace(v), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid history tier, expected step:retention: %s", v)
+		}
+		var t Tier
+		var err error
+		if t.Step, err = time.ParseDuration(step); err != nil {
+			return nil, err
+		}
+		if t.Retention, err = time.ParseDuration(retention); err != nil {
+			return nil, err
+		}
+		if t.Step < time.Second || t.Retention < t.Step {
+			return nil, fmt.Errorf("invalid history tier: %s", v)
+		}
+		tiers = append(tiers, t)
+	}
+	slices.SortFunc(tiers, func(a, b Tier) int { return int(a.Step - b.Step) })
+	return tiers, nil
+}
+
+// Store records client traffic into tiers of segment files.
+type Store struct {
+	dir     string
+	maxSize int64
+
+	mu    sync.Mutex
+	tiers []*tier
+	last  map[clientKey]Counts // last recorded totals
+	prev  time.Time            // time of the previous record
+}
+
+// clientKey identifies a client between records.
+type clientKey struct {
+	ip, hwaddr string
+}
+
+// tier is the state of a single tier of the store.
+type tier struct {
+	Tier
+	dir    string
+	bucket time.Time             // start of the step being accumulated
+	acc    map[clientKey]*Sample // traffic during the current step
+	seg    *segmentWriter
+}
+
+// Open opens or creates a store in dir. maxSize is the maximum total size of
+// the segment files in bytes, 0 means no limit.
+func Open(dir string, tiers []Tier, maxSize int64) (*Store, error) {
+	if len(tiers) == 0 {
+		return nil, fmt.Errorf("no history tiers")
+	}
+	s := &Store{
+		dir:     dir,
+		maxSize: maxSize,
+		last:    make(map[clientKey]Counts),
+	}
+	for _, t := range tiers {
+		td := filepath.Join(dir, t.Step.String())
+		if err := os.MkdirAll(td, 0o755); err != nil {
+			return nil, err
+		}
+		s.tiers = append(s.tiers, &tier{
+			Tier: t,
+			dir:  td,
+			acc:  make(map[clientKey]*Sample),
+		})
+	}
+	return s, nil
+}
+
+// Interval returns how often Record should be called, the step of the finest
+// tier.
+func (s *Store) Interval() time.Duration {
+	return s.tiers[0].Step
+}
+
+// Record records the traffic counted since the previous call from the client
+// totals in stats.
+//
+// The IP and hardware address of a client can change, such as when its
+// hardware address becomes known or an IPv4 address replaces an IPv6 primary
+// address. The totals of a client that is new to the store are only recorded
+// if the client was first seen after the previous call, otherwise its traffic
+// has already been recorded under another series and only its current totals
+// are kept to count from.
+func (s *Store) Record(t time.Time, stats clientstats.Stats) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev := s.prev
+	s.prev = t
+
+	seen := make(map[clientKey]bool, len(stats))
+	var errs []error
+	for _, st := range stats {
+		k := clientKey{ip: st.IP, hwaddr: st.HWAddr}
+		seen[k] = true
+		cur := Counts{
+			InBytes:    st.InBytes,
+			OutBytes:   st.OutBytes,
+			InPackets:  st.InPackets,
+			OutPackets: st.OutPackets,
+		}
+		last, ok := s.last[k]
+		s.last[k] = cur
+		if !ok && !prev.IsZero() && !st.FirstSeen.After(prev) {
+			continue
+		}
+		d := cur.since(last)
+		if d == (Counts{}) {
+			continue
+		}
+		series := Series{IP: st.IP, HWAddr: st.HWAddr, Name: st.Name}
+		for _, tr := range s.tiers {
+			if err := s.add(tr, t, series, d); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	for k := range s.last {
+		if !seen[k] {
+			delete(s.last, k)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// add adds c to the step of the tier that t is in. The previous step is
+// written to disk when t is in a later step.
+func (s *Store) add(tr *tier, t time.Time, series Series, c Counts) error {
+	bucket := t.Truncate(tr.Step)
+	var err error
+	if bucket.After(tr.bucket) {
+		err = s.flush(tr)
+		tr.bucket = bucket
+	}
+	v, ok := tr.acc[series.key()]
+	if !ok {
+		v = &Sample{Time: tr.bucket}
+		tr.acc[series.key()] = v
+	}
+	v.Series = series
+	v.Counts = v.Counts.add(c)
+	return err
+}
+
+// flush writes the accumulated step of the tier.
+func (s *Store) flush(tr *tier) error {
+	if len(tr.acc) == 0 {
+		return nil
+	}
+	acc := tr.acc
+	tr.acc = make(map[clientKey]*Sample)
+	segDur := tr.Step * segmentSteps
+	start := tr.bucket.Truncate(segDur)
+	if tr.seg == nil || !tr.seg.start.Equal(start) {
+		if tr.seg != nil {
+			if err := tr.seg.close(); err != nil {
+				log.Warn().Err(err).Msg("close history segment")
+			}
+			tr.seg = nil
+		}
+		seg, err := openSegment(segmentPath(tr.dir, start), start)
+		if err != nil {
+			return err
+		}
+		tr.seg = seg
+		s.cleanup()
+	}
+	for _, v := range acc {
+		if err := tr.seg.write(v.Time, v.Series, v.Counts); err != nil {
+			return err
+		}
+	}
+	return tr.seg.flush()
+}
+
+// Close writes all accumulated steps and closes the segment files.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for _, tr := range s.tiers {
+		if err := s.flush(tr); err != nil {
+			errs = append(errs, err)
+		}
+		if tr.seg != nil {
+			if err := tr.seg.close(); err != nil {
+				errs = append(errs, err)
+			}
+			tr.seg = nil
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// segmentFile is a segment file on disk.
+type segmentFile struct {
+	path  string
+	start time.Time
+	size  int64
+}
+
+func segmentPath(dir string, start time.Time) string {
+	return filepath.Join(dir, fmt.Sprintf("%d.seg", start.Unix()))
+}
+
+// segments returns the segment files of the tier ordered by start time.
+func (tr *tier) segments() ([]segmentFile, error) {
+	entries, err := os.ReadDir(tr.dir)
+	if err != nil {
+		return nil, err
+	}
+	var segs []segmentFile
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".seg")
+		if !ok || e.IsDir() {
+			continue
+		}
+		ts, err := strconv.ParseInt(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		segs = append(segs, segmentFile{
+			path:  filepath.Join(tr.dir, e.Name()),
+			start: time.Unix(ts, 0),
+			size:  info.Size(),
+		})
+	}
+	slices.SortFunc(segs, func(a, b segmentFile) int { return a.start.Compare(b.start) })
+	return segs, nil
+}
+
+// cleanup removes segments that are past the retention time of their tier
+// and the oldest segments of the finest tiers while the store is larger than
+// the maximum size. The open segments are never removed.
+func (s *Store) cleanup() {
+	now := time.Now()
+	var size int64
+	tierSegs := make([][]segmentFile, len(s.tiers))
+	for i, tr := range s.tiers {
+		segs, err := tr.segments()
+		if err != nil {
+			log.Warn().Err(err).Msg("list history segments")
+			continue
+		}
+		for _, seg := range segs {
+			end := seg.start.Add(tr.Step * segmentSteps)
+			if now.Sub(end) > tr.Retention && !s.isOpen(tr, seg) {
+				s.remove(seg)
+				continue
+			}
+			size += seg.size
+			tierSegs[i] = append(tierSegs[i], seg)
+		}
+	}
+	if s.maxSize <= 0 {
+		return
+	}
+	for i, tr := range s.tiers {
+		for _, seg := range tierSegs[i] {
+			if size <= s.maxSize {
+				return
+			}
+			if s.isOpen(tr, seg) {
+				continue
+			}
+			s.remove(seg)
+			size -= seg.size
+		}
+	}
+}
+
+func (s *Store) isOpen(tr *tier, seg segmentFile) bool {
+	return tr.seg != nil && tr.seg.start.Equal(seg.start)
+}
+
+func (s *Store) remove(seg segmentFile) {
+	if err := os.Remove(seg.path); err != nil {
+		log.Warn().Err(err).Msg("remove history segment")
+		return
+	}
+	log.Debug().Str("path", seg.path).Msg("removed history segment")
+}
+
+// Query returns the traffic of every client per step between from and to and
+// the step that was used.
+//
+// The samples are read from the finest tier that still covers from and are
+// summed up to step which is rounded up to a multiple of the step of the
+// tier. A step of 0 uses the step of the tier.
+func (s *Store) Query(from, to time.Time, step time.Duration) ([]Sample, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tr := s.tierFor(from, step)
+	if step < tr.Step {
+		step = tr.Step
+	}
+	step = (step + tr.Step - 1) / tr.Step * tr.Step
+
+	type key struct {
+		t      int64
+		client clientKey
+	}
+	acc := make(map[key]*Sample)
+	add := func(sample Sample) {
+		if sample.Time.Before(from) || !sample.Time.Before(to) {
+			return
+		}
+		t := from.Add(sample.Time.Sub(from) / step * step)
+		k := key{t: t.Unix(), client: sample.key()}
+		v, ok := acc[k]
+		if !ok {
+			v = &Sample{Time: t, Series: sample.Series}
+			acc[k] = v
+		}
+		v.Name = sample.Name
+		v.Counts = v.Counts.add(sample.Counts)
+	}
+
+	segs, err := tr.segments()
+	if err != nil {
+		return nil, 0, err
+	}
+	segDur := tr.Step * segmentSteps
+	for _, seg := range segs {
+		if !seg.start.Add(segDur).After(from) || !seg.start.Before(to) {
+			continue
+		}
+		if tr.seg != nil && tr.seg.start.Equal(seg.start) {
+			if err := tr.seg.flush(); err != nil {
+				return nil, 0, err
+			}
+		}
+		f, err := os.Open(seg.path)
+		if err != nil {
+			return nil, 0, err
+		}
+		_, err = readSegment(f, nil, add)
+		f.Close()
+		if err != nil {
+			return nil, 0, fmt.Errorf("read segment %s: %w", seg.path, err)
+		}
+	}
+	for _, v := range tr.acc {
+		add(*v)
+	}
+
+	res := make([]Sample, 0, len(acc))
+	for _, v := range acc {
+		res = append(res, *v)
+	}
+	slices.SortFunc(res, func(a, b Sample) int {
+		if c := a.Time.Compare(b.Time); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.IP, b.IP); c != 0 {
+			return c
+		}
+		return strings.Compare(a.HWAddr, b.HWAddr)
+	})
+	return res, step, nil
+}
+
+// tierFor returns the tier to read samples from.
+func (s *Store) tierFor(from time.Time, step time.Duration) *tier {
+	age := time.Since(from)
+	var covering []*tier
+	for _, tr := range s.tiers {
+		if age <= tr.Retention {
+			covering = append(covering, tr)
+		}
+	}
+	if len(covering) == 0 {
+		return slices.MaxFunc(s.tiers, func(a, b *tier) int {
+			return int(a.Retention - b.Retention)
+		})
+	}
+	res := covering[0]
+	for _, tr := range covering {
+		if tr.Step <= step {
+			res = tr
+		}
+	}
+	return res
+}
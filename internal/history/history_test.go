@@ -0,0 +1,150 @@
+package history
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/some-programs/natbwmon/internal/clientstats"
+)
+
+func TestStore(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	tiers, err := ParseTiers("1m:24h,10s:1h")
+	is.NoErr(err)
+	is.Equal(10*time.Second, tiers[0].Step)
+
+	s, err := Open(dir, tiers, 0)
+	is.NoErr(err)
+
+	start := time.Now().Truncate(time.Minute).Add(-10 * time.Minute)
+	stat := func(in, out uint64) clientstats.Stats {
+		return clientstats.Stats{{IP: "192.168.0.2", HWAddr: "00:00:00:00:00:01", InBytes: in, OutBytes: out}}
+	}
+	is.NoErr(s.Record(start, stat(100, 10)))
+	is.NoErr(s.Record(start.Add(10*time.Second), stat(300, 20)))
+	is.NoErr(s.Record(start.Add(70*time.Second), stat(600, 30)))
+	is.NoErr(s.Close())
+
+	// reopen and read back from the 10s tier
+	s, err = Open(dir, tiers, 0)
+	is.NoErr(err)
+	samples, _, err := s.Query(start, start.Add(time.Hour), 0)
+	is.NoErr(err)
+	is.Equal(3, len(samples))
+	is.Equal(uint64(100), samples[0].InBytes)
+	is.Equal(uint64(200), samples[1].InBytes)
+	is.Equal(uint64(300), samples[2].InBytes)
+
+	// summed up per minute
+	samples, step, err := s.Query(start, start.Add(time.Hour), time.Minute)
+	is.NoErr(err)
+	is.Equal(time.Minute, step)
+	is.Equal(2, len(samples))
+	is.Equal(uint64(300), samples[0].InBytes)
+	is.Equal(uint64(20), samples[0].OutBytes)
+	is.Equal(uint64(300), samples[1].InBytes)
+
+	// a truncated record at the end of a segment is ignored
+	segs, err := s.tiers[0].segments()
+	is.NoErr(err)
+	f, err := os.OpenFile(segs[0].path, os.O_APPEND|os.O_WRONLY, 0)
+	is.NoErr(err)
+	_, err = f.Write([]byte{recSample, 0})
+	is.NoErr(err)
+	is.NoErr(f.Close())
+	samples, _, err = s.Query(start, start.Add(time.Hour), 0)
+	is.NoErr(err)
+	is.Equal(3, len(samples))
+	is.NoErr(s.Close())
+}
+
+func TestStoreRename(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	tiers, err := ParseTiers("10s:1h")
+	is.NoErr(err)
+	s, err := Open(dir, tiers, 0)
+	is.NoErr(err)
+
+	start := time.Now().Truncate(time.Minute).Add(-10 * time.Minute)
+	stat := func(name string, in uint64) clientstats.Stats {
+		return clientstats.Stats{{IP: "192.168.0.2", HWAddr: "00:00:00:00:00:01", Name: name, InBytes: in}}
+	}
+	is.NoErr(s.Record(start, stat("tv", 100)))
+	is.NoErr(s.Record(start.Add(10*time.Second), stat("tv", 200)))
+	is.NoErr(s.Record(start.Add(20*time.Second), stat("living-room-tv", 300)))
+	is.NoErr(s.Record(start.Add(30*time.Second), stat("living-room-tv", 400)))
+	is.NoErr(s.Close())
+
+	s, err = Open(dir, tiers, 0)
+	is.NoErr(err)
+	defer s.Close()
+	is.Equal(len(s.tiers[0].acc), 0)
+	samples, _, err := s.Query(start, start.Add(time.Hour), time.Minute)
+	is.NoErr(err)
+	is.Equal(1, len(samples)) // one series across the rename
+	is.Equal("living-room-tv", samples[0].Name)
+	is.Equal(uint64(400), samples[0].InBytes)
+
+	samples, _, err = s.Query(start, start.Add(time.Hour), 0)
+	is.NoErr(err)
+	is.Equal(4, len(samples))
+	is.Equal("tv", samples[1].Name)
+	is.Equal("living-room-tv", samples[2].Name)
+}
+
+func TestReadSegmentUnknownSeries(t *testing.T) {
+	is := is.New(t)
+	var b []byte
+	b = append(b, recSeries)
+	b = binary.AppendUvarint(b, 0)
+	b = appendString(b, "192.168.0.2")
+	b = appendString(b, "00:00:00:00:00:01")
+	b = appendString(b, "tv")
+	valid := int64(len(b))
+	b = append(b, recSample)
+	b = binary.AppendUvarint(b, 1)
+	b = binary.AppendVarint(b, 0)
+	b = append(b, 0, 0, 0, 0)
+
+	n, err := readSegment(bytes.NewReader(b), nil, nil)
+	is.True(err != nil)
+	is.Equal(valid, n)
+}
+
+func TestStoreKeyChange(t *testing.T) {
+	is := is.New(t)
+	tiers, err := ParseTiers("10s:1h")
+	is.NoErr(err)
+	s, err := Open(t.TempDir(), tiers, 0)
+	is.NoErr(err)
+	defer s.Close()
+
+	start := time.Now().Truncate(time.Minute).Add(-10 * time.Minute)
+	tv := clientstats.Stat{IP: "192.168.0.2", FirstSeen: start, InBytes: 100}
+	is.NoErr(s.Record(start, clientstats.Stats{tv}))
+	tv.InBytes = 300
+	is.NoErr(s.Record(start.Add(10*time.Second), clientstats.Stats{tv}))
+
+	// the hardware address becomes known and a new client appears
+	tv.HWAddr = "00:00:00:00:00:01"
+	tv.InBytes = 400
+	phone := clientstats.Stat{IP: "192.168.0.3", FirstSeen: start.Add(15 * time.Second), InBytes: 50}
+	is.NoErr(s.Record(start.Add(20*time.Second), clientstats.Stats{tv, phone}))
+	tv.InBytes = 450
+	is.NoErr(s.Record(start.Add(30*time.Second), clientstats.Stats{tv, phone}))
+
+	samples, _, err := s.Query(start, start.Add(time.Hour), time.Hour)
+	is.NoErr(err)
+	total := make(map[string]uint64)
+	for _, v := range samples {
+		total[v.IP] += v.InBytes
+	}
+	is.Equal(uint64(350), total["192.168.0.2"]) // the total is not counted again
+	is.Equal(uint64(50), total["192.168.0.3"])  // a new client is counted from zero
+}
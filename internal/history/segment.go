@@ -0,0 +1,236 @@
+package history
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// A segment file is a sequence of records. A series record assigns an id to a
+// series for the rest of the file and sample records refer to series by id.
+// A series record for an id that is already assigned changes the name of the
+// series.
+//
+//	series: 1 uvarint(id) string(ip) string(hwaddr) string(name)
+//	sample: 2 uvarint(id) varint(unix seconds) uvarint(in bytes)
+//	        uvarint(out bytes) uvarint(in packets) uvarint(out packets)
+//
+// Strings are prefixed by their uvarint length. A truncated record at the end
+// of a file, as left by a crash, is ignored.
+const (
+	recSeries byte = 1
+	recSample byte = 2
+)
+
+// segmentWriter appends samples to a segment file.
+type segmentWriter struct {
+	start time.Time
+	f     *os.File
+	w     *bufio.Writer
+	ids   map[clientKey]uint64
+	names map[uint64]string // the name last written for each id
+	buf   []byte
+}
+
+// openSegment opens the segment file for appending. The series ids of an
+// existing file are read and a truncated last record is removed.
+func openSegment(path string, start time.Time) (*segmentWriter, error) {
+	ids := make(map[clientKey]uint64)
+	names := make(map[uint64]string)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	valid, err := readSegment(f, func(id uint64, s Series) {
+		ids[s.key()] = id
+		names[id] = s.Name
+	}, nil)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("read segment %s: %w", path, err)
+	}
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &segmentWriter{
+		start: start,
+		f:     f,
+		w:     bufio.NewWriter(f),
+		ids:   ids,
+		names: names,
+	}, nil
+}
+
+// write appends a sample.
+func (sw *segmentWriter) write(t time.Time, s Series, c Counts) error {
+	b := sw.buf[:0]
+	id, ok := sw.ids[s.key()]
+	if !ok {
+		id = uint64(len(sw.ids))
+		sw.ids[s.key()] = id
+	}
+	if !ok || sw.names[id] != s.Name {
+		sw.names[id] = s.Name
+		b = append(b, recSeries)
+		b = binary.AppendUvarint(b, id)
+		b = appendString(b, s.IP)
+		b = appendString(b, s.HWAddr)
+		b = appendString(b, s.Name)
+	}
+	b = append(b, recSample)
+	b = binary.AppendUvarint(b, id)
+	b = binary.AppendVarint(b, t.Unix())
+	b = binary.AppendUvarint(b, c.InBytes)
+	b = binary.AppendUvarint(b, c.OutBytes)
+	b = binary.AppendUvarint(b, c.InPackets)
+	b = binary.AppendUvarint(b, c.OutPackets)
+	sw.buf = b
+	_, err := sw.w.Write(b)
+	return err
+}
+
+func (sw *segmentWriter) flush() error {
+	return sw.w.Flush()
+}
+
+func (sw *segmentWriter) close() error {
+	if err := sw.w.Flush(); err != nil {
+		sw.f.Close()
+		return err
+	}
+	return sw.f.Close()
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// readSegment reads all records from r and calls the non nil functions for
+// them. The number of bytes of complete records is returned. A sample of a
+// series that has not been assigned an id is an error.
+func readSegment(r io.Reader, series func(id uint64, s Series), sample func(Sample)) (int64, error) {
+	cr := &countingReader{r: bufio.NewReader(r)}
+	ids := make(map[uint64]Series)
+	var valid int64
+	for {
+		typ, err := cr.ReadByte()
+		if err == io.EOF {
+			return valid, nil
+		}
+		if err != nil {
+			return valid, err
+		}
+		switch typ {
+		case recSeries:
+			id, s, err := readSeries(cr)
+			if err != nil {
+				return valid, truncated(err)
+			}
+			ids[id] = s
+			if series != nil {
+				series(id, s)
+			}
+		case recSample:
+			id, ts, c, err := readSample(cr)
+			if err != nil {
+				return valid, truncated(err)
+			}
+			s, ok := ids[id]
+			if !ok {
+				return valid, fmt.Errorf("sample of unknown series id %d at offset %d", id, valid)
+			}
+			if sample != nil {
+				sample(Sample{Time: time.Unix(ts, 0), Series: s, Counts: c})
+			}
+		default:
+			// garbage after a crash, the rest of the file is ignored.
+			return valid, nil
+		}
+		valid = cr.n
+	}
+}
+
+// truncated returns nil for errors caused by a record that was cut short.
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil
+	}
+	return err
+}
+
+func readSeries(r *countingReader) (uint64, Series, error) {
+	var s Series
+	id, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, s, err
+	}
+	for _, v := range []*string{&s.IP, &s.HWAddr, &s.Name} {
+		if *v, err = readString(r); err != nil {
+			return 0, s, err
+		}
+	}
+	return id, s, nil
+}
+
+func readSample(r *countingReader) (uint64, int64, Counts, error) {
+	var c Counts
+	id, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, 0, c, err
+	}
+	ts, err := binary.ReadVarint(r)
+	if err != nil {
+		return 0, 0, c, err
+	}
+	for _, v := range []*uint64{&c.InBytes, &c.OutBytes, &c.InPackets, &c.OutPackets} {
+		if *v, err = binary.ReadUvarint(r); err != nil {
+			return 0, 0, c, err
+		}
+	}
+	return id, ts, c, nil
+}
+
+func readString(r *countingReader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > 1024 {
+		return "", io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// countingReader counts the bytes read.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
@@ -0,0 +1,307 @@
+// Package inventory keeps a persistent record of every device that has been
+// seen on the network.
+//
+// Devices are identified by their hardware address so that a device keeps its
+// history when its IP address changes. The inventory is stored as a JSON file
+// that is replaced atomically when it is saved.
+//
+// Devices appearing, going offline, coming back online and changing their
+// IPv4 address are published as device events.
+package inventory
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/some-programs/natbwmon/internal/clientstats"
+	"github.com/some-programs/natbwmon/internal/events"
+)
+
+// Device is a device that has been seen on the network.
+type Device struct {
+	HWAddr       string    `json:"hwaddr"`
+	IP           string    `json:"ip"`   // the last known IPv4 address
+	Name         string    `json:"name"` // the last known name
+	Manufacturer string    `json:"manufacturer"`
+	Interface    string    `json:"interface"` // the interface it was last seen on
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+	Online       bool      `json:"online"` // seen within the offline timeout
+	IPs          []Seen    `json:"ips"`    // every address the device has used
+	Names        []Seen    `json:"names"`  // every host name the device has used
+}
+
+// Seen is an address or name used by a device and when it was used.
+type Seen struct {
+	Value     string    `json:"value"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// clone returns a deep copy of d.
+func (d *Device) clone() Device {
+	c := *d
+	c.IPs = slices.Clone(d.IPs)
+	c.Names = slices.Clone(d.Names)
+	return c
+}
+
+// stat returns the device as client stats for events.
+func (d *Device) stat() clientstats.Stat {
+	return clientstats.Stat{
+		IP:           d.IP,
+		HWAddr:       d.HWAddr,
+		Name:         d.Name,
+		Interface:    d.Interface,
+		Manufacturer: d.Manufacturer,
+		FirstSeen:    d.FirstSeen,
+		LastSeen:     d.LastSeen,
+	}
+}
+
+// see records that value was used at t.
+func see(seen []Seen, value string, t time.Time) []Seen {
+	if value == "" {
+		return seen
+	}
+	for i := range seen {
+		if seen[i].Value == value {
+			if t.After(seen[i].LastSeen) {
+				seen[i].LastSeen = t
+			}
+			return seen
+		}
+	}
+	return append(seen, Seen{Value: value, FirstSeen: t, LastSeen: t})
+}
+
+// Store is the device inventory.
+type Store struct {
+	path         string
+	offlineAfter time.Duration
+	events       *events.Bus
+
+	mu      sync.Mutex
+	devices map[string]*Device // by hardware address
+	dirty   bool               // changed since the last save
+}
+
+// Open reads the inventory from the file at path, a missing file is an empty
+// inventory. If path is empty the inventory is only kept in memory. Devices
+// that have not been seen for offlineAfter are offline. Device events are
+// published to bus, which may be nil.
+func Open(path string, offlineAfter time.Duration, bus *events.Bus) (*Store, error) {
+	s := &Store{
+		path:         path,
+		offlineAfter: offlineAfter,
+		events:       bus,
+		devices:      make(map[string]*Device),
+	}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var devices []*Device
+	if err := json.Unmarshal(data, &devices); err != nil {
+		return nil, err
+	}
+	for _, d := range devices {
+		s.devices[d.HWAddr] = d
+	}
+	return s, nil
+}
+
+// Update records the clients in stats that have a hardware address as seen at
+// their last seen time, or t if it is not known, and publishes the device
+// events caused by the update. The devices that were not in the inventory
+// before are returned.
+//
+// A device with several IPv4 addresses is several clients with the same
+// hardware address. The IPv4 address of the device is kept while a client
+// with it is online, otherwise the address of the most recently seen client
+// is used.
+func (s *Store) Update(stats clientstats.Stats, t time.Time) []Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// update is a device seen in this update.
+	type update struct {
+		d      *Device
+		added  bool
+		ip     string    // the most recently seen IPv4 address
+		ipSeen time.Time // when ip was seen
+		keep   bool      // a client with the current IPv4 address is online
+	}
+	var updates []*update
+	byHWAddr := make(map[string]*update)
+	for _, st := range stats {
+		if st.HWAddr == "" {
+			continue
+		}
+		seen := st.LastSeen
+		if seen.IsZero() {
+			seen = t
+		}
+		u, ok := byHWAddr[st.HWAddr]
+		if !ok {
+			d, found := s.devices[st.HWAddr]
+			if !found {
+				first := st.FirstSeen
+				if first.IsZero() || first.After(seen) {
+					first = seen
+				}
+				d = &Device{HWAddr: st.HWAddr, FirstSeen: first}
+				s.devices[st.HWAddr] = d
+			}
+			u = &update{d: d, added: !found}
+			byHWAddr[st.HWAddr] = u
+			updates = append(updates, u)
+		}
+		d := u.d
+		if seen.After(d.LastSeen) {
+			d.LastSeen = seen
+		}
+		if isIPv4(st.IP) {
+			if u.ip == "" || seen.After(u.ipSeen) {
+				u.ip, u.ipSeen = st.IP, seen
+			}
+			if st.IP == d.IP && t.Sub(seen) < s.offlineAfter {
+				u.keep = true
+			}
+		}
+		if st.Name != "" {
+			d.Name = st.Name
+		}
+		if st.Manufacturer != "" {
+			d.Manufacturer = st.Manufacturer
+		}
+		if st.Interface != "" {
+			d.Interface = st.Interface
+		}
+		for _, ip := range append([]string{st.IP}, st.IP6...) {
+			d.IPs = see(d.IPs, ip, seen)
+		}
+		d.Names = see(d.Names, st.Name, seen)
+	}
+
+	var added []Device
+	for _, u := range updates {
+		d := u.d
+		prevIP := d.IP
+		if u.ip != "" && !u.keep {
+			d.IP = u.ip
+		}
+		switch {
+		case u.added:
+			d.Online = s.online(d, t)
+			added = append(added, d.clone())
+			s.events.Publish(events.Event{Type: events.DeviceNew, Time: t, Client: d.stat()})
+		case prevIP != "" && prevIP != d.IP:
+			s.events.Publish(events.Event{Type: events.DeviceIPChanged, Time: t, Client: d.stat(), PrevIP: prevIP})
+		}
+		s.dirty = true
+	}
+	for _, d := range s.devices {
+		online := s.online(d, t)
+		if online == d.Online {
+			continue
+		}
+		d.Online = online
+		typ := events.DeviceOffline
+		if online {
+			typ = events.DeviceOnline
+		}
+		s.events.Publish(events.Event{Type: typ, Time: t, Client: d.stat()})
+		s.dirty = true
+	}
+	return added
+}
+
+// online returns true if d has been seen within the offline timeout at t.
+func (s *Store) online(d *Device, t time.Time) bool {
+	return t.Sub(d.LastSeen) < s.offlineAfter
+}
+
+func isIPv4(ip string) bool {
+	v := net.ParseIP(ip)
+	return v != nil && v.To4() != nil
+}
+
+// Device returns the device with the hardware address.
+func (s *Store) Device(hwaddr string) (Device, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[hwaddr]
+	if !ok {
+		return Device{}, false
+	}
+	return d.clone(), true
+}
+
+// Devices returns all devices, the most recently first seen first.
+func (s *Store) Devices() []Device {
+	s.mu.Lock()
+	res := make([]Device, 0, len(s.devices))
+	for _, d := range s.devices {
+		res = append(res, d.clone())
+	}
+	s.mu.Unlock()
+	slices.SortFunc(res, func(a, b Device) int {
+		if c := b.FirstSeen.Compare(a.FirstSeen); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.HWAddr, b.HWAddr)
+	})
+	return res
+}
+
+// Save writes the inventory to its file if it has changed since it was last
+// saved.
+func (s *Store) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path == "" || !s.dirty {
+		return nil
+	}
+	devices := make([]*Device, 0, len(s.devices))
+	for _, d := range s.devices {
+		devices = append(devices, d)
+	}
+	slices.SortFunc(devices, func(a, b *Device) int {
+		return cmp.Compare(a.HWAddr, b.HWAddr)
+	})
+	data, err := json.MarshalIndent(devices, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), s.path); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
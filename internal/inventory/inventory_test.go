@@ -0,0 +1,149 @@
+package inventory
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/some-programs/natbwmon/internal/clientstats"
+	"github.com/some-programs/natbwmon/internal/events"
+)
+
+func TestStore(t *testing.T) {
+	is := is.New(t)
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	s, err := Open(path, 5*time.Minute, nil)
+	is.NoErr(err)
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	added := s.Update(clientstats.Stats{
+		{IP: "192.168.0.10", IP6: []string{"fe80::1"}, HWAddr: "00:00:00:00:00:01", Name: "tv", Interface: "br0", FirstSeen: t0, LastSeen: t0},
+		{IP: "192.168.0.11"}, // no hardware address
+	}, t0)
+	is.Equal(len(added), 1)
+	is.Equal(added[0].HWAddr, "00:00:00:00:00:01")
+
+	// the device gets a new address and name
+	t1 := t0.Add(time.Hour)
+	added = s.Update(clientstats.Stats{
+		{IP: "192.168.0.20", HWAddr: "00:00:00:00:00:01", Name: "livingroom-tv", Manufacturer: "Acme", FirstSeen: t1},
+	}, t1)
+	is.Equal(len(added), 0)
+
+	d, ok := s.Device("00:00:00:00:00:01")
+	is.True(ok)
+	is.Equal(d.FirstSeen, t0)
+	is.Equal(d.LastSeen, t1)
+	is.Equal(d.Name, "livingroom-tv")
+	is.Equal(d.Manufacturer, "Acme")
+	is.Equal(d.Interface, "br0")
+	is.Equal(d.IPs, []Seen{
+		{Value: "192.168.0.10", FirstSeen: t0, LastSeen: t0},
+		{Value: "fe80::1", FirstSeen: t0, LastSeen: t0},
+		{Value: "192.168.0.20", FirstSeen: t1, LastSeen: t1},
+	})
+	is.Equal(len(d.Names), 2)
+
+	is.NoErr(s.Save())
+	s2, err := Open(path, 5*time.Minute, nil)
+	is.NoErr(err)
+	is.Equal(s2.Devices(), s.Devices())
+
+	s.Update(clientstats.Stats{{IP: "192.168.0.30", HWAddr: "00:00:00:00:00:02"}}, t1)
+	ds := s.Devices()
+	is.Equal(len(ds), 2)
+	is.Equal(ds[0].HWAddr, "00:00:00:00:00:02") // most recently first seen first
+}
+
+func TestMemoryStore(t *testing.T) {
+	is := is.New(t)
+	s, err := Open("", 5*time.Minute, nil)
+	is.NoErr(err)
+	s.Update(clientstats.Stats{{IP: "192.168.0.10", HWAddr: "00:00:00:00:00:01"}}, time.Now())
+	is.NoErr(s.Save())
+	is.Equal(len(s.Devices()), 1)
+}
+
+func TestEvents(t *testing.T) {
+	is := is.New(t)
+	bus := events.NewBus()
+	evs, cancel := bus.Subscribe(10)
+	defer cancel()
+	s, err := Open("", 5*time.Minute, bus)
+	is.NoErr(err)
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tv := clientstats.Stat{IP: "192.168.0.10", HWAddr: "00:00:00:00:00:01", Manufacturer: "Acme", LastSeen: t0}
+	s.Update(clientstats.Stats{tv}, t0)
+	e := <-evs
+	is.Equal(e.Type, events.DeviceNew)
+	is.Equal(e.Client.Manufacturer, "Acme")
+
+	// an IPv6 primary address is not an IPv4 address change
+	s.Update(clientstats.Stats{{IP: "fe80::1", HWAddr: "00:00:00:00:00:01", LastSeen: t0}}, t0)
+	is.Equal(len(evs), 0)
+
+	tv.IP = "192.168.0.20"
+	s.Update(clientstats.Stats{tv}, t0)
+	e = <-evs
+	is.Equal(e.Type, events.DeviceIPChanged)
+	is.Equal(e.Client.IP, "192.168.0.20")
+	is.Equal(e.PrevIP, "192.168.0.10")
+
+	// the client is still known but has not been seen
+	t1 := t0.Add(10 * time.Minute)
+	s.Update(clientstats.Stats{tv}, t1)
+	is.Equal((<-evs).Type, events.DeviceOffline)
+	s.Update(clientstats.Stats{tv}, t1)
+	is.Equal(len(evs), 0)
+
+	tv.LastSeen = t1
+	s.Update(clientstats.Stats{tv}, t1)
+	is.Equal((<-evs).Type, events.DeviceOnline)
+
+	d, _ := s.Device("00:00:00:00:00:01")
+	is.True(d.Online)
+}
+
+func TestSharedHWAddr(t *testing.T) {
+	is := is.New(t)
+	bus := events.NewBus()
+	evs, cancel := bus.Subscribe(10)
+	defer cancel()
+	s, err := Open("", 5*time.Minute, bus)
+	is.NoErr(err)
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// a device with two IPv4 addresses is two clients
+	a := clientstats.Stat{IP: "192.168.0.10", HWAddr: "00:00:00:00:00:01", LastSeen: t0}
+	b := clientstats.Stat{IP: "192.168.0.20", HWAddr: "00:00:00:00:00:01", LastSeen: t0.Add(time.Second)}
+	s.Update(clientstats.Stats{a, b}, t0.Add(time.Second))
+	is.Equal((<-evs).Type, events.DeviceNew)
+	d, _ := s.Device("00:00:00:00:00:01")
+	is.Equal(d.IP, "192.168.0.20") // the most recently seen
+	is.Equal(len(d.IPs), 2)
+
+	// the address is kept while both clients are seen
+	for i := range 10 {
+		ti := t0.Add(time.Duration(i+2) * time.Second)
+		if i%2 == 0 {
+			a.LastSeen = ti
+		} else {
+			b.LastSeen = ti
+		}
+		s.Update(clientstats.Stats{a, b}, ti)
+	}
+	is.Equal(len(evs), 0)
+
+	// until the client with the address is offline
+	t1 := t0.Add(10 * time.Minute)
+	a.LastSeen = t1
+	s.Update(clientstats.Stats{a, b}, t1)
+	e := <-evs
+	is.Equal(e.Type, events.DeviceIPChanged)
+	is.Equal(e.Client.IP, "192.168.0.10")
+	is.Equal(e.PrevIP, "192.168.0.20")
+	s.Update(clientstats.Stats{a, b}, t1)
+	is.Equal(len(evs), 0)
+}
@@ -0,0 +1,194 @@
+// Package metrics writes metrics in the Prometheus text exposition format and
+// keeps track of the health of the periodic collectors.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Writer writes metrics in the Prometheus text exposition format. The first
+// write error is kept and returned by Err.
+type Writer struct {
+	w   io.Writer
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Family writes the help and type lines of a metric family. It must be called
+// once before the samples of the family are written.
+func (w *Writer) Family(name, typ, help string) {
+	w.printf("# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, typ)
+}
+
+// Sample writes a sample, labels are name value pairs.
+func (w *Writer) Sample(name string, value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i])
+			b.WriteString(`="`)
+			b.WriteString(escapeLabel(labels[i+1]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatValue(value))
+	b.WriteByte('\n')
+	w.printf("%s", b.String())
+}
+
+// Err returns the first write error.
+func (w *Writer) Err() error {
+	return w.err
+}
+
+func (w *Writer) printf(format string, a ...any) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = fmt.Fprintf(w.w, format, a...)
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Collector tracks the outcome of a periodically run task such as reading the
+// accounting counters or the neighbor table.
+type Collector struct {
+	name string
+
+	mu          sync.Mutex
+	runs        uint64
+	errors      uint64
+	total       time.Duration
+	last        time.Duration
+	lastSuccess time.Time
+}
+
+// Observe records a run of the task that took d and failed if err is not nil.
+func (c *Collector) Observe(d time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.runs++
+	c.total += d
+	c.last = d
+	if err != nil {
+		c.errors++
+		return
+	}
+	c.lastSuccess = time.Now()
+}
+
+// Run runs fn and observes its duration and error.
+func (c *Collector) Run(fn func() error) error {
+	start := time.Now()
+	err := fn()
+	c.Observe(time.Since(start), err)
+	return err
+}
+
+// Health is the set of collectors of the application.
+type Health struct {
+	mu         sync.Mutex
+	collectors []*Collector
+}
+
+func NewHealth() *Health {
+	return &Health{}
+}
+
+// Collector returns the named collector, it is created on first use.
+func (h *Health) Collector(name string) *Collector {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, c := range h.collectors {
+		if c.name == name {
+			return c
+		}
+	}
+	c := &Collector{name: name}
+	h.collectors = append(h.collectors, c)
+	return c
+}
+
+// Write writes the metrics of all collectors.
+func (h *Health) Write(w *Writer) {
+	h.mu.Lock()
+	collectors := append([]*Collector(nil), h.collectors...)
+	h.mu.Unlock()
+
+	type snapshot struct {
+		name         string
+		runs, errors uint64
+		total, last  time.Duration
+		lastSuccess  time.Time
+	}
+	ss := make([]snapshot, 0, len(collectors))
+	for _, c := range collectors {
+		c.mu.Lock()
+		ss = append(ss, snapshot{c.name, c.runs, c.errors, c.total, c.last, c.lastSuccess})
+		c.mu.Unlock()
+	}
+
+	w.Family("natbwmon_collector_runs_total", "counter", "Number of runs of the collector.")
+	for _, s := range ss {
+		w.Sample("natbwmon_collector_runs_total", float64(s.runs), "collector", s.name)
+	}
+	w.Family("natbwmon_collector_errors_total", "counter", "Number of failed runs of the collector.")
+	for _, s := range ss {
+		w.Sample("natbwmon_collector_errors_total", float64(s.errors), "collector", s.name)
+	}
+	w.Family("natbwmon_collector_duration_seconds_total", "counter", "Total time spent in runs of the collector.")
+	for _, s := range ss {
+		w.Sample("natbwmon_collector_duration_seconds_total", s.total.Seconds(), "collector", s.name)
+	}
+	w.Family("natbwmon_collector_last_duration_seconds", "gauge", "Duration of the last run of the collector.")
+	for _, s := range ss {
+		w.Sample("natbwmon_collector_last_duration_seconds", s.last.Seconds(), "collector", s.name)
+	}
+	w.Family("natbwmon_collector_last_success_timestamp_seconds", "gauge", "Unix time of the last successful run of the collector, 0 if it has never succeeded.")
+	for _, s := range ss {
+		var ts float64
+		if !s.lastSuccess.IsZero() {
+			ts = float64(s.lastSuccess.UnixNano()) / 1e9
+		}
+		w.Sample("natbwmon_collector_last_success_timestamp_seconds", ts, "collector", s.name)
+	}
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestWriter(t *testing.T) {
+	is := is.New(t)
+	var b strings.Builder
+	w := NewWriter(&b)
+	w.Family("test_total", "counter", "A test\ncounter.")
+	w.Sample("test_total", 1.5, "name", `a "b"\c`, "direction", "in")
+	w.Sample("test_total", 3)
+	is.NoErr(w.Err())
+	is.Equal(`# HELP test_total A test\ncounter.
+# TYPE test_total counter
+test_total{name="a \"b\"\\c",direction="in"} 1.5
+test_total 3
+`, b.String())
+}
+
+func TestHealth(t *testing.T) {
+	is := is.New(t)
+	h := NewHealth()
+	c := h.Collector("rules")
+	is.True(h.Collector("rules") == c)
+	c.Observe(time.Second, nil)
+	c.Observe(time.Second, errors.New("failed"))
+
+	var b strings.Builder
+	h.Write(NewWriter(&b))
+	out := b.String()
+	is.True(strings.Contains(out, `natbwmon_collector_runs_total{collector="rules"} 2`+"\n"))
+	is.True(strings.Contains(out, `natbwmon_collector_errors_total{collector="rules"} 1`+"\n"))
+	is.True(strings.Contains(out, `natbwmon_collector_duration_seconds_total{collector="rules"} 2`+"\n"))
+}
@@ -0,0 +1,54 @@
+package mon
+
+import (
+	"time"
+
+	"github.com/some-programs/natbwmon/internal/arp"
+)
+
+// Accounting is a backend that counts forwarded bytes per client address.
+type Accounting interface {
+	// Update makes sure that the neighbors in arps are being counted.
+	Update(arps arp.Entries) error
+
+	// Remove stops counting the client addresses in ips.
+	Remove(ips []string) error
+
+	// Stats reads the current counters.
+	Stats() (IPTStats, error)
+
+	// ClearChain removes all counted clients.
+	ClearChain() error
+
+	// Delete removes everything the backend has added to the system.
+	Delete() error
+}
+
+// IPTStats is a snapshot of all the counters of an accounting backend.
+type IPTStats struct {
+	CreatedAt time.Time
+	Stats     []Counter
+}
+
+// Counter is the number of bytes and packets counted in one direction for a
+// single client address.
+type Counter struct {
+	IP      string
+	Out     bool // true if the counter is for traffic sent by the client
+	Bytes   uint64
+	Packets uint64
+}
+
+// Enforcer is implemented by the accounting backends that can restrict the
+// traffic of clients.
+type Enforcer interface {
+	// Enforce replaces the currently enforced limits with limits, an empty
+	// list lifts all restrictions.
+	Enforce(limits []Limit) error
+}
+
+// Limit restricts the forwarded traffic of a single client address.
+type Limit struct {
+	IP   string
+	Rate uint64 // bytes per second in each direction, 0 drops all traffic
+}
@@ -5,14 +5,22 @@ import (
 	"fmt"
 	"net"
 	"sort"
+	"strconv"
+	"strings"
 
 	ct "github.com/florianl/go-conntrack"
+	"golang.org/x/sys/unix"
 )
 
 type Flow struct {
-	Orig  Subflow
-	Reply Subflow
-	TTL   uint64
+	ID    uint32  `json:"id"`
+	Proto string  `json:"proto"` // protocol name or number
+	State string  `json:"state"` // TCP connection state
+	Zone  uint16  `json:"zone"`
+	Mark  uint32  `json:"mark"`
+	Orig  Subflow `json:"orig"`
+	Reply Subflow `json:"reply"`
+	TTL   uint64  `json:"ttl"` // seconds until the entry times out
 }
 
 func newFlow(c ct.Con) Flow {
@@ -20,12 +28,73 @@ func newFlow(c ct.Con) Flow {
 		Orig:  newSubFlow(c.Origin, c.CounterOrigin),
 		Reply: newSubFlow(c.Reply, c.CounterReply),
 	}
+	if c.ID != nil {
+		f.ID = *c.ID
+	}
 	if c.Timeout != nil {
 		f.TTL = uint64(*c.Timeout)
 	}
+	if c.Origin != nil && c.Origin.Proto != nil && c.Origin.Proto.Number != nil {
+		f.Proto = protoName(*c.Origin.Proto.Number)
+	}
+	if c.ProtoInfo != nil && c.ProtoInfo.TCP != nil && c.ProtoInfo.TCP.State != nil {
+		f.State = tcpStateName(*c.ProtoInfo.TCP.State)
+	}
+	if c.Zone != nil {
+		f.Zone = *c.Zone
+	}
+	if c.Mark != nil {
+		f.Mark = *c.Mark
+	}
 	return f
 }
 
+// protoName returns the name of common IP protocols and the number of others.
+func protoName(n uint8) string {
+	switch n {
+	case unix.IPPROTO_ICMP:
+		return "icmp"
+	case unix.IPPROTO_TCP:
+		return "tcp"
+	case unix.IPPROTO_UDP:
+		return "udp"
+	case unix.IPPROTO_DCCP:
+		return "dccp"
+	case unix.IPPROTO_GRE:
+		return "gre"
+	case unix.IPPROTO_ESP:
+		return "esp"
+	case unix.IPPROTO_ICMPV6:
+		return "icmpv6"
+	case unix.IPPROTO_SCTP:
+		return "sctp"
+	case unix.IPPROTO_UDPLITE:
+		return "udplite"
+	}
+	return strconv.Itoa(int(n))
+}
+
+// tcpStates are the names of the conntrack TCP states by number.
+var tcpStates = []string{
+	"NONE",
+	"SYN_SENT",
+	"SYN_RECV",
+	"ESTABLISHED",
+	"FIN_WAIT",
+	"CLOSE_WAIT",
+	"LAST_ACK",
+	"TIME_WAIT",
+	"CLOSE",
+	"SYN_SENT2",
+}
+
+func tcpStateName(n uint8) string {
+	if int(n) < len(tcpStates) {
+		return tcpStates[n]
+	}
+	return strconv.Itoa(int(n))
+}
+
 // isInteresting returns false if all the ends of the connections is the router
 // itself or some similarily uninteresting item. Keeping multicast stuff.
 func (f Flow) isInteresting() bool {
@@ -59,12 +128,12 @@ func (f Flow) isRouted() bool {
 }
 
 type Subflow struct {
-	Source      net.IP
-	Destination net.IP
-	SPort       int
-	DPort       int
-	Bytes       uint64
-	Packets     uint64
+	Source      net.IP `json:"src"`
+	Destination net.IP `json:"dst"`
+	SPort       int    `json:"sport"`
+	DPort       int    `json:"dport"`
+	Bytes       uint64 `json:"bytes"`
+	Packets     uint64 `json:"packets"`
 }
 
 func newSubFlow(ipt *ct.IPTuple, counter *ct.Counter) Subflow {
@@ -78,7 +147,7 @@ func newSubFlow(ipt *ct.IPTuple, counter *ct.Counter) Subflow {
 		}
 		if ipt.Proto != nil {
 			if ipt.Proto.SrcPort != nil {
-				sf.DPort = int(*ipt.Proto.SrcPort)
+				sf.SPort = int(*ipt.Proto.SrcPort)
 			}
 			if ipt.Proto.DstPort != nil {
 				sf.DPort = int(*ipt.Proto.DstPort)
@@ -148,6 +217,57 @@ func (fs FlowSlice) FilterByIP(ip net.IP) FlowSlice {
 	return res
 }
 
+// FilterByPort returns the flows that have port as a source or destination
+// port in either direction.
+func (fs FlowSlice) FilterByPort(port int) FlowSlice {
+	res := make(FlowSlice, 0, len(fs))
+	for _, f := range fs {
+		if f.Orig.SPort == port ||
+			f.Orig.DPort == port ||
+			f.Reply.SPort == port ||
+			f.Reply.DPort == port {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// FilterByProto returns the flows of the protocol, which is a name as in Flow
+// or a number.
+func (fs FlowSlice) FilterByProto(proto string) FlowSlice {
+	if n, err := strconv.ParseUint(proto, 10, 8); err == nil {
+		proto = protoName(uint8(n))
+	}
+	proto = strings.ToLower(proto)
+	res := make(FlowSlice, 0, len(fs))
+	for _, f := range fs {
+		if f.Proto == proto {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// FilterByNet returns the flows that have an address within the network.
+func (fs FlowSlice) FilterByNet(n *net.IPNet) FlowSlice {
+	res := make(FlowSlice, 0, len(fs))
+	for _, f := range fs {
+		if n.Contains(f.Orig.Source) ||
+			n.Contains(f.Orig.Destination) ||
+			n.Contains(f.Reply.Source) ||
+			n.Contains(f.Reply.Destination) {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+func (fs FlowSlice) OrderByProto() {
+	sort.SliceStable(fs, func(i, j int) bool {
+		return fs[i].Proto < fs[j].Proto
+	})
+}
+
 func (fs FlowSlice) OrderByTTL() {
 	sort.SliceStable(fs, func(i, j int) bool {
 		return fs[i].TTL > fs[j].TTL
@@ -0,0 +1,78 @@
+package mon
+
+import (
+	"net"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestFlowSliceFilters(t *testing.T) {
+	is := is.New(t)
+	flow := func(proto, dst string, dport int) Flow {
+		return Flow{
+			Proto: proto,
+			Orig:  Subflow{Source: net.ParseIP("192.168.0.2"), Destination: net.ParseIP(dst), SPort: 40000, DPort: dport},
+			Reply: Subflow{Source: net.ParseIP(dst), Destination: net.ParseIP("10.0.0.1"), SPort: dport, DPort: 40000},
+		}
+	}
+	fs := FlowSlice{
+		flow("tcp", "1.1.1.1", 443),
+		flow("udp", "1.1.1.1", 53),
+		flow("tcp", "8.8.8.8", 80),
+	}
+
+	is.Equal(2, len(fs.FilterByProto("tcp")))
+	is.Equal(2, len(fs.FilterByProto("6")))
+	is.Equal(1, len(fs.FilterByProto("UDP")))
+	is.Equal(0, len(fs.FilterByProto("icmp")))
+
+	is.Equal(1, len(fs.FilterByPort(53)))
+	is.Equal(3, len(fs.FilterByPort(40000)))
+
+	_, n, err := net.ParseCIDR("8.8.0.0/16")
+	is.NoErr(err)
+	res := fs.FilterByNet(n)
+	is.Equal(1, len(res))
+	is.Equal(80, res[0].Orig.DPort)
+}
+
+func TestNames(t *testing.T) {
+	is := is.New(t)
+	is.Equal("tcp", protoName(6))
+	is.Equal("icmpv6", protoName(58))
+	is.Equal("253", protoName(253))
+	is.Equal("ESTABLISHED", tcpStateName(3))
+	is.Equal("42", tcpStateName(42))
+}
+
+func TestDestinations(t *testing.T) {
+	is := is.New(t)
+	client := net.ParseIP("192.168.0.2")
+	wan := net.ParseIP("10.0.0.1")
+	out := func(dst string, sent, received uint64) Flow {
+		return Flow{
+			Orig:  Subflow{Source: client, Destination: net.ParseIP(dst), Bytes: sent},
+			Reply: Subflow{Source: net.ParseIP(dst), Destination: wan, Bytes: received},
+		}
+	}
+	fs := FlowSlice{
+		out("1.1.1.1", 10, 100),
+		out("8.8.8.8", 100, 1000),
+		out("1.1.1.1", 20, 200),
+		{
+			Orig:  Subflow{Source: net.ParseIP("2.2.2.2"), Destination: wan, Bytes: 1},
+			Reply: Subflow{Source: client, Destination: net.ParseIP("2.2.2.2"), Bytes: 2},
+		},
+		{
+			Orig:  Subflow{Source: net.ParseIP("192.168.0.3"), Destination: net.ParseIP("8.8.8.8"), Bytes: 5},
+			Reply: Subflow{Source: net.ParseIP("8.8.8.8"), Destination: wan, Bytes: 5},
+		},
+	}
+	ds := fs.Destinations([]net.IP{client})
+	is.Equal([]Destination{
+		{IP: "8.8.8.8", Flows: 1, InBytes: 1000, OutBytes: 100},
+		{IP: "1.1.1.1", Flows: 2, InBytes: 300, OutBytes: 30},
+		{IP: "2.2.2.2", Flows: 1, InBytes: 1, OutBytes: 2},
+	}, ds)
+}
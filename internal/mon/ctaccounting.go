@@ -0,0 +1,165 @@
+package mon
+
+import (
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/some-programs/natbwmon/internal/arp"
+	"github.com/some-programs/natbwmon/internal/log"
+)
+
+// ConntrackAccounting is an accounting backend that does not touch the
+// firewall. Per client counters are derived from the conntrack byte counters
+// by diffing each flow between polls and attributing the traffic to the LAN
+// side endpoint of the flow.
+//
+// Requires connection tracking accounting to be enabled
+// (net.netfilter.nf_conntrack_acct=1).
+//
+// The conntrack table is dumped on every poll. The traffic of flows that end
+// between polls is counted from their final counters, which are reported by
+// the ended function, usually FlowTracker.Ended.
+//
+// All addresses within the LAN networks are counted, Update is not needed to
+// add clients.
+type ConntrackAccounting struct {
+	flows func() (FlowSlice, error)
+	ended func() FlowSlice // nil if ended flows are not known
+	lan   LAN
+	ipv6  bool
+
+	mu       sync.Mutex
+	differ   *flowDiffer
+	counters map[string]*ctCounter
+}
+
+// ctCounter holds the accumulated traffic of one client address.
+type ctCounter struct {
+	in  Counter
+	out Counter
+}
+
+func NewConntrackAccounting(flows func() (FlowSlice, error), ended func() FlowSlice, lan LAN, ipv6 bool) (*ConntrackAccounting, error) {
+	data, err := os.ReadFile("/proc/sys/net/netfilter/nf_conntrack_acct")
+	if err == nil && strings.TrimSpace(string(data)) == "0" {
+		log.Warn().Msg("conntrack accounting is disabled, enable it with: sysctl -w net.netfilter.nf_conntrack_acct=1")
+	}
+	return &ConntrackAccounting{
+		flows:    flows,
+		ended:    ended,
+		lan:      lan,
+		ipv6:     ipv6,
+		differ:   newFlowDiffer(),
+		counters: make(map[string]*ctCounter),
+	}, nil
+}
+
+func (c *ConntrackAccounting) Stats() (IPTStats, error) {
+	lan, err := c.lan.networks()
+	if err != nil {
+		return IPTStats{}, err
+	}
+	fs, err := c.flows()
+	if err != nil {
+		return IPTStats{}, err
+	}
+	// read after the dump so that a flow that ends in between is not lost
+	var ended FlowSlice
+	if c.ended != nil {
+		ended = c.ended()
+	}
+	createdAt := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, d := range c.differ.diff(fs, ended) {
+		ip, out := lanEndpoint(d.Flow, lan)
+		if ip == nil || (!c.ipv6 && ip.To4() == nil) {
+			continue
+		}
+		key := ip.String()
+		cc, ok := c.counters[key]
+		if !ok {
+			cc = &ctCounter{
+				in:  Counter{IP: key},
+				out: Counter{IP: key, Out: true},
+			}
+			c.counters[key] = cc
+		}
+		origBytes, origPackets := d.Delta.OrigBytes, d.Delta.OrigPackets
+		replyBytes, replyPackets := d.Delta.ReplyBytes, d.Delta.ReplyPackets
+		if !out {
+			origBytes, replyBytes = replyBytes, origBytes
+			origPackets, replyPackets = replyPackets, origPackets
+		}
+		cc.out.Bytes += origBytes
+		cc.out.Packets += origPackets
+		cc.in.Bytes += replyBytes
+		cc.in.Packets += replyPackets
+	}
+
+	stats := make([]Counter, 0, len(c.counters)*2)
+	for _, cc := range c.counters {
+		stats = append(stats, cc.in, cc.out)
+	}
+	return IPTStats{
+		CreatedAt: createdAt,
+		Stats:     stats,
+	}, nil
+}
+
+// lanEndpoint returns the address of the LAN side endpoint of f. out is true
+// if the flow was initiated by the LAN side endpoint. nil is returned if the
+// flow is not forwarded between the LAN and another network.
+func lanEndpoint(f Flow, lan []*net.IPNet) (ip net.IP, out bool) {
+	switch {
+	case inNetworks(f.Orig.Source, lan) && !isLocalIP(f.Orig.Source) && !isLocalIP(f.Reply.Source):
+		return f.Orig.Source, true
+	case inNetworks(f.Reply.Source, lan) && !isLocalIP(f.Reply.Source) && !isLocalIP(f.Orig.Source):
+		return f.Reply.Source, false
+	}
+	return nil, false
+}
+
+func inNetworks(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Update does nothing, clients are added when they are first seen in a
+// connection.
+func (c *ConntrackAccounting) Update(arps arp.Entries) error {
+	return nil
+}
+
+// Remove forgets the counters of the addresses. Traffic of their existing
+// connections is counted again from zero.
+func (c *ConntrackAccounting) Remove(ips []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ip := range ips {
+		delete(c.counters, ip)
+	}
+	return nil
+}
+
+// ClearChain forgets all counters.
+func (c *ConntrackAccounting) ClearChain() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.differ.reset()
+	c.counters = make(map[string]*ctCounter)
+	return nil
+}
+
+// Delete does nothing since nothing is added to the system.
+func (c *ConntrackAccounting) Delete() error {
+	return nil
+}
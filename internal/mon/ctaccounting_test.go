@@ -0,0 +1,84 @@
+package mon
+
+import (
+	"net"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestConntrackAccountingRemove(t *testing.T) {
+	is := is.New(t)
+	flow := func(sent, received uint64) Flow {
+		return Flow{
+			ID:    1,
+			Proto: "tcp",
+			Orig:  Subflow{Source: net.ParseIP("192.168.0.2"), Destination: net.ParseIP("1.1.1.1"), SPort: 40000, DPort: 443, Bytes: sent, Packets: 1},
+			Reply: Subflow{Source: net.ParseIP("1.1.1.1"), Destination: net.ParseIP("10.0.0.1"), SPort: 443, DPort: 40000, Bytes: received, Packets: 1},
+		}
+	}
+	var fs FlowSlice
+	lan, err := ParseLAN(nil, []string{"192.168.0.0/24"})
+	is.NoErr(err)
+	c, err := NewConntrackAccounting(func() (FlowSlice, error) { return fs, nil }, nil, lan, false)
+	is.NoErr(err)
+
+	counters := func() map[bool]uint64 {
+		stats, err := c.Stats()
+		is.NoErr(err)
+		res := make(map[bool]uint64)
+		for _, s := range stats.Stats {
+			is.Equal(s.IP, "192.168.0.2")
+			res[s.Out] = s.Bytes
+		}
+		return res
+	}
+
+	fs = FlowSlice{flow(100, 1000)}
+	counters() // baseline
+	fs = FlowSlice{flow(150, 3000)}
+	is.Equal(counters(), map[bool]uint64{true: 50, false: 2000})
+
+	// removed addresses are counted from zero
+	is.NoErr(c.Remove([]string{"192.168.0.2"}))
+	is.Equal(len(counters()), 0)
+	fs = FlowSlice{flow(160, 3500)}
+	is.Equal(counters(), map[bool]uint64{true: 10, false: 500})
+}
+
+func TestConntrackAccountingEnded(t *testing.T) {
+	is := is.New(t)
+	flow := func(id uint32, sent, received uint64) Flow {
+		return Flow{
+			ID:    id,
+			Proto: "tcp",
+			Orig:  Subflow{Source: net.ParseIP("192.168.0.2"), Destination: net.ParseIP("1.1.1.1"), SPort: 40000 + int(id), DPort: 443, Bytes: sent, Packets: 1},
+			Reply: Subflow{Source: net.ParseIP("1.1.1.1"), Destination: net.ParseIP("10.0.0.1"), SPort: 443, DPort: 40000 + int(id), Bytes: received, Packets: 1},
+		}
+	}
+	var fs, ended FlowSlice
+	lan, err := ParseLAN(nil, []string{"192.168.0.0/24"})
+	is.NoErr(err)
+	c, err := NewConntrackAccounting(
+		func() (FlowSlice, error) { return fs, nil },
+		func() FlowSlice { e := ended; ended = nil; return e },
+		lan, false)
+	is.NoErr(err)
+	counters := func() map[bool]uint64 {
+		stats, err := c.Stats()
+		is.NoErr(err)
+		res := make(map[bool]uint64)
+		for _, s := range stats.Stats {
+			res[s.Out] = s.Bytes
+		}
+		return res
+	}
+
+	fs = FlowSlice{flow(1, 100, 1000)}
+	counters() // baseline
+	// the first flow ends and a short flow starts and ends between reads
+	fs = nil
+	ended = FlowSlice{flow(1, 120, 1500), flow(2, 10, 200)}
+	is.Equal(counters(), map[bool]uint64{true: 30, false: 700})
+	is.Equal(counters(), map[bool]uint64{true: 30, false: 700})
+}
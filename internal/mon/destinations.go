@@ -0,0 +1,73 @@
+package mon
+
+import (
+	"net"
+	"slices"
+)
+
+// Destination is the traffic between a client and a remote host.
+type Destination struct {
+	IP       string `json:"ip"`
+	Name     string `json:"name"` // reverse DNS name, empty if not resolved
+	Flows    int    `json:"flows"`
+	InBytes  uint64 `json:"in_bytes"`  // to the client
+	OutBytes uint64 `json:"out_bytes"` // from the client
+}
+
+// TotalBytes returns the sum of the in and out bytes.
+func (d Destination) TotalBytes() uint64 {
+	return d.InBytes + d.OutBytes
+}
+
+// Destinations groups the flows of the client with the addresses by the
+// remote end of the connection, ordered by total bytes.
+func (fs FlowSlice) Destinations(addrs []net.IP) []Destination {
+	byIP := make(map[string]*Destination)
+	for _, f := range fs {
+		var (
+			remote net.IP
+			out    bool
+		)
+		switch {
+		case slices.ContainsFunc(addrs, f.Orig.Source.Equal):
+			remote, out = f.Orig.Destination, true
+		case slices.ContainsFunc(addrs, f.Reply.Source.Equal):
+			// a connection to a forwarded port
+			remote = f.Orig.Source
+		default:
+			continue
+		}
+		ip := remote.String()
+		d, ok := byIP[ip]
+		if !ok {
+			d = &Destination{IP: ip}
+			byIP[ip] = d
+		}
+		d.Flows++
+		if out {
+			d.OutBytes += f.Orig.Bytes
+			d.InBytes += f.Reply.Bytes
+		} else {
+			d.InBytes += f.Orig.Bytes
+			d.OutBytes += f.Reply.Bytes
+		}
+	}
+	res := make([]Destination, 0, len(byIP))
+	for _, d := range byIP {
+		res = append(res, *d)
+	}
+	slices.SortFunc(res, func(a, b Destination) int {
+		switch {
+		case a.TotalBytes() > b.TotalBytes():
+			return -1
+		case a.TotalBytes() < b.TotalBytes():
+			return 1
+		case a.IP < b.IP:
+			return -1
+		case a.IP > b.IP:
+			return 1
+		}
+		return 0
+	})
+	return res
+}
@@ -0,0 +1,109 @@
+package mon
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DNSCache resolves the host names of addresses in the background and caches
+// them so that looking up a name never blocks.
+type DNSCache struct {
+	ttl     time.Duration
+	maxSize int
+	resolve func(ip string) (string, error)
+	queue   chan string
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+}
+
+type dnsEntry struct {
+	name    string
+	expires time.Time // zero while the address is being resolved
+}
+
+// NewDNSCache returns a cache that keeps up to maxSize names for ttl.
+func NewDNSCache(ttl time.Duration, maxSize int) *DNSCache {
+	return &DNSCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		resolve: ResolveHostname,
+		queue:   make(chan string, 1024),
+		entries: make(map[string]*dnsEntry),
+	}
+}
+
+// Run resolves the queued addresses with the number of concurrent workers
+// until ctx is done.
+func (c *DNSCache) Run(ctx context.Context, workers int) {
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case ip := <-c.queue:
+					// failed lookups are cached as no name to not retry them
+					// until the entry expires.
+					name, _ := c.resolve(ip)
+					c.mu.Lock()
+					c.entries[ip] = &dnsEntry{name: name, expires: time.Now().Add(c.ttl)}
+					c.mu.Unlock()
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Lookup returns the cached name of the address. An empty string is returned
+// if the name is not known yet, the address is then queued to be resolved.
+func (c *DNSCache) Lookup(ip string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[ip]
+	if ok && (e.expires.IsZero() || time.Now().Before(e.expires)) {
+		return e.name
+	}
+	if len(c.entries) >= c.maxSize {
+		c.evict()
+	}
+	select {
+	case c.queue <- ip:
+		if ok {
+			// keep the old name until the address has been resolved again.
+			e.expires = time.Time{}
+		} else {
+			c.entries[ip] = &dnsEntry{}
+		}
+	default:
+		// the queue is full, the address is queued by a later lookup.
+	}
+	if ok {
+		return e.name
+	}
+	return ""
+}
+
+// evict removes the expired entries or if there are none some arbitrary
+// resolved entries, c.mu must be held.
+func (c *DNSCache) evict() {
+	now := time.Now()
+	for ip, e := range c.entries {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			delete(c.entries, ip)
+		}
+	}
+	for ip, e := range c.entries {
+		if len(c.entries) < c.maxSize {
+			return
+		}
+		if !e.expires.IsZero() {
+			delete(c.entries, ip)
+		}
+	}
+}
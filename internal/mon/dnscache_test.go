@@ -0,0 +1,60 @@
+package mon
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestDNSCache(t *testing.T) {
+	is := is.New(t)
+	c := NewDNSCache(time.Hour, 2)
+	resolved := make(chan string, 10)
+	c.resolve = func(ip string) (string, error) {
+		defer func() { resolved <- ip }()
+		if ip == "10.0.0.3" {
+			return "", errors.New("timeout")
+		}
+		return "host-" + ip, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx, 1)
+
+	is.Equal("", c.Lookup("10.0.0.1"))
+	is.Equal("", c.Lookup("10.0.0.1")) // queued only once
+	is.Equal("10.0.0.1", <-resolved)
+	waitFor(t, func() bool { return c.Lookup("10.0.0.1") != "" })
+	is.Equal("host-10.0.0.1", c.Lookup("10.0.0.1"))
+
+	// failures are cached as no name
+	is.Equal("", c.Lookup("10.0.0.3"))
+	is.Equal("10.0.0.3", <-resolved)
+
+	// the cache does not grow past its size
+	c.Lookup("10.0.0.4")
+	<-resolved
+	waitFor(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.entries["10.0.0.4"] != nil && !c.entries["10.0.0.4"].expires.IsZero()
+	})
+	c.mu.Lock()
+	is.True(len(c.entries) <= 2)
+	c.mu.Unlock()
+	is.Equal(0, len(resolved))
+}
+
+func waitFor(t *testing.T, fn func() bool) {
+	t.Helper()
+	for range 100 {
+		if fn() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out")
+}
@@ -0,0 +1,149 @@
+package mon
+
+// flowKey identifies a conntrack entry across dumps.
+type flowKey struct {
+	id    uint32
+	proto string
+	src   string
+	dst   string
+	sport int
+	dport int
+}
+
+func newFlowKey(f Flow) flowKey {
+	return flowKey{
+		id:    f.ID,
+		proto: f.Proto,
+		src:   string(f.Orig.Source.To16()),
+		dst:   string(f.Orig.Destination.To16()),
+		sport: f.Orig.SPort,
+		dport: f.Orig.DPort,
+	}
+}
+
+// flowCounters are the byte and packet counters of both directions of a flow.
+type flowCounters struct {
+	OrigBytes    uint64
+	OrigPackets  uint64
+	ReplyBytes   uint64
+	ReplyPackets uint64
+}
+
+func newFlowCounters(f Flow) flowCounters {
+	return flowCounters{
+		OrigBytes:    f.Orig.Bytes,
+		OrigPackets:  f.Orig.Packets,
+		ReplyBytes:   f.Reply.Bytes,
+		ReplyPackets: f.Reply.Packets,
+	}
+}
+
+// sub returns c-o, a counter that has decreased is counted from zero.
+func (c flowCounters) sub(o flowCounters) flowCounters {
+	sub := func(a, b uint64) uint64 {
+		if a < b {
+			return a
+		}
+		return a - b
+	}
+	return flowCounters{
+		OrigBytes:    sub(c.OrigBytes, o.OrigBytes),
+		OrigPackets:  sub(c.OrigPackets, o.OrigPackets),
+		ReplyBytes:   sub(c.ReplyBytes, o.ReplyBytes),
+		ReplyPackets: sub(c.ReplyPackets, o.ReplyPackets),
+	}
+}
+
+// atLeast returns the larger of each counter of c and o.
+func (c flowCounters) atLeast(o flowCounters) flowCounters {
+	return flowCounters{
+		OrigBytes:    max(c.OrigBytes, o.OrigBytes),
+		OrigPackets:  max(c.OrigPackets, o.OrigPackets),
+		ReplyBytes:   max(c.ReplyBytes, o.ReplyBytes),
+		ReplyPackets: max(c.ReplyPackets, o.ReplyPackets),
+	}
+}
+
+// flowDelta is the traffic of a flow since the previous dump.
+type flowDelta struct {
+	Flow  Flow
+	Delta flowCounters
+}
+
+// flowDiffer computes per flow counter differences between consecutive
+// conntrack dumps.
+type flowDiffer struct {
+	prev        map[flowKey]flowCounters
+	gone        map[flowKey]flowCounters // flows that disappeared from the previous dump
+	initialized bool
+}
+
+func newFlowDiffer() *flowDiffer {
+	return &flowDiffer{
+		prev: make(map[flowKey]flowCounters),
+		gone: make(map[flowKey]flowCounters),
+	}
+}
+
+// diff returns the traffic of each flow in fs and of each flow that has
+// ended with its final counters in ended since the previous call.
+//
+// The first call only records a baseline so that traffic from before the
+// program started does not show up as a spike. Flows that have appeared since
+// the previous call are counted from zero. Flows that have disappeared
+// without being in ended are forgotten after one more call in case they are
+// reported as ended late, what they transferred after the previous dump is
+// lost.
+func (d *flowDiffer) diff(fs, ended FlowSlice) []flowDelta {
+	next := make(map[flowKey]flowCounters, len(fs))
+	deltas := make([]flowDelta, 0, len(fs)+len(ended))
+	for _, f := range fs {
+		k := newFlowKey(f)
+		cur := newFlowCounters(f)
+		next[k] = cur
+		if !d.initialized {
+			continue
+		}
+		delta := cur.sub(d.prev[k])
+		if delta == (flowCounters{}) {
+			continue
+		}
+		deltas = append(deltas, flowDelta{Flow: f, Delta: delta})
+	}
+	done := make(map[flowKey]bool, len(ended))
+	for _, f := range ended {
+		k := newFlowKey(f)
+		done[k] = true
+		last, ok := next[k]
+		if ok {
+			delete(next, k)
+		} else if last, ok = d.prev[k]; !ok {
+			last = d.gone[k]
+		}
+		if !d.initialized {
+			continue
+		}
+		// the final counters are never less than the last seen counters
+		delta := newFlowCounters(f).atLeast(last).sub(last)
+		if delta == (flowCounters{}) {
+			continue
+		}
+		deltas = append(deltas, flowDelta{Flow: f, Delta: delta})
+	}
+	gone := make(map[flowKey]flowCounters)
+	for k, c := range d.prev {
+		if _, ok := next[k]; !ok && !done[k] {
+			gone[k] = c
+		}
+	}
+	d.prev, d.gone = next, gone
+	d.initialized = true
+	return deltas
+}
+
+// reset forgets all flows, the next call to diff records a new baseline.
+func (d *flowDiffer) reset() {
+	d.prev = make(map[flowKey]flowCounters)
+	d.gone = make(map[flowKey]flowCounters)
+	d.initialized = false
+}
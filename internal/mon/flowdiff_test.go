@@ -0,0 +1,85 @@
+package mon
+
+import (
+	"net"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestFlowDiffer(t *testing.T) {
+	is := is.New(t)
+	flow := func(id uint32, orig, reply uint64) Flow {
+		return Flow{
+			ID:    id,
+			Orig:  Subflow{Source: net.ParseIP("192.168.0.2"), Destination: net.ParseIP("1.1.1.1"), SPort: 1000 + int(id), DPort: 443, Bytes: orig},
+			Reply: Subflow{Source: net.ParseIP("1.1.1.1"), Destination: net.ParseIP("10.0.0.1"), SPort: 443, DPort: 1000 + int(id), Bytes: reply},
+		}
+	}
+	d := newFlowDiffer()
+
+	// baseline
+	is.Equal(0, len(d.diff(FlowSlice{flow(1, 100, 1000)}, nil)))
+
+	// existing flow grows and a new flow appears
+	deltas := d.diff(FlowSlice{flow(1, 150, 1500), flow(2, 10, 20)}, nil)
+	is.Equal(2, len(deltas))
+	is.Equal(uint64(50), deltas[0].Delta.OrigBytes)
+	is.Equal(uint64(500), deltas[0].Delta.ReplyBytes)
+	is.Equal(uint64(10), deltas[1].Delta.OrigBytes)
+	is.Equal(uint64(20), deltas[1].Delta.ReplyBytes)
+
+	// flow 1 disappears, unchanged flows produce no deltas
+	is.Equal(0, len(d.diff(FlowSlice{flow(2, 10, 20)}, nil)))
+
+	// a reused id with a different tuple is a new flow
+	f := flow(2, 5, 5)
+	f.Orig.SPort = 5000
+	deltas = d.diff(FlowSlice{f}, nil)
+	is.Equal(1, len(deltas))
+	is.Equal(uint64(5), deltas[0].Delta.OrigBytes)
+}
+
+func TestFlowDifferEnded(t *testing.T) {
+	is := is.New(t)
+	flow := func(id uint32, orig, reply uint64) Flow {
+		return Flow{
+			ID:    id,
+			Orig:  Subflow{Source: net.ParseIP("192.168.0.2"), Destination: net.ParseIP("1.1.1.1"), SPort: 1000 + int(id), DPort: 443, Bytes: orig},
+			Reply: Subflow{Source: net.ParseIP("1.1.1.1"), Destination: net.ParseIP("10.0.0.1"), SPort: 443, DPort: 1000 + int(id), Bytes: reply},
+		}
+	}
+	sum := func(deltas []flowDelta) (orig, reply uint64) {
+		for _, d := range deltas {
+			orig += d.Delta.OrigBytes
+			reply += d.Delta.ReplyBytes
+		}
+		return orig, reply
+	}
+	d := newFlowDiffer()
+
+	// flows that end before the baseline are not counted
+	is.Equal(0, len(d.diff(FlowSlice{flow(1, 100, 1000), flow(2, 10, 10)}, FlowSlice{flow(9, 5, 5)})))
+
+	// the traffic after the last dump of an ended flow and of a flow that
+	// started and ended between dumps
+	orig, reply := sum(d.diff(FlowSlice{flow(2, 10, 10)}, FlowSlice{flow(1, 150, 1200), flow(3, 20, 30)}))
+	is.Equal(uint64(70), orig)
+	is.Equal(uint64(230), reply)
+
+	// a flow that ended after the dump is counted once
+	orig, reply = sum(d.diff(FlowSlice{flow(2, 15, 15)}, FlowSlice{flow(2, 16, 17)}))
+	is.Equal(uint64(6), orig)
+	is.Equal(uint64(7), reply)
+	is.Equal(0, len(d.diff(nil, nil)))
+
+	// a flow that is reported as ended after it has disappeared from a dump
+	is.Equal(1, len(d.diff(FlowSlice{flow(4, 100, 100)}, nil)))
+	is.Equal(0, len(d.diff(nil, nil)))
+	orig, _ = sum(d.diff(nil, FlowSlice{flow(4, 110, 100)}))
+	is.Equal(uint64(10), orig)
+
+	// final counters that are older than the last dump are not counted
+	is.Equal(1, len(d.diff(FlowSlice{flow(5, 100, 100)}, nil)))
+	is.Equal(0, len(d.diff(nil, FlowSlice{flow(5, 0, 0)})))
+}
@@ -0,0 +1,297 @@
+package mon
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/some-programs/natbwmon/internal/arp"
+)
+
+// IPSet is an accounting backend that keeps the client addresses in ipset
+// hash sets with per element counters. The iptables chain has a fixed number
+// of rules that match against the sets so adding clients and matching
+// packets stays constant time regardless of the number of clients.
+//
+// Requires the ipset command.
+type IPSet struct {
+	ipt  *IPTables
+	sets []ipSet
+}
+
+// ipSet is an ipset hash:ip set of client addresses.
+type ipSet struct {
+	name string
+	ipv6 bool // the set contains IPv6 addresses
+	out  bool // the set matches source addresses
+}
+
+func NewIPSet(chain string, lan LAN, ipv6 bool) (*IPSet, error) {
+	if _, err := exec.LookPath("ipset"); err != nil {
+		return nil, err
+	}
+	ipt, err := NewIPTables(chain, lan, ipv6)
+	if err != nil {
+		return nil, err
+	}
+	families := []bool{false}
+	if ipt.ipt6 != nil {
+		families = append(families, true)
+	}
+	var sets []ipSet
+	for _, v6 := range families {
+		for _, out := range []bool{false, true} {
+			name := chain + "-in"
+			if out {
+				name = chain + "-out"
+			}
+			if v6 {
+				name += "6"
+			} else {
+				name += "4"
+			}
+			sets = append(sets, ipSet{name: name, ipv6: v6, out: out})
+		}
+	}
+	return &IPSet{
+		ipt:  ipt,
+		sets: sets,
+	}, nil
+}
+
+// Stats reads the counters of all sets.
+func (i *IPSet) Stats() (IPTStats, error) {
+	stats, err := i.save(i.sets)
+	if err != nil {
+		return IPTStats{}, err
+	}
+	createdAt := time.Now()
+	return IPTStats{
+		CreatedAt: createdAt,
+		Stats:     stats,
+	}, nil
+}
+
+// ClearChain clears the chain and empties the sets.
+func (i *IPSet) ClearChain() error {
+	if err := i.ipt.ClearChain(); err != nil {
+		return err
+	}
+	if err := i.create(); err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, s := range i.sets {
+		fmt.Fprintf(&b, "flush %s\n", s.name)
+	}
+	_, err := ipsetCmd(strings.NewReader(b.String()), "restore")
+	return err
+}
+
+// create creates the sets if they do not exist.
+func (i *IPSet) create() error {
+	var b strings.Builder
+	for _, s := range i.sets {
+		family := "inet"
+		if s.ipv6 {
+			family = "inet6"
+		}
+		fmt.Fprintf(&b, "create %s hash:ip family %s counters\n", s.name, family)
+	}
+	_, err := ipsetCmd(strings.NewReader(b.String()), "restore", "-exist")
+	return err
+}
+
+// Update makes sure the rules exist and adds all neighbors that are not
+// already counted to the sets in a single ipset restore.
+func (i *IPSet) Update(arps arp.Entries) error {
+	arps = i.ipt.lan.Filter(arps)
+
+	if err := i.create(); err != nil {
+		return err
+	}
+	if err := i.ipt.ensureJump(); err != nil {
+		return err
+	}
+	for _, s := range i.sets {
+		ipt := i.ipt.ipt
+		if s.ipv6 {
+			ipt = i.ipt.ipt6
+		}
+		dir := "dst"
+		if s.out {
+			dir = "src"
+		}
+		if err := ipt.AppendUnique("filter", i.ipt.chain, "-m", "set", "--match-set", s.name, dir); err != nil {
+			return err
+		}
+	}
+
+	// the out sets contain the same addresses
+	var in []ipSet
+	for _, s := range i.sets {
+		if !s.out {
+			in = append(in, s)
+		}
+	}
+	existing, err := i.save(in)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(existing))
+	for _, c := range existing {
+		known[c.IP] = true
+	}
+	var b strings.Builder
+	for _, a := range arps {
+		ip := net.ParseIP(a.IPAddress)
+		if ip == nil || known[ip.String()] {
+			continue
+		}
+		v6 := ip.To4() == nil
+		if v6 && !ip.IsGlobalUnicast() {
+			continue
+		}
+		for _, s := range i.sets {
+			if s.ipv6 == v6 {
+				fmt.Fprintf(&b, "add %s %s\n", s.name, ip)
+			}
+		}
+		known[ip.String()] = true
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	_, err = ipsetCmd(strings.NewReader(b.String()), "restore", "-exist")
+	return err
+}
+
+// save reads the elements of sets. ipset save lists every set on the host
+// unless it is given a set name and it only accepts one, so each set is saved
+// separately.
+func (i *IPSet) save(sets []ipSet) ([]Counter, error) {
+	var stats []Counter
+	for _, s := range sets {
+		out, err := ipsetCmd(nil, "save", s.name)
+		if err != nil {
+			return nil, err
+		}
+		cs, err := readIPSetSave(bytes.NewReader(out), []ipSet{s})
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, cs...)
+	}
+	return stats, nil
+}
+
+// Remove deletes the addresses from the sets in a single ipset restore.
+func (i *IPSet) Remove(ips []string) error {
+	script := i.removeScript(ips)
+	if script == "" {
+		return nil
+	}
+	_, err := ipsetCmd(strings.NewReader(script), "restore", "-exist")
+	return err
+}
+
+// removeScript returns the ipset restore commands that delete the addresses
+// from the sets of their address family.
+func (i *IPSet) removeScript(ips []string) string {
+	var b strings.Builder
+	for _, v := range ips {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			continue
+		}
+		for _, s := range i.sets {
+			if s.ipv6 == (ip.To4() == nil) {
+				fmt.Fprintf(&b, "del %s %s\n", s.name, ip)
+			}
+		}
+	}
+	return b.String()
+}
+
+// Enforce restricts clients with rules in the quota chain of the iptables
+// backend.
+func (i *IPSet) Enforce(limits []Limit) error {
+	return i.ipt.Enforce(limits)
+}
+
+// Delete removes the rules and the sets.
+func (i *IPSet) Delete() error {
+	if err := i.ipt.Delete(); err != nil {
+		return err
+	}
+	out, err := ipsetCmd(nil, "list", "-n")
+	if err != nil {
+		return err
+	}
+	names := strings.Fields(string(out))
+	for _, s := range i.sets {
+		for _, name := range names {
+			if name == s.name {
+				if _, err := ipsetCmd(nil, "destroy", s.name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// readIPSetSave reads the counters of the sets from ipset save output. Lines
+// for other sets are ignored.
+func readIPSetSave(r io.Reader, sets []ipSet) ([]Counter, error) {
+	byName := make(map[string]ipSet, len(sets))
+	for _, s := range sets {
+		byName[s.name] = s
+	}
+	var stats []Counter
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[0] != "add" {
+			continue
+		}
+		s, ok := byName[fields[1]]
+		if !ok {
+			continue
+		}
+		c := Counter{IP: fields[2], Out: s.out}
+		for j := 3; j+1 < len(fields); j += 2 {
+			n, err := strconv.ParseUint(fields[j+1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ipset counter: %s", scanner.Text())
+			}
+			switch fields[j] {
+			case "packets":
+				c.Packets = n
+			case "bytes":
+				c.Bytes = n
+			}
+		}
+		stats = append(stats, c)
+	}
+	return stats, scanner.Err()
+}
+
+// ipsetCmd runs ipset with the arguments and stdin and returns its output.
+func ipsetCmd(stdin io.Reader, args ...string) ([]byte, error) {
+	cmd := exec.Command("ipset", args...)
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ipset %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
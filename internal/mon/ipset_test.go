@@ -0,0 +1,45 @@
+package mon
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestReadIPSetSave(t *testing.T) {
+	is := is.New(t)
+	const save = `create other hash:ip family inet hashsize 1024 maxelem 65536
+add other 10.0.0.1
+create NATBW-in4 hash:ip family inet hashsize 1024 maxelem 65536 counters bucketsize 12 initval 0x1d4e2b1f
+add NATBW-in4 192.168.0.2 packets 12 bytes 3456
+create NATBW-out4 hash:ip family inet hashsize 1024 maxelem 65536 counters bucketsize 12 initval 0x1d4e2b20
+add NATBW-out4 192.168.0.2 packets 3 bytes 180
+`
+	sets := []ipSet{
+		{name: "NATBW-in4"},
+		{name: "NATBW-out4", out: true},
+	}
+	stats, err := readIPSetSave(strings.NewReader(save), sets)
+	is.NoErr(err)
+	is.Equal([]Counter{
+		{IP: "192.168.0.2", Bytes: 3456, Packets: 12},
+		{IP: "192.168.0.2", Out: true, Bytes: 180, Packets: 3},
+	}, stats)
+}
+
+func TestIPSetRemoveScript(t *testing.T) {
+	is := is.New(t)
+	i := &IPSet{sets: []ipSet{
+		{name: "NATBW-in4"},
+		{name: "NATBW-out4", out: true},
+		{name: "NATBW-in6", ipv6: true},
+		{name: "NATBW-out6", ipv6: true, out: true},
+	}}
+	is.Equal(i.removeScript([]string{"192.168.0.2", "2001:db8::2", "invalid"}), `del NATBW-in4 192.168.0.2
+del NATBW-out4 192.168.0.2
+del NATBW-in6 2001:db8::2
+del NATBW-out6 2001:db8::2
+`)
+	is.Equal(i.removeScript(nil), "")
+}
@@ -2,6 +2,7 @@ package mon
 
 import (
 	"fmt"
+	"net"
 	"time"
 
 	"github.com/coreos/go-iptables/iptables"
@@ -11,28 +12,77 @@ import (
 
 // IPTables takes care of managing iptables rules for tracking per client
 // bandwidth usage.
+//
+// When IPv6 is enabled a parallel chain with the same name is managed using
+// ip6tables, if ip6tables is not available only IPv4 traffic is counted.
 type IPTables struct {
 	ipt   *iptables.IPTables
+	ipt6  *iptables.IPTables // nil if IPv6 is disabled
 	chain string
-	netif string
+	lan   LAN
 }
 
-func NewIPTables(chain string, netif string) (*IPTables, error) {
+func NewIPTables(chain string, lan LAN, ipv6 bool) (*IPTables, error) {
 	ipt, err := iptables.New()
 	if err != nil {
 		return nil, err
 	}
+	var ipt6 *iptables.IPTables
+	if ipv6 {
+		ipt6, err = iptables.New(iptables.IPFamily(iptables.ProtocolIPv6))
+		if err != nil {
+			log.Warn().Err(err).Msg("ip6tables is not available, IPv6 traffic is not counted")
+			ipt6 = nil
+		}
+	}
 	return &IPTables{
 		ipt:   ipt,
+		ipt6:  ipt6,
 		chain: chain,
-		netif: netif,
+		lan:   lan,
 	}, nil
 }
 
+// tables returns all enabled iptables instances.
+func (i *IPTables) tables() []*iptables.IPTables {
+	if i.ipt6 != nil {
+		return []*iptables.IPTables{i.ipt, i.ipt6}
+	}
+	return []*iptables.IPTables{i.ipt}
+}
+
+// table returns the iptables instance for the address family of ip, nil is
+// returned if the family is not enabled.
+func (i *IPTables) table(ip string) *iptables.IPTables {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil
+	}
+	if addr.To4() != nil {
+		return i.ipt
+	}
+	return i.ipt6
+}
+
 func (i *IPTables) Stats() (IPTStats, error) {
-	stats, err := i.ipt.StructuredStats("filter", i.chain)
-	if err != nil {
-		return IPTStats{}, err
+	var stats []Counter
+	for _, ipt := range i.tables() {
+		ss, err := ipt.StructuredStats("filter", i.chain)
+		if err != nil {
+			return IPTStats{}, err
+		}
+		for _, s := range ss {
+			ip, err := getLocalIP(s)
+			if err != nil {
+				return IPTStats{}, err
+			}
+			stats = append(stats, Counter{
+				IP:      ip,
+				Out:     !s.Source.IP.IsUnspecified(),
+				Bytes:   s.Bytes,
+				Packets: s.Packets,
+			})
+		}
 	}
 	return IPTStats{
 		CreatedAt: time.Now(),
@@ -42,45 +92,147 @@ func (i *IPTables) Stats() (IPTStats, error) {
 
 // ClearChain clears and
 func (i *IPTables) ClearChain() error {
-	return i.ipt.ClearChain("filter", i.chain)
+	for _, ipt := range i.tables() {
+		if err := ipt.ClearChain("filter", i.chain); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Update updates natbw rules according to the system arp list
 func (i *IPTables) Update(arps arp.Entries) error {
-	arps = arps.FilterDeviceName(i.netif)
+	arps = i.lan.Filter(arps)
 
-	ok, err := i.ipt.Exists("filter", "FORWARD", "-j", i.chain)
-	if err != nil {
+	if err := i.ensureJump(); err != nil {
 		return err
 	}
-	if !ok {
-		err = i.ipt.Insert("filter", "FORWARD", 1, "-j", i.chain)
-		if err != nil {
-			return err
-		}
-	}
 aloop:
 	for _, a := range arps {
-		if err := i.ipt.AppendUnique("filter", i.chain, "-d", a.IPAddress, "-j", "RETURN"); err != nil {
+		ipt := i.table(a.IPAddress)
+		if ipt == nil {
+			continue aloop
+		}
+		if ipt == i.ipt6 && !net.ParseIP(a.IPAddress).IsGlobalUnicast() {
+			continue aloop
+		}
+		if err := ipt.AppendUnique("filter", i.chain, "-d", a.IPAddress, "-j", "RETURN"); err != nil {
 			log.Error().Err(err).Msg("")
 			continue aloop
 		}
-		if err := i.ipt.AppendUnique("filter", i.chain, "-s", a.IPAddress, "-j", "RETURN"); err != nil {
+		if err := ipt.AppendUnique("filter", i.chain, "-s", a.IPAddress, "-j", "RETURN"); err != nil {
 			log.Error().Err(err).Msg("")
 		}
 	}
 	return nil
 }
 
+// Remove deletes the rules of the addresses.
+func (i *IPTables) Remove(ips []string) error {
+	for _, ip := range ips {
+		ipt := i.table(ip)
+		if ipt == nil {
+			continue
+		}
+		if err := ipt.DeleteIfExists("filter", i.chain, "-d", ip, "-j", "RETURN"); err != nil {
+			return err
+		}
+		if err := ipt.DeleteIfExists("filter", i.chain, "-s", ip, "-j", "RETURN"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureJump inserts the jump to the chain in FORWARD if it does not exist.
+func (i *IPTables) ensureJump() error {
+	for _, ipt := range i.tables() {
+		ok, err := ipt.Exists("filter", "FORWARD", "-j", i.chain)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			err = ipt.Insert("filter", "FORWARD", 1, "-j", i.chain)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// quotaChain returns the name of the chain with the enforced limits. It is
+// separate from the accounting chain so that Stats only sees counters.
+func (i *IPTables) quotaChain() string {
+	return i.chain + "-QUOTA"
+}
+
+// Enforce replaces the rules in the quota chain with limits. The quota chain
+// is jumped to from FORWARD before the accounting chain so that dropped
+// traffic is not counted.
+func (i *IPTables) Enforce(limits []Limit) error {
+	chain := i.quotaChain()
+	for _, ipt := range i.tables() {
+		if err := ipt.ClearChain("filter", chain); err != nil {
+			return err
+		}
+	}
+	for n, l := range limits {
+		ipt := i.table(l.IP)
+		if ipt == nil {
+			continue
+		}
+		for _, dir := range []string{"-s", "-d"} {
+			rule := []string{dir, l.IP}
+			if l.Rate > 0 {
+				rule = append(rule,
+					"-m", "hashlimit",
+					"--hashlimit-above", fmt.Sprintf("%dkb/s", max(l.Rate/1024, 1)),
+					"--hashlimit-name", fmt.Sprintf("natbwq%d%s", n, dir[1:]),
+				)
+			}
+			rule = append(rule, "-j", "DROP")
+			if err := ipt.Append("filter", chain, rule...); err != nil {
+				return err
+			}
+		}
+	}
+	for _, ipt := range i.tables() {
+		ok, err := ipt.Exists("filter", "FORWARD", "-j", chain)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if err := ipt.Insert("filter", "FORWARD", 1, "-j", chain); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // Delete removes all rules related to natbwmon
 func (i *IPTables) Delete() error {
-	err := i.ClearChain()
+	for _, ipt := range i.tables() {
+		if err := i.delete(ipt, i.quotaChain()); err != nil {
+			return err
+		}
+		if err := i.delete(ipt, i.chain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delete removes chain and the jumps to it from FORWARD.
+func (i *IPTables) delete(ipt *iptables.IPTables, chain string) error {
+	err := ipt.ClearChain("filter", chain)
 	if err != nil {
 		return fmt.Errorf("clear iptables chain failed: %w", err)
 	}
 
 	for {
-		ok, err := i.ipt.Exists("filter", "FORWARD", "-j", i.chain)
+		ok, err := ipt.Exists("filter", "FORWARD", "-j", chain)
 		if err != nil {
 			return err
 		}
@@ -88,13 +240,13 @@ func (i *IPTables) Delete() error {
 			break
 		}
 
-		err = i.ipt.Delete("filter", "FORWARD", "-j", i.chain)
+		err = ipt.Delete("filter", "FORWARD", "-j", chain)
 		if err != nil {
 			return err
 		}
 	}
 
-	err = i.ipt.DeleteChain("filter", i.chain)
+	err = ipt.DeleteChain("filter", chain)
 	if err != nil {
 		return err
 	}
@@ -116,9 +268,3 @@ func getLocalIP(s iptables.Stat) (string, error) {
 	}
 	return "", fmt.Errorf("unexpected stat: %v", s)
 }
-
-// IPTStats .
-type IPTStats struct {
-	CreatedAt time.Time
-	Stats     []iptables.Stat
-}
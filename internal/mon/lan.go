@@ -0,0 +1,91 @@
+package mon
+
+import (
+	"net"
+	"slices"
+
+	"github.com/some-programs/natbwmon/internal/arp"
+)
+
+// LAN describes the local networks whose clients are accounted.
+//
+// Clients are either neighbors seen on one of the interfaces or any address
+// within one of the networks. The networks do not have to be directly
+// connected, clients behind a downstream router are discovered from the
+// connections they make.
+type LAN struct {
+	Interfaces []string
+	Networks   []*net.IPNet
+}
+
+// ParseLAN returns a LAN from interface names and CIDR network notations.
+func ParseLAN(interfaces []string, cidrs []string) (LAN, error) {
+	lan := LAN{Interfaces: interfaces}
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return LAN{}, err
+		}
+		lan.Networks = append(lan.Networks, n)
+	}
+	return lan, nil
+}
+
+// Contains returns true if ip is within one of the configured networks.
+func (l LAN) Contains(ip net.IP) bool {
+	return inNetworks(ip, l.Networks)
+}
+
+// Includes returns true if the entry is on one of the LAN interfaces or has an
+// address within one of the LAN networks.
+func (l LAN) Includes(a arp.Entry) bool {
+	return slices.Contains(l.Interfaces, a.Device) || l.Contains(net.ParseIP(a.IPAddress))
+}
+
+// Filter returns the entries that are included in the LAN.
+func (l LAN) Filter(arps arp.Entries) arp.Entries {
+	filtered := make(arp.Entries, 0, len(arps))
+	for _, a := range arps {
+		if l.Includes(a) {
+			filtered = append(filtered, a)
+		}
+	}
+	return filtered
+}
+
+// networks returns the networks of all LAN interfaces and the configured
+// networks.
+func (l LAN) networks() ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, netif := range l.Interfaces {
+		ns, err := interfaceNetworks(netif)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ns...)
+	}
+	return append(nets, l.Networks...), nil
+}
+
+// Discover returns entries without hardware addresses for the clients within
+// the configured networks that have connections in fs.
+func (l LAN) Discover(fs FlowSlice) arp.Entries {
+	if len(l.Networks) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var arps arp.Entries
+	for _, f := range fs {
+		ip, _ := lanEndpoint(f, l.Networks)
+		if ip == nil {
+			continue
+		}
+		key := ip.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		arps = append(arps, arp.Entry{IPAddress: key})
+	}
+	return arps
+}
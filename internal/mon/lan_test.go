@@ -0,0 +1,103 @@
+package mon
+
+import (
+	"net"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/some-programs/natbwmon/internal/arp"
+)
+
+func TestLANIncludes(t *testing.T) {
+	tests := []struct {
+		name       string
+		interfaces []string
+		cidrs      []string
+		entry      arp.Entry
+		include    bool
+	}{
+		{"nothing configured", nil, nil, arp.Entry{IPAddress: "192.168.0.2", Device: "br0"}, false},
+		{"interface", []string{"br0"}, nil, arp.Entry{IPAddress: "192.168.0.2", Device: "br0"}, true},
+		{"other interface", []string{"br0"}, nil, arp.Entry{IPAddress: "192.168.0.2", Device: "eth0"}, false},
+		{"any interface", []string{"br0", "wg0"}, nil, arp.Entry{IPAddress: "10.8.0.2", Device: "wg0"}, true},
+		{"network", nil, []string{"192.168.1.0/24"}, arp.Entry{IPAddress: "192.168.1.2", Device: "eth1"}, true},
+		{"outside network", nil, []string{"192.168.1.0/24"}, arp.Entry{IPAddress: "192.168.2.2", Device: "eth1"}, false},
+		{"network without device", nil, []string{"192.168.1.0/24"}, arp.Entry{IPAddress: "192.168.1.2"}, true},
+		{"ipv6 network", nil, []string{"2001:db8::/64"}, arp.Entry{IPAddress: "2001:db8::2"}, true},
+		{"ipv6 outside network", nil, []string{"2001:db8::/64"}, arp.Entry{IPAddress: "2001:db8:1::2"}, false},
+		{"interface or network", []string{"br0"}, []string{"192.168.1.0/24"}, arp.Entry{IPAddress: "192.168.1.2", Device: "eth1"}, true},
+		{"neither interface nor network", []string{"br0"}, []string{"192.168.1.0/24"}, arp.Entry{IPAddress: "192.168.2.2", Device: "eth1"}, false},
+		{"invalid address on interface", []string{"br0"}, []string{"192.168.1.0/24"}, arp.Entry{IPAddress: "invalid", Device: "br0"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			lan, err := ParseLAN(tt.interfaces, tt.cidrs)
+			is.NoErr(err)
+			is.Equal(lan.Includes(tt.entry), tt.include)
+		})
+	}
+}
+
+func TestParseLAN(t *testing.T) {
+	is := is.New(t)
+	lan, err := ParseLAN([]string{"br0"}, []string{"192.168.1.7/24", "2001:db8::/64"})
+	is.NoErr(err)
+	is.Equal(lan.Interfaces, []string{"br0"})
+	is.Equal(len(lan.Networks), 2)
+	is.Equal(lan.Networks[0].String(), "192.168.1.0/24")
+	_, err = ParseLAN(nil, []string{"192.168.1.0"})
+	is.True(err != nil)
+}
+
+func TestLANDiscover(t *testing.T) {
+	flow := func(src, dst string) Flow {
+		return Flow{
+			Proto: "tcp",
+			Orig:  Subflow{Source: net.ParseIP(src), Destination: net.ParseIP(dst), SPort: 40000, DPort: 443},
+			Reply: Subflow{Source: net.ParseIP(dst), Destination: net.ParseIP(src), SPort: 443, DPort: 40000},
+		}
+	}
+	tests := []struct {
+		name  string
+		cidrs []string
+		flows FlowSlice
+		want  []string
+	}{
+		{"no networks", nil, FlowSlice{flow("192.168.1.2", "203.0.113.1")}, nil},
+		{"outgoing", []string{"192.168.1.0/24"}, FlowSlice{flow("192.168.1.2", "203.0.113.1")}, []string{"192.168.1.2"}},
+		{"incoming", []string{"192.168.1.0/24"}, FlowSlice{flow("203.0.113.1", "192.168.1.3")}, []string{"192.168.1.3"}},
+		{"outside networks", []string{"192.168.1.0/24"}, FlowSlice{flow("192.168.2.2", "203.0.113.1")}, nil},
+		{
+			"once per address",
+			[]string{"192.168.1.0/24"},
+			FlowSlice{
+				flow("192.168.1.2", "203.0.113.1"),
+				flow("192.168.1.2", "203.0.113.2"),
+				flow("203.0.113.3", "192.168.1.2"),
+				flow("192.168.1.4", "203.0.113.1"),
+			},
+			[]string{"192.168.1.2", "192.168.1.4"},
+		},
+		{
+			"several networks",
+			[]string{"192.168.1.0/24", "2001:db8::/64"},
+			FlowSlice{flow("2001:db8::2", "2001:db8:1::1"), flow("192.168.1.2", "203.0.113.1")},
+			[]string{"2001:db8::2", "192.168.1.2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+			lan, err := ParseLAN(nil, tt.cidrs)
+			is.NoErr(err)
+			arps := lan.Discover(tt.flows)
+			var ips []string
+			for _, a := range arps {
+				is.Equal(a.HWAddress, "")
+				ips = append(ips, a.IPAddress)
+			}
+			is.Equal(ips, tt.want)
+		})
+	}
+}
@@ -13,6 +13,26 @@ func isLocalIP(ip net.IP) bool {
 	return false
 }
 
+// interfaceNetworks returns the networks of the addresses assigned to the
+// named network interface.
+func interfaceNetworks(name string) ([]*net.IPNet, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	nets := make([]*net.IPNet, 0, len(addrs))
+	for _, addr := range addrs {
+		if n, ok := addr.(*net.IPNet); ok {
+			nets = append(nets, n)
+		}
+	}
+	return nets, nil
+}
+
 func init() {
 	addresses, err := net.InterfaceAddrs()
 	if err != nil {
@@ -0,0 +1,310 @@
+package mon
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/some-programs/natbwmon/internal/arp"
+	"golang.org/x/sys/unix"
+)
+
+// NFTables is an accounting backend that uses a dedicated nftables table with
+// a single set of all clients. The set keeps a counter per element so the
+// chain has a fixed number of rules and both updating the clients and
+// matching packets are hash lookups regardless of the number of clients.
+//
+// The elements are keyed by the direction and the address of a client, IPv4
+// addresses are stored as IPv4-mapped IPv6 addresses, so the counters of all
+// clients are read atomically in one dump.
+//
+// Per element counters are updated by set lookups since Linux 5.11.
+type NFTables struct {
+	table *nftables.Table
+	chain *nftables.Chain
+	set   *nftables.Set
+	ipv6  bool
+	lan   LAN
+}
+
+var (
+	// the direction part of the set keys
+	nftIn  = []byte{0, 0, 0, 0}
+	nftOut = []byte{0, 0, 0, 1}
+
+	// the prefix of IPv4-mapped IPv6 addresses
+	v4InV6Prefix = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff}
+)
+
+func NewNFTables(table string, lan LAN, ipv6 bool) (*NFTables, error) {
+	keyType, err := nftables.ConcatSetType(nftables.TypeMark, nftables.TypeIP6Addr)
+	if err != nil {
+		return nil, err
+	}
+	t := &nftables.Table{
+		Name:   table,
+		Family: nftables.TableFamilyINet,
+	}
+	return &NFTables{
+		table: t,
+		chain: &nftables.Chain{
+			Name:     "forward",
+			Table:    t,
+			Type:     nftables.ChainTypeFilter,
+			Hooknum:  nftables.ChainHookForward,
+			Priority: nftables.ChainPriorityRef(*nftables.ChainPriorityFilter - 1),
+		},
+		set: &nftables.Set{
+			Table:         t,
+			Name:          "clients",
+			KeyType:       keyType,
+			Concatenation: true,
+			Counter:       true,
+		},
+		ipv6: ipv6,
+		lan:  lan,
+	}, nil
+}
+
+// nftKey returns the set key of the address in the direction.
+func nftKey(ip net.IP, out bool) []byte {
+	dir := nftIn
+	if out {
+		dir = nftOut
+	}
+	return append(slices.Clone(dir), ip.To16()...)
+}
+
+// parseNftKey returns the address and direction of a set key.
+func parseNftKey(key []byte) (ip net.IP, out bool, ok bool) {
+	if len(key) != len(nftIn)+net.IPv6len {
+		return nil, false, false
+	}
+	dir := key[:len(nftIn)]
+	if !bytes.Equal(dir, nftIn) && !bytes.Equal(dir, nftOut) {
+		return nil, false, false
+	}
+	return net.IP(slices.Clone(key[len(nftIn):])), bytes.Equal(dir, nftOut), true
+}
+
+// Stats reads the counters of all clients in one dump.
+func (n *NFTables) Stats() (IPTStats, error) {
+	c, err := nftables.New()
+	if err != nil {
+		return IPTStats{}, err
+	}
+	elems, err := c.GetSetElements(n.set)
+	if err != nil {
+		return IPTStats{}, fmt.Errorf("could not read nftables set %s: %w", n.set.Name, err)
+	}
+	createdAt := time.Now()
+	stats := make([]Counter, 0, len(elems))
+	for _, e := range elems {
+		ip, out, ok := parseNftKey(e.Key)
+		if !ok || e.Counter == nil {
+			continue
+		}
+		stats = append(stats, Counter{
+			IP:      ip.String(),
+			Out:     out,
+			Bytes:   e.Counter.Bytes,
+			Packets: e.Counter.Packets,
+		})
+	}
+	return IPTStats{
+		CreatedAt: createdAt,
+		Stats:     stats,
+	}, nil
+}
+
+// ClearChain recreates the table with an empty set.
+func (n *NFTables) ClearChain() error {
+	if err := n.Delete(); err != nil {
+		return err
+	}
+	c, err := nftables.New()
+	if err != nil {
+		return err
+	}
+	c.AddTable(n.table)
+	c.AddChain(n.chain)
+	if err := c.AddSet(n.set, nil); err != nil {
+		return err
+	}
+	families := []bool{false}
+	if n.ipv6 {
+		families = append(families, true)
+	}
+	for _, v6 := range families {
+		for _, out := range []bool{false, true} {
+			c.AddRule(&nftables.Rule{
+				Table: n.table,
+				Chain: n.chain,
+				Exprs: n.lookup(v6, out),
+			})
+		}
+	}
+	return c.Flush()
+}
+
+// lookup returns expressions that look up the key of the destination
+// address, or the source address if out is true, in the set.
+func (n *NFTables) lookup(ipv6 bool, out bool) []expr.Any {
+	dir := nftIn
+	if out {
+		dir = nftOut
+	}
+	// the key is loaded into the consecutive 32 bit registers starting at
+	// 8, the direction followed by the IPv6 or IPv4-mapped address.
+	exprs := []expr.Any{
+		&expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{nfproto(ipv6)}},
+		&expr.Immediate{Register: 8, Data: dir},
+	}
+	if ipv6 {
+		exprs = append(exprs, loadAddr(true, out, 9))
+	} else {
+		exprs = append(exprs,
+			&expr.Immediate{Register: 9, Data: v4InV6Prefix},
+			loadAddr(false, out, 12),
+		)
+	}
+	return append(exprs, &expr.Lookup{
+		SourceRegister: 8,
+		SetName:        n.set.Name,
+		SetID:          n.set.ID,
+	})
+}
+
+// Update adds all neighbors that are not already counted to the set.
+func (n *NFTables) Update(arps arp.Entries) error {
+	arps = n.lan.Filter(arps)
+
+	c, err := nftables.New()
+	if err != nil {
+		return err
+	}
+	existing, err := n.existing(c)
+	if err != nil {
+		return err
+	}
+	var elems []nftables.SetElement
+	for _, a := range arps {
+		ip := net.ParseIP(a.IPAddress)
+		if ip == nil || existing[ip.String()] {
+			continue
+		}
+		if ip.To4() == nil && (!n.ipv6 || !ip.IsGlobalUnicast()) {
+			continue
+		}
+		elems = append(elems,
+			nftables.SetElement{Key: nftKey(ip, false)},
+			nftables.SetElement{Key: nftKey(ip, true)},
+		)
+		existing[ip.String()] = true
+	}
+	if len(elems) == 0 {
+		return nil
+	}
+	if err := c.SetAddElements(n.set, elems); err != nil {
+		return err
+	}
+	return c.Flush()
+}
+
+// existing returns the addresses that are in the set.
+func (n *NFTables) existing(c *nftables.Conn) (map[string]bool, error) {
+	elems, err := c.GetSetElements(n.set)
+	if err != nil {
+		return nil, fmt.Errorf("could not read nftables set %s: %w", n.set.Name, err)
+	}
+	existing := make(map[string]bool, len(elems)/2)
+	for _, e := range elems {
+		if ip, _, ok := parseNftKey(e.Key); ok {
+			existing[ip.String()] = true
+		}
+	}
+	return existing, nil
+}
+
+// Remove deletes the addresses from the set.
+func (n *NFTables) Remove(ips []string) error {
+	c, err := nftables.New()
+	if err != nil {
+		return err
+	}
+	existing, err := n.existing(c)
+	if err != nil {
+		return err
+	}
+	var elems []nftables.SetElement
+	for _, v := range ips {
+		ip := net.ParseIP(v)
+		if ip == nil || !existing[ip.String()] {
+			continue
+		}
+		elems = append(elems,
+			nftables.SetElement{Key: nftKey(ip, false)},
+			nftables.SetElement{Key: nftKey(ip, true)},
+		)
+	}
+	if len(elems) == 0 {
+		return nil
+	}
+	if err := c.SetDeleteElements(n.set, elems); err != nil {
+		return err
+	}
+	return c.Flush()
+}
+
+// Delete removes the natbwmon nftables table.
+func (n *NFTables) Delete() error {
+	c, err := nftables.New()
+	if err != nil {
+		return err
+	}
+	tables, err := c.ListTablesOfFamily(n.table.Family)
+	if err != nil {
+		return err
+	}
+	for _, t := range tables {
+		if t.Name == n.table.Name {
+			c.DelTable(n.table)
+			return c.Flush()
+		}
+	}
+	return nil
+}
+
+// nfproto returns the netfilter protocol family of the address family.
+func nfproto(ipv6 bool) byte {
+	if ipv6 {
+		return unix.NFPROTO_IPV6
+	}
+	return unix.NFPROTO_IPV4
+}
+
+// loadAddr returns an expression that loads the source address if src is true
+// or the destination address otherwise into the register.
+func loadAddr(ipv6 bool, src bool, register uint32) expr.Any {
+	offset, size := uint32(16), uint32(net.IPv4len)
+	if src {
+		offset = 12
+	}
+	if ipv6 {
+		offset, size = 24, net.IPv6len
+		if src {
+			offset = 8
+		}
+	}
+	return &expr.Payload{
+		DestRegister: register,
+		Base:         expr.PayloadBaseNetworkHeader,
+		Offset:       offset,
+		Len:          size,
+	}
+}
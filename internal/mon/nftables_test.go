@@ -0,0 +1,55 @@
+package mon
+
+import (
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/google/nftables/expr"
+	"github.com/matryer/is"
+)
+
+func TestNftKey(t *testing.T) {
+	is := is.New(t)
+	for _, tt := range []struct {
+		ip  string
+		out bool
+	}{
+		{"192.168.0.2", false},
+		{"192.168.0.2", true},
+		{"2001:db8::2", false},
+		{"2001:db8::2", true},
+	} {
+		key := nftKey(net.ParseIP(tt.ip), tt.out)
+		is.Equal(len(key), 20) // the key length of the set
+		ip, out, ok := parseNftKey(key)
+		is.True(ok)
+		is.Equal(ip.String(), tt.ip)
+		is.Equal(out, tt.out)
+	}
+	is.Equal(nftKey(net.ParseIP("192.168.0.2"), true)[4:], slices.Concat(v4InV6Prefix, []byte{192, 168, 0, 2}))
+
+	_, _, ok := parseNftKey(append([]byte{0, 0, 0, 2}, net.ParseIP("192.168.0.2").To16()...))
+	is.True(!ok)
+	_, _, ok = parseNftKey([]byte{0, 0, 0, 0, 192, 168, 0, 2})
+	is.True(!ok)
+}
+
+func TestNFTablesLookup(t *testing.T) {
+	is := is.New(t)
+	n, err := NewNFTables("natbwmon", LAN{}, true)
+	is.NoErr(err)
+	is.Equal(n.set.KeyType.Bytes, uint32(20))
+
+	// the IPv4 address follows the IPv4-mapped prefix in the last register
+	exprs := n.lookup(false, true)
+	is.Equal(exprs[2], &expr.Immediate{Register: 8, Data: nftOut})
+	is.Equal(exprs[3], &expr.Immediate{Register: 9, Data: v4InV6Prefix})
+	is.Equal(exprs[4], &expr.Payload{DestRegister: 12, Base: expr.PayloadBaseNetworkHeader, Offset: 12, Len: 4})
+	is.Equal(exprs[5].(*expr.Lookup).SourceRegister, uint32(8))
+
+	exprs = n.lookup(true, false)
+	is.Equal(exprs[2], &expr.Immediate{Register: 8, Data: nftIn})
+	is.Equal(exprs[3], &expr.Payload{DestRegister: 9, Base: expr.PayloadBaseNetworkHeader, Offset: 24, Len: 16})
+	is.Equal(exprs[4].(*expr.Lookup).SetName, "clients")
+}
@@ -0,0 +1,226 @@
+package mon
+
+import (
+	"cmp"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// remoteBuckets is the number of buckets the rolling window of a
+// RemoteMatrix is divided into.
+const remoteBuckets = 24
+
+// RemoteMatrix accounts the traffic of each client per remote network (/24
+// for IPv4 and /48 for IPv6) and protocol by diffing the conntrack counters
+// between updates, with the same limitations as ConntrackAccounting.
+//
+// Both the totals since an entry was created and the totals during a rolling
+// window are kept. The number of entries is bounded, when it is exceeded the
+// entries with the least traffic during the window are evicted.
+type RemoteMatrix struct {
+	flows      func() (FlowSlice, error)
+	lan        LAN
+	window     time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	differ  *flowDiffer
+	entries map[remoteKey]*remoteEntry
+}
+
+type remoteKey struct {
+	client string
+	remote string
+	proto  string
+}
+
+type remoteEntry struct {
+	total    amountInOut
+	buckets  [remoteBuckets]remoteBucket
+	lastSeen time.Time
+}
+
+// remoteBucket is the traffic during the n:th bucket sized period since the
+// unix epoch.
+type remoteBucket struct {
+	n       int64
+	traffic amountInOut
+}
+
+type amountInOut struct {
+	in, out uint64
+}
+
+// RemoteTraffic is the traffic between a client and a remote network.
+type RemoteTraffic struct {
+	Client         string    `json:"client"`
+	Remote         string    `json:"remote"` // network in CIDR notation
+	Proto          string    `json:"proto"`
+	InBytes        uint64    `json:"in_bytes"` // since the entry was created
+	OutBytes       uint64    `json:"out_bytes"`
+	WindowInBytes  uint64    `json:"window_in_bytes"` // during the rolling window
+	WindowOutBytes uint64    `json:"window_out_bytes"`
+	LastSeen       time.Time `json:"last_seen"`
+}
+
+// WindowBytes returns the sum of the in and out bytes during the window.
+func (t RemoteTraffic) WindowBytes() uint64 {
+	return t.WindowInBytes + t.WindowOutBytes
+}
+
+func NewRemoteMatrix(flows func() (FlowSlice, error), lan LAN, window time.Duration, maxEntries int) *RemoteMatrix {
+	return &RemoteMatrix{
+		flows:      flows,
+		lan:        lan,
+		window:     window,
+		maxEntries: maxEntries,
+		differ:     newFlowDiffer(),
+		entries:    make(map[remoteKey]*remoteEntry),
+	}
+}
+
+// Window returns the length of the rolling window.
+func (m *RemoteMatrix) Window() time.Duration {
+	return m.window
+}
+
+// Update adds the traffic since the previous update.
+func (m *RemoteMatrix) Update() error {
+	lan, err := m.lan.networks()
+	if err != nil {
+		return err
+	}
+	fs, err := m.flows()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.add(m.differ.diff(fs, nil), lan, now)
+	return nil
+}
+
+// add adds the deltas, m.mu must be held.
+func (m *RemoteMatrix) add(deltas []flowDelta, lan []*net.IPNet, now time.Time) {
+	bucket := m.bucket(now)
+	for _, d := range deltas {
+		client, out := lanEndpoint(d.Flow, lan)
+		if client == nil {
+			continue
+		}
+		remote := d.Flow.Orig.Source
+		if out {
+			remote = d.Flow.Orig.Destination
+		}
+		k := remoteKey{
+			client: client.String(),
+			remote: remoteNetwork(remote).String(),
+			proto:  d.Flow.Proto,
+		}
+		e, ok := m.entries[k]
+		if !ok {
+			e = &remoteEntry{}
+			m.entries[k] = e
+		}
+		traffic := amountInOut{in: d.Delta.ReplyBytes, out: d.Delta.OrigBytes}
+		if !out {
+			traffic.in, traffic.out = traffic.out, traffic.in
+		}
+		e.total.in += traffic.in
+		e.total.out += traffic.out
+		b := &e.buckets[bucket%remoteBuckets]
+		if b.n != bucket {
+			*b = remoteBucket{n: bucket}
+		}
+		b.traffic.in += traffic.in
+		b.traffic.out += traffic.out
+		e.lastSeen = now
+	}
+	if len(m.entries) > m.maxEntries {
+		m.evict(bucket)
+	}
+}
+
+// bucket returns the number of the bucket that t is in.
+func (m *RemoteMatrix) bucket(t time.Time) int64 {
+	size := max(int64(m.window/remoteBuckets), 1)
+	return t.UnixNano() / size
+}
+
+// evict removes the entries with the least traffic during the window until
+// there is room for a tenth of maxEntries new entries, m.mu must be held.
+func (m *RemoteMatrix) evict(bucket int64) {
+	ts := m.traffic(bucket)
+	slices.SortFunc(ts, orderRemoteTraffic)
+	keep := m.maxEntries - m.maxEntries/10
+	for _, t := range ts[min(keep, len(ts)):] {
+		delete(m.entries, remoteKey{client: t.Client, remote: t.Remote, proto: t.Proto})
+	}
+}
+
+// traffic returns all entries, m.mu must be held.
+func (m *RemoteMatrix) traffic(bucket int64) []RemoteTraffic {
+	res := make([]RemoteTraffic, 0, len(m.entries))
+	for k, e := range m.entries {
+		t := RemoteTraffic{
+			Client:   k.client,
+			Remote:   k.remote,
+			Proto:    k.proto,
+			InBytes:  e.total.in,
+			OutBytes: e.total.out,
+			LastSeen: e.lastSeen,
+		}
+		for _, b := range e.buckets {
+			if b.n > bucket-remoteBuckets && b.n <= bucket {
+				t.WindowInBytes += b.traffic.in
+				t.WindowOutBytes += b.traffic.out
+			}
+		}
+		res = append(res, t)
+	}
+	return res
+}
+
+// Top returns the entries with the most traffic during the window, at most n
+// unless n is 0. If clients is not empty only the entries of clients with
+// those addresses are returned.
+func (m *RemoteMatrix) Top(n int, clients ...string) []RemoteTraffic {
+	m.mu.Lock()
+	ts := m.traffic(m.bucket(time.Now()))
+	m.mu.Unlock()
+	if len(clients) > 0 {
+		ts = slices.DeleteFunc(ts, func(t RemoteTraffic) bool {
+			return !slices.Contains(clients, t.Client)
+		})
+	}
+	slices.SortFunc(ts, orderRemoteTraffic)
+	if n > 0 && len(ts) > n {
+		ts = ts[:n]
+	}
+	return ts
+}
+
+// orderRemoteTraffic orders by window bytes, total bytes and last seen time,
+// the most active first.
+func orderRemoteTraffic(a, b RemoteTraffic) int {
+	if c := cmp.Compare(b.WindowBytes(), a.WindowBytes()); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(b.InBytes+b.OutBytes, a.InBytes+a.OutBytes); c != 0 {
+		return c
+	}
+	return b.LastSeen.Compare(a.LastSeen)
+}
+
+// remoteNetwork returns the /24 or /48 network of ip.
+func remoteNetwork(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		mask := net.CIDRMask(24, 32)
+		return &net.IPNet{IP: ip4.Mask(mask), Mask: mask}
+	}
+	mask := net.CIDRMask(48, 128)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
@@ -0,0 +1,52 @@
+package mon
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestRemoteMatrix(t *testing.T) {
+	is := is.New(t)
+	_, lan, err := net.ParseCIDR("192.168.0.0/24")
+	is.NoErr(err)
+	m := NewRemoteMatrix(nil, LAN{}, 24*time.Hour, 2)
+	delta := func(client, remote, proto string, sent, received uint64) flowDelta {
+		return flowDelta{
+			Flow: Flow{
+				Proto: proto,
+				Orig:  Subflow{Source: net.ParseIP(client), Destination: net.ParseIP(remote)},
+				Reply: Subflow{Source: net.ParseIP(remote), Destination: net.ParseIP("10.0.0.1")},
+			},
+			Delta: flowCounters{OrigBytes: sent, ReplyBytes: received},
+		}
+	}
+	start := time.Now().Add(-48 * time.Hour)
+	m.add([]flowDelta{
+		delta("192.168.0.2", "1.1.1.1", "tcp", 10, 100),
+		delta("192.168.0.2", "1.1.1.200", "tcp", 10, 100),
+		delta("192.168.0.2", "2001:db8:1:2::1", "udp", 1, 1),
+	}, []*net.IPNet{lan}, start)
+	now := time.Now()
+	m.add([]flowDelta{
+		delta("192.168.0.2", "1.1.1.1", "tcp", 5, 50),
+		delta("192.168.0.3", "8.8.8.8", "udp", 1, 2),
+	}, []*net.IPNet{lan}, now)
+
+	// the entry without traffic during the window was evicted
+	ts := m.Top(0)
+	is.Equal(2, len(ts))
+	is.Equal(RemoteTraffic{
+		Client: "192.168.0.2", Remote: "1.1.1.0/24", Proto: "tcp",
+		InBytes: 250, OutBytes: 25, WindowInBytes: 50, WindowOutBytes: 5,
+		LastSeen: now,
+	}, ts[0])
+	is.Equal("8.8.8.0/24", ts[1].Remote)
+	is.Equal(uint64(2), ts[1].WindowInBytes)
+
+	ts = m.Top(1, "192.168.0.3")
+	is.Equal(1, len(ts))
+	is.Equal("192.168.0.3", ts[0].Client)
+}
@@ -2,26 +2,36 @@ package mon
 
 import (
 	"fmt"
+	"net"
+	"slices"
 	"sync"
 	"time"
 
-	"github.com/coreos/go-iptables/iptables"
 	"github.com/mxmCherry/movavg"
 	"github.com/some-programs/natbwmon/internal/arp"
 	"github.com/some-programs/natbwmon/internal/clientstats"
+	"github.com/some-programs/natbwmon/internal/events"
 	"github.com/some-programs/natbwmon/internal/log"
 )
 
 // Client represent a device on the network, a client is tracked by it's IP
-// address.
+// address. IPv6 addresses are attributed to the client that has the same
+// hardware address.
 type Client struct {
-	in        *counter
-	out       *counter
+	in4       *counter
+	out4      *counter
+	in6       *counter
+	out6      *counter
+	addrs     map[string]*addrCounter
+	period    periodStart
 	CreatedAt time.Time
 	UpdatedAt time.Time
-	IP        string
+	LastSeen  time.Time // last time the client had traffic or was seen as a neighbor
+	IP        string    // primary address, IPv4 if one is known
+	IP6       []string  // IPv6 addresses attributed to the client
 	HWAddr    string
 	Name      string
+	Interface string // the network interface the client was last seen on
 }
 
 func NewClient(ip string, avgSamples int) *Client {
@@ -31,66 +41,149 @@ func NewClient(ip string, avgSamples int) *Client {
 		log.Warn().Err(err).Msg("")
 	}
 
-	return &Client{
-		in: &counter{
-			avg: movavg.NewSMA(avgSamples),
-		},
-		out: &counter{
-			avg: movavg.NewSMA(avgSamples),
-		},
+	c := &Client{
+		in4:       newCounter(avgSamples),
+		out4:      newCounter(avgSamples),
+		in6:       newCounter(avgSamples),
+		out6:      newCounter(avgSamples),
+		addrs:     make(map[string]*addrCounter),
+		period:    periodStart{time: now},
 		CreatedAt: now,
 		UpdatedAt: now,
+		LastSeen:  now,
 		IP:        ip,
 		Name:      name,
 	}
+	c.addAddr(ip, &addrCounter{})
+	return c
 }
 
 func (c *Client) Stat() clientstats.Stat {
-	or := c.out.avg.Avg()
-	if or < 0.0001 {
-		or = 0
+	in4, out4 := c.in4.rate(), c.out4.rate()
+	in6, out6 := c.in6.rate(), c.out6.rate()
+	in, out := c.total()
+	return clientstats.Stat{
+		IP:               c.IP,
+		IP6:              slices.Clone(c.IP6),
+		HWAddr:           c.HWAddr,
+		Name:             c.Name,
+		Interface:        c.Interface,
+		OutRate:          out4 + out6,
+		InRate:           in4 + in6,
+		OutRateV4:        out4,
+		InRateV4:         in4,
+		OutRateV6:        out6,
+		InRateV6:         in6,
+		InPacketRate:     c.in4.packetRate() + c.in6.packetRate(),
+		OutPacketRate:    c.out4.packetRate() + c.out6.packetRate(),
+		InBytes:          in.bytes,
+		OutBytes:         out.bytes,
+		InPackets:        in.packets,
+		OutPackets:       out.packets,
+		PeriodStart:      c.period.time,
+		PeriodInBytes:    in.bytes - c.period.in.bytes,
+		PeriodOutBytes:   out.bytes - c.period.out.bytes,
+		PeriodInPackets:  in.packets - c.period.in.packets,
+		PeriodOutPackets: out.packets - c.period.out.packets,
+		FirstSeen:        c.CreatedAt,
+		LastSeen:         c.LastSeen,
+	}
+}
+
+// total returns the traffic counted since the client was first seen.
+func (c *Client) total() (in, out amount) {
+	in = c.in4.total.add(c.in6.total)
+	out = c.out4.total.add(c.out6.total)
+	return in, out
+}
+
+// resetPeriod starts a new period of counted traffic at t.
+func (c *Client) resetPeriod(t time.Time) {
+	in, out := c.total()
+	c.period = periodStart{time: t, in: in, out: out}
+}
+
+// addAddr attributes the address ip to the client.
+func (c *Client) addAddr(ip string, ac *addrCounter) {
+	c.addrs[ip] = ac
+	if !isIPv6(ip) {
+		c.IP = ip
+		return
+	}
+	if !slices.Contains(c.IP6, ip) {
+		c.IP6 = append(c.IP6, ip)
 	}
-	ir := c.in.avg.Avg()
-	if ir < 0.0001 {
-		ir = 0
+	if c.IP == "" {
+		c.IP = ip
 	}
+}
 
-	return clientstats.Stat{
-		IP:      c.IP,
-		HWAddr:  c.HWAddr,
-		Name:    c.Name,
-		OutRate: or,
-		InRate:  ir,
+// removeAddr removes the address ip from the client and returns its counter.
+func (c *Client) removeAddr(ip string) *addrCounter {
+	ac := c.addrs[ip]
+	delete(c.addrs, ip)
+	c.IP6 = slices.DeleteFunc(c.IP6, func(v string) bool { return v == ip })
+	if c.IP == ip {
+		c.IP = ""
+		for addr := range c.addrs {
+			if c.IP == "" || !isIPv6(addr) {
+				c.IP = addr
+			}
+		}
 	}
+	return ac
 }
 
-func (c *Client) UpdateIPTables(s iptables.Stat, timestamp time.Time) {
-	var count *counter
-	if !s.Source.IP.IsUnspecified() {
-		count = c.out
-	} else {
-		count = c.in
+// updateCounter updates the counters for the address in s and adds the
+// number of new bytes and packets to d.
+func (c *Client) updateCounter(ip string, s Counter, d *delta) {
+	ac, ok := c.addrs[ip]
+	if !ok {
+		ac = &addrCounter{}
+		c.addrs[ip] = ac
 	}
-	db := s.Bytes - count.bytes
-	if count.bytes > s.Bytes {
-		log.Warn().Msgf("resetting due to overflow: %v %v", s, count)
-		db = 0
+	out := s.Out
+	last := &ac.in
+	if out {
+		last = &ac.out
 	}
-	dur := float64(timestamp.Sub(count.updatedAt))
-	if dur > 0 {
-		perSecond := float64(time.Second) / dur
-		count.avg.Add(float64(db) * perSecond)
-		count.bytes = s.Bytes
-		count.updatedAt = timestamp
-	} else {
-		log.Warn().Msgf("no time difference, skipping updating rate counter %v %v", dur, db)
+	next := amount{bytes: s.Bytes, packets: s.Packets}
+	n := next.sub(*last)
+	if last.bytes > s.Bytes || last.packets > s.Packets {
+		log.Warn().Msgf("resetting due to overflow: %v %v", s, *last)
+		n = amount{}
 	}
+	*last = next
+	switch {
+	case out && isIPv6(ip):
+		d.out6 = d.out6.add(n)
+	case out:
+		d.out4 = d.out4.add(n)
+	case isIPv6(ip):
+		d.in6 = d.in6.add(n)
+	default:
+		d.in4 = d.in4.add(n)
+	}
+}
+
+// updateRates adds the traffic counted since the last update to the totals
+// and the rate averages.
+func (c *Client) updateRates(d delta, timestamp time.Time) {
+	c.in4.add(d.in4, timestamp)
+	c.out4.add(d.out4, timestamp)
+	c.in6.add(d.in6, timestamp)
+	c.out6.add(d.out6, timestamp)
 	c.UpdatedAt = time.Now()
+	if d != (delta{}) {
+		c.LastSeen = c.UpdatedAt
+	}
 }
 
 func (c *Client) UpdateArp(a arp.Entry) {
 	c.UpdatedAt = time.Now()
+	c.LastSeen = c.UpdatedAt
 	c.HWAddr = a.HWAddress
+	c.Interface = a.Device
 }
 
 func (c *Client) UpdateName(name string) {
@@ -103,18 +196,28 @@ func (c *Client) UpdateName(name string) {
 // Access to the data is protected by a single mutex because it is expected
 // that it is more than enough for this use case.
 type Clients struct {
-	cs map[string]*Client
+	cs map[string]*Client // by IP address, a client can have several addresses
+	hw map[string]*Client // by hardware address
 	mu sync.Mutex
 
 	avgSamples  int
 	hostAliases map[string]string
+	hostGroups  map[string]string
+	events      *events.Bus
+
+	updated chan struct{} // closed by the next UpdateIPTables, nil until requested
 }
 
-func NewClients(avgSamples int, hostAliases map[string]string) *Clients {
+// NewClients returns an empty client store. Clients joining and leaving are
+// published to bus, which may be nil.
+func NewClients(avgSamples int, hostAliases map[string]string, hostGroups map[string]string, bus *events.Bus) *Clients {
 	return &Clients{
 		cs:          make(map[string]*Client, 0),
+		hw:          make(map[string]*Client, 0),
 		avgSamples:  avgSamples,
 		hostAliases: hostAliases,
+		hostGroups:  hostGroups,
+		events:      bus,
 	}
 }
 
@@ -122,41 +225,104 @@ func (c *Clients) UpdateIPTables(stats IPTStats) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	var joined []*Client
+	deltas := make(map[*Client]*delta, len(c.cs))
 	for _, s := range stats.Stats {
-		ip, err := getLocalIP(s)
-		if err != nil {
-			return err
-		}
+		ip := s.IP
 		client, ok := c.cs[ip]
-		if ok {
-			client.UpdateIPTables(s, stats.CreatedAt)
-		} else {
+		if !ok {
 			client = NewClient(ip, c.avgSamples)
-			client.UpdateIPTables(s, stats.CreatedAt)
 			c.cs[ip] = client
+			joined = append(joined, client)
 		}
+		d, ok := deltas[client]
+		if !ok {
+			d = &delta{}
+			deltas[client] = d
+		}
+		client.updateCounter(ip, s, d)
+	}
+	for client, d := range deltas {
+		client.updateRates(*d, stats.CreatedAt)
+	}
+	for _, client := range joined {
+		c.publish(events.Join, client)
+	}
+	if c.updated != nil {
+		close(c.updated)
+		c.updated = nil
 	}
 	return nil
 }
 
+// Updated returns a channel that is closed when UpdateIPTables has completed
+// the next time.
+func (c *Clients) Updated() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.updated == nil {
+		c.updated = make(chan struct{})
+	}
+	return c.updated
+}
+
 func (c *Clients) UpdateArp(as arp.Entries) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	var joined []*Client
 	for _, a := range as {
 		ip := a.IPAddress
 		client, ok := c.cs[ip]
-		if ok {
-			client.UpdateArp(a)
-		} else {
+		hwClient, hwOK := c.hw[a.HWAddress]
+		switch {
+		case hwOK && hwClient != client && (isIPv6(ip) || isIPv6(hwClient.IP)):
+			// attribute the address to the client with the same hardware address
+			ac := &addrCounter{}
+			if ok {
+				ac = c.removeAddr(client, ip)
+			}
+			hwClient.addAddr(ip, ac)
+			c.cs[ip] = hwClient
+			client = hwClient
+		case ok && isIPv6(ip) && client.HWAddr != "" && client.HWAddr != a.HWAddress:
+			// the address has moved to another device
+			ac := c.removeAddr(client, ip)
+			client = NewClient(ip, c.avgSamples)
+			client.addrs[ip] = ac
+			c.cs[ip] = client
+			joined = append(joined, client)
+		case !ok:
 			client = NewClient(ip, c.avgSamples)
-			client.UpdateArp(a)
 			c.cs[ip] = client
+			joined = append(joined, client)
+		}
+		if client.HWAddr != a.HWAddress && c.hw[client.HWAddr] == client {
+			delete(c.hw, client.HWAddr)
 		}
+		client.UpdateArp(a)
+		if _, ok := c.hw[a.HWAddress]; !ok && a.HWAddress != "" {
+			c.hw[a.HWAddress] = client
+		}
+	}
+	for _, client := range joined {
+		c.publish(events.Join, client)
 	}
 	return nil
 }
 
+// removeAddr removes the address ip from client and returns its counter.
+func (c *Clients) removeAddr(client *Client, ip string) *addrCounter {
+	ac := client.removeAddr(ip)
+	if ac == nil {
+		ac = &addrCounter{}
+	}
+	if len(client.addrs) == 0 && c.hw[client.HWAddr] == client {
+		delete(c.hw, client.HWAddr)
+	}
+	return ac
+}
+
 func (c *Clients) UpdateNames(names map[string]string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -164,7 +330,9 @@ func (c *Clients) UpdateNames(names map[string]string) error {
 	for k, v := range names {
 		client, ok := c.cs[k]
 		if ok {
-			client.UpdateName(v)
+			if client.IP == k {
+				client.UpdateName(v)
+			}
 		} else {
 			log.Warn().Msgf("no client registerd for %v %v", k, v)
 		}
@@ -172,27 +340,192 @@ func (c *Clients) UpdateNames(names map[string]string) error {
 	return nil
 }
 
+// Idle returns the addresses of the clients that have not had any traffic or
+// been seen as a neighbor within maxIdle.
+func (c *Clients) Idle(maxIdle time.Duration) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var ips []string
+	for ip, client := range c.cs {
+		if time.Since(client.LastSeen) > maxIdle {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// Expire removes the clients that have any of the addresses in ips.
+func (c *Clients) Expire(ips []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, ip := range ips {
+		client, ok := c.cs[ip]
+		if !ok {
+			continue
+		}
+		for addr := range client.addrs {
+			if c.cs[addr] == client {
+				delete(c.cs, addr)
+			}
+		}
+		delete(c.cs, ip)
+		if c.hw[client.HWAddr] == client {
+			delete(c.hw, client.HWAddr)
+		}
+		log.Info().
+			Str("event", "expired").
+			Str("ip", client.IP).
+			Strs("ip6", client.IP6).
+			Str("hwaddr", client.HWAddr).
+			Str("name", client.Name).
+			Time("last_seen", client.LastSeen).
+			Msg("client expired")
+		c.publish(events.Leave, client)
+	}
+}
+
+// ResetPeriod starts a new period of counted traffic for all clients.
+func (c *Clients) ResetPeriod() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for _, client := range c.cs {
+		client.resetPeriod(now)
+	}
+}
+
+// Alias returns the configured alias of the hardware address.
+func (c *Clients) Alias(hwaddr string) (string, bool) {
+	alias, ok := c.hostAliases[hwaddr]
+	return alias, ok
+}
+
+// Group returns the configured group of the hardware address.
+func (c *Clients) Group(hwaddr string) string {
+	return c.hostGroups[hwaddr]
+}
+
 func (c *Clients) Stats() clientstats.Stats {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	ss := make([]clientstats.Stat, 0, len(c.cs))
+	seen := make(map[*Client]bool, len(c.cs))
 	for _, client := range c.cs {
-		stat := client.Stat()
-		if alias, ok := c.hostAliases[stat.HWAddr]; ok {
-			stat.Name = alias
+		if seen[client] {
+			continue
 		}
-		ss = append(ss, stat)
+		seen[client] = true
+		ss = append(ss, c.stat(client))
 	}
 	return ss
 }
 
+// stat returns the stats of client with the configured alias and group.
+func (c *Clients) stat(client *Client) clientstats.Stat {
+	stat := client.Stat()
+	if alias, ok := c.hostAliases[stat.HWAddr]; ok {
+		stat.Name = alias
+	}
+	stat.Group = c.hostGroups[stat.HWAddr]
+	return stat
+}
+
+// publish publishes an event about client, c.mu must be held.
+func (c *Clients) publish(typ events.Type, client *Client) {
+	c.events.Publish(events.Event{
+		Type:   typ,
+		Time:   time.Now(),
+		Client: c.stat(client),
+	})
+}
+
+// counter is the total traffic and the rate averages for one direction and
+// address family.
 type counter struct {
 	updatedAt time.Time
-	bytes     uint64
+	total     amount
 	avg       movavg.MA
+	packetAvg movavg.MA
+}
+
+func newCounter(avgSamples int) *counter {
+	return &counter{
+		avg:       movavg.NewSMA(avgSamples),
+		packetAvg: movavg.NewSMA(avgSamples),
+	}
+}
+
+// add adds n recorded at timestamp to the total and the averages.
+func (c *counter) add(n amount, timestamp time.Time) {
+	dur := float64(timestamp.Sub(c.updatedAt))
+	if dur > 0 {
+		perSecond := float64(time.Second) / dur
+		c.avg.Add(float64(n.bytes) * perSecond)
+		c.packetAvg.Add(float64(n.packets) * perSecond)
+		c.total = c.total.add(n)
+		c.updatedAt = timestamp
+	} else {
+		log.Warn().Msgf("no time difference, skipping updating rate counter %v %v", dur, n)
+	}
+}
+
+func (c *counter) rate() float64 {
+	r := c.avg.Avg()
+	if r < 0.0001 {
+		r = 0
+	}
+	return r
+}
+
+func (c *counter) packetRate() float64 {
+	r := c.packetAvg.Avg()
+	if r < 0.0001 {
+		r = 0
+	}
+	return r
 }
 
 func (c counter) String() string {
-	return fmt.Sprintf("bytes:%v avg:%.2f updatedAt:%v", c.bytes, c.avg.Avg(), c.updatedAt)
+	return fmt.Sprintf("bytes:%v packets:%v avg:%.2f updatedAt:%v", c.total.bytes, c.total.packets, c.avg.Avg(), c.updatedAt)
+}
+
+// amount is a number of bytes and packets.
+type amount struct {
+	bytes   uint64
+	packets uint64
+}
+
+func (a amount) add(b amount) amount {
+	return amount{bytes: a.bytes + b.bytes, packets: a.packets + b.packets}
+}
+
+func (a amount) sub(b amount) amount {
+	return amount{bytes: a.bytes - b.bytes, packets: a.packets - b.packets}
+}
+
+// addrCounter holds the last seen counters for a single address.
+type addrCounter struct {
+	in  amount
+	out amount
+}
+
+// delta is the traffic counted since the last update.
+type delta struct {
+	in4, out4, in6, out6 amount
+}
+
+// periodStart is the start of a resettable period of counted traffic.
+type periodStart struct {
+	time time.Time
+	in   amount // total at the start of the period
+	out  amount
+}
+
+func isIPv6(ip string) bool {
+	addr := net.ParseIP(ip)
+	return addr != nil && addr.To4() == nil
 }
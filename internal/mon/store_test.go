@@ -0,0 +1,184 @@
+package mon
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/some-programs/natbwmon/internal/arp"
+	"github.com/some-programs/natbwmon/internal/clientstats"
+	"github.com/some-programs/natbwmon/internal/events"
+)
+
+// statByHWAddr returns the stat of the client with the hardware address.
+func statByHWAddr(stats clientstats.Stats, hwaddr string) (clientstats.Stat, bool) {
+	i := slices.IndexFunc(stats, func(s clientstats.Stat) bool { return s.HWAddr == hwaddr })
+	if i < 0 {
+		return clientstats.Stat{}, false
+	}
+	return stats[i], true
+}
+
+func TestClientsIPv6ByHWAddr(t *testing.T) {
+	is := is.New(t)
+	const (
+		tv    = "00:00:00:00:00:01"
+		nas   = "00:00:00:00:00:02"
+		phone = "00:00:00:00:00:03"
+	)
+	c := NewClients(1, nil, nil, nil)
+
+	// IPv6 addresses are attributed to the client with the same hardware address
+	is.NoErr(c.UpdateArp(arp.Entries{
+		{IPAddress: "192.168.0.10", HWAddress: tv, Device: "br0"},
+		{IPAddress: "2001:db8::10", HWAddress: tv, Device: "br0"},
+		{IPAddress: "fe80::10", HWAddress: tv, Device: "br0"},
+	}))
+	// an IPv6 address seen first is the primary address until an IPv4 address is known
+	is.NoErr(c.UpdateArp(arp.Entries{{IPAddress: "2001:db8::20", HWAddress: nas, Device: "br0"}}))
+	s, _ := statByHWAddr(c.Stats(), nas)
+	is.Equal(s.IP, "2001:db8::20")
+	is.NoErr(c.UpdateArp(arp.Entries{{IPAddress: "192.168.0.20", HWAddress: nas, Device: "br0"}}))
+
+	stats := c.Stats()
+	is.Equal(len(stats), 2)
+	s, _ = statByHWAddr(stats, tv)
+	is.Equal(s.IP, "192.168.0.10")
+	is.Equal(s.IP6, []string{"2001:db8::10", "fe80::10"})
+	s, _ = statByHWAddr(stats, nas)
+	is.Equal(s.IP, "192.168.0.20")
+	is.Equal(s.IP6, []string{"2001:db8::20"})
+	is.Equal(c.hw[tv], c.cs["2001:db8::10"])
+	is.Equal(c.hw[nas], c.cs["192.168.0.20"])
+
+	// the traffic of all addresses is counted for the client
+	t0 := time.Now()
+	is.NoErr(c.UpdateIPTables(IPTStats{CreatedAt: t0, Stats: []Counter{
+		{IP: "192.168.0.10", Bytes: 1000, Packets: 1},
+		{IP: "2001:db8::10", Bytes: 500, Packets: 1},
+		{IP: "2001:db8::10", Out: true, Bytes: 100, Packets: 1},
+	}}))
+	s, _ = statByHWAddr(c.Stats(), tv)
+	is.Equal(s.InBytes, uint64(1500))
+	is.Equal(s.OutBytes, uint64(100))
+
+	// an IPv6 address that moves to another device takes its counters along
+	is.NoErr(c.UpdateArp(arp.Entries{{IPAddress: "2001:db8::10", HWAddress: phone, Device: "br0"}}))
+	stats = c.Stats()
+	is.Equal(len(stats), 3)
+	s, _ = statByHWAddr(stats, tv)
+	is.Equal(s.IP6, []string{"fe80::10"})
+	s, _ = statByHWAddr(stats, phone)
+	is.Equal(s.IP, "2001:db8::10")
+	is.Equal(c.hw[phone], c.cs["2001:db8::10"])
+
+	is.NoErr(c.UpdateIPTables(IPTStats{CreatedAt: t0.Add(time.Second), Stats: []Counter{
+		{IP: "192.168.0.10", Bytes: 1000, Packets: 1},
+		{IP: "2001:db8::10", Bytes: 800, Packets: 2},
+		{IP: "2001:db8::10", Out: true, Bytes: 100, Packets: 1},
+	}}))
+	s, _ = statByHWAddr(c.Stats(), phone)
+	is.Equal(s.InBytes, uint64(300)) // only the traffic since the move
+	s, _ = statByHWAddr(c.Stats(), tv)
+	is.Equal(s.InBytes, uint64(1500))
+}
+
+func TestClientAddrs(t *testing.T) {
+	is := is.New(t)
+	c := &Client{addrs: make(map[string]*addrCounter)}
+	c.addAddr("2001:db8::1", &addrCounter{})
+	is.Equal(c.IP, "2001:db8::1")
+	c.addAddr("2001:db8::2", &addrCounter{})
+	c.addAddr("2001:db8::2", &addrCounter{})
+	ac := &addrCounter{in: amount{bytes: 10}}
+	c.addAddr("192.168.0.1", ac)
+	is.Equal(c.IP, "192.168.0.1") // IPv4 is preferred
+	is.Equal(c.IP6, []string{"2001:db8::1", "2001:db8::2"})
+
+	is.Equal(c.removeAddr("192.168.0.1"), ac)
+	is.True(c.IP == "2001:db8::1" || c.IP == "2001:db8::2")
+	is.Equal(c.removeAddr("2001:db8::1"), &addrCounter{})
+	is.Equal(c.IP, "2001:db8::2")
+	is.Equal(c.IP6, []string{"2001:db8::2"})
+	c.removeAddr("2001:db8::2")
+	is.Equal(c.IP, "")
+	is.Equal(len(c.IP6), 0)
+}
+
+func TestClientsExpire(t *testing.T) {
+	is := is.New(t)
+	bus := events.NewBus()
+	evs, cancel := bus.Subscribe(10)
+	defer cancel()
+	c := NewClients(1, nil, nil, bus)
+	is.NoErr(c.UpdateArp(arp.Entries{
+		{IPAddress: "192.168.0.10", HWAddress: "00:00:00:00:00:01", Device: "br0"},
+		{IPAddress: "2001:db8::10", HWAddress: "00:00:00:00:00:01", Device: "br0"},
+		{IPAddress: "192.168.0.20", HWAddress: "00:00:00:00:00:02", Device: "br0"},
+	}))
+	is.Equal((<-evs).Type, events.Join)
+	is.Equal((<-evs).Type, events.Join)
+	is.Equal(len(c.Idle(time.Hour)), 0)
+
+	tv := c.cs["192.168.0.10"]
+	tv.LastSeen = time.Now().Add(-2 * time.Hour)
+	ips := c.Idle(time.Hour)
+	slices.Sort(ips)
+	is.Equal(ips, []string{"192.168.0.10", "2001:db8::10"}) // every address of the client
+
+	c.Expire(ips)
+	e := <-evs
+	is.Equal(e.Type, events.Leave)
+	is.Equal(e.Client.HWAddr, "00:00:00:00:00:01")
+	is.Equal(len(evs), 0) // one event per client
+	stats := c.Stats()
+	is.Equal(len(stats), 1)
+	is.Equal(stats[0].IP, "192.168.0.20")
+	_, ok := c.hw["00:00:00:00:00:01"]
+	is.True(!ok)
+	_, ok = c.cs["2001:db8::10"]
+	is.True(!ok)
+
+	// an expired client that comes back starts over
+	is.NoErr(c.UpdateArp(arp.Entries{{IPAddress: "192.168.0.10", HWAddress: "00:00:00:00:00:01", Device: "br0"}}))
+	is.Equal((<-evs).Type, events.Join)
+	is.True(c.cs["192.168.0.10"] != tv)
+}
+
+func TestClientsResetPeriod(t *testing.T) {
+	is := is.New(t)
+	c := NewClients(1, nil, nil, nil)
+	is.NoErr(c.UpdateArp(arp.Entries{{IPAddress: "192.168.0.10", HWAddress: "00:00:00:00:00:01", Device: "br0"}}))
+	t0 := time.Now()
+	update := func(dt time.Duration, in, out uint64) clientstats.Stat {
+		is.NoErr(c.UpdateIPTables(IPTStats{CreatedAt: t0.Add(dt), Stats: []Counter{
+			{IP: "192.168.0.10", Bytes: in, Packets: in / 100},
+			{IP: "192.168.0.10", Out: true, Bytes: out, Packets: out / 100},
+		}}))
+		return c.Stats()[0]
+	}
+
+	update(0, 0, 0)
+	s := update(time.Second, 1000, 200)
+	is.Equal(s.InBytes, uint64(1000))
+	is.Equal(s.PeriodInBytes, uint64(1000))
+	is.Equal(s.PeriodOutBytes, uint64(200))
+	is.Equal(s.InPackets, uint64(10))
+	is.Equal(s.InRate, float64(1000))
+	is.Equal(s.InPacketRate, float64(10))
+
+	c.ResetPeriod()
+	s = c.Stats()[0]
+	is.Equal(s.InBytes, uint64(1000)) // the totals keep accumulating
+	is.Equal(s.PeriodInBytes, uint64(0))
+	is.Equal(s.PeriodInPackets, uint64(0))
+	is.True(!s.PeriodStart.Before(t0))
+
+	s = update(2*time.Second, 1500, 300)
+	is.Equal(s.InBytes, uint64(1500))
+	is.Equal(s.OutBytes, uint64(300))
+	is.Equal(s.PeriodInBytes, uint64(500))
+	is.Equal(s.PeriodOutBytes, uint64(100))
+	is.Equal(s.PeriodInPackets, uint64(5))
+}
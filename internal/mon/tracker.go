@@ -0,0 +1,246 @@
+package mon
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	ct "github.com/florianl/go-conntrack"
+	"github.com/some-programs/natbwmon/internal/log"
+)
+
+// FlowTracker keeps an in memory table of the interesting connections which
+// is kept up to date by conntrack NEW/UPDATE/DESTROY events.
+//
+// Conntrack does not send events for counter changes so the table is also
+// periodically replaced by a full dump to refresh the byte counters and
+// timeouts and to recover from lost events. Events received while the dump
+// runs are applied again on top of the dumped table.
+type FlowTracker struct {
+	resync time.Duration
+	dump   func() (FlowSlice, error)
+
+	mu        sync.RWMutex
+	flows     map[flowKey]Flow
+	syncedAt  time.Time
+	syncing   bool
+	pending   []flowEvent // events received during the running dump
+	keepEnded bool        // Ended has been called
+	ended     FlowSlice   // destroyed flows not yet returned by Ended
+	onNew     func(Flow)
+}
+
+// flowEvent is a conntrack event about a flow.
+type flowEvent struct {
+	flow    Flow
+	destroy bool
+}
+
+func NewFlowTracker(resync time.Duration) *FlowTracker {
+	return &FlowTracker{
+		resync: resync,
+		dump:   Flows,
+		flows:  make(map[flowKey]Flow),
+	}
+}
+
+// Run subscribes to conntrack events and keeps the table updated until ctx is
+// done.
+func (t *FlowTracker) Run(ctx context.Context) {
+	for {
+		err := t.run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Warn().Err(err).Msg("conntrack event subscription failed, resubscribing")
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (t *FlowTracker) run(ctx context.Context) error {
+	nfct, err := ct.Open(&ct.Config{AddConntrackInformation: true})
+	if err != nil {
+		return fmt.Errorf("could not create nfct: %w", err)
+	}
+	errCh := nfct.AttachErrChan()
+
+	// The event goroutine is stopped by closing the socket rather than by
+	// canceling its context so that it always ends by reporting an error
+	// which must be received before errCh can be closed by nfct.Close.
+	regCtx, regCancel := context.WithCancel(context.Background())
+	defer regCancel()
+	groups := ct.NetlinkCtNew | ct.NetlinkCtUpdate | ct.NetlinkCtDestroy
+	if err := nfct.Register(regCtx, ct.Conntrack, groups, t.handleEvent); err != nil {
+		nfct.Close()
+		return fmt.Errorf("could not register for conntrack events: %w", err)
+	}
+
+	err = t.follow(ctx, errCh)
+	if err == nil {
+		nfct.Con.Close()
+		<-errCh
+	}
+	nfct.Close()
+	return err
+}
+
+// follow resyncs the table periodically until ctx is done or the event
+// subscription fails.
+func (t *FlowTracker) follow(ctx context.Context, errCh <-chan error) error {
+	if err := t.sync(); err != nil {
+		log.Warn().Err(err).Msg("conntrack sync failed")
+	}
+	var tick <-chan time.Time
+	if t.resync > 0 {
+		ticker := time.NewTicker(t.resync)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+			if err := t.sync(); err != nil {
+				log.Warn().Err(err).Msg("conntrack sync failed")
+			}
+		case err := <-errCh:
+			return fmt.Errorf("conntrack events: %w", err)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// sync replaces the table with a full dump and the events received while the
+// dump ran.
+func (t *FlowTracker) sync() error {
+	t.mu.Lock()
+	t.syncing = true
+	t.mu.Unlock()
+
+	fs, err := t.dump()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pending := t.pending
+	t.syncing, t.pending = false, nil
+	if err != nil {
+		return err
+	}
+	flows := make(map[flowKey]Flow, len(fs))
+	for _, f := range fs {
+		flows[newFlowKey(f)] = f
+	}
+	for _, e := range pending {
+		applyEvent(flows, e)
+	}
+	t.flows = flows
+	t.syncedAt = time.Now()
+	return nil
+}
+
+// OnNew sets fn to be called with every new flow that is added to the table.
+// fn is called from the event goroutine and must not block.
+func (t *FlowTracker) OnNew(fn func(Flow)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onNew = fn
+}
+
+func (t *FlowTracker) handleEvent(c ct.Con) int {
+	e := flowEvent{
+		flow:    newFlow(c),
+		destroy: c.Info != nil && c.Info.NetlinkGroup == ct.NetlinkCtDestroy,
+	}
+	isNew := c.Info != nil && c.Info.NetlinkGroup == ct.NetlinkCtNew
+	if onNew := t.apply(e); onNew != nil && isNew && e.flow.isInteresting() {
+		onNew(e.flow)
+	}
+	return 0
+}
+
+// apply applies the event to the table and returns the OnNew function.
+func (t *FlowTracker) apply(e flowEvent) func(Flow) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e.destroy && t.keepEnded {
+		f := e.flow
+		if prev, ok := t.flows[newFlowKey(f)]; ok {
+			f = maxCounters(f, prev)
+		}
+		if f.isInteresting() {
+			t.ended = append(t.ended, f)
+		}
+	}
+	applyEvent(t.flows, e)
+	if t.syncing {
+		t.pending = append(t.pending, e)
+	}
+	return t.onNew
+}
+
+// applyEvent updates the flows with the event.
+func applyEvent(flows map[flowKey]Flow, e flowEvent) {
+	f := e.flow
+	k := newFlowKey(f)
+	if e.destroy {
+		delete(flows, k)
+		return
+	}
+	if !f.isInteresting() {
+		return
+	}
+	if prev, ok := flows[k]; ok {
+		// update events only carry counters if they were changed and the
+		// counters only grow, the table may already have newer counters than
+		// an event that is applied again after a dump.
+		f = maxCounters(f, prev)
+	}
+	flows[k] = f
+}
+
+// maxCounters returns f with the larger of the counters of f and prev.
+func maxCounters(f, prev Flow) Flow {
+	f.Orig.Bytes = max(f.Orig.Bytes, prev.Orig.Bytes)
+	f.Orig.Packets = max(f.Orig.Packets, prev.Orig.Packets)
+	f.Reply.Bytes = max(f.Reply.Bytes, prev.Reply.Bytes)
+	f.Reply.Packets = max(f.Reply.Packets, prev.Reply.Packets)
+	return f
+}
+
+// Ended returns the flows that have been destroyed since the previous call
+// with their final counters. Destroyed flows are only kept once Ended has
+// been called so it must then be called regularly.
+func (t *FlowTracker) Ended() FlowSlice {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.keepEnded = true
+	fs := t.ended
+	t.ended = nil
+	return fs
+}
+
+// Flows returns a copy of the current flow table.
+func (t *FlowTracker) Flows() (FlowSlice, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.syncedAt.IsZero() {
+		return nil, fmt.Errorf("conntrack table has not been read yet")
+	}
+	fs := make(FlowSlice, 0, len(t.flows))
+	for _, f := range t.flows {
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+// Len returns the number of flows in the table.
+func (t *FlowTracker) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.flows)
+}
@@ -0,0 +1,136 @@
+package mon
+
+import (
+	"net"
+	"testing"
+
+	ct "github.com/florianl/go-conntrack"
+	"github.com/matryer/is"
+)
+
+// testCon returns a TCP connection from 192.168.0.2 to dst as reported by a
+// conntrack event of the group, counters are only set if bytes is not 0.
+func testCon(group ct.NetlinkGroup, id uint32, dst string, bytes uint64) ct.Con {
+	src, dstIP := net.ParseIP("192.168.0.2"), net.ParseIP(dst)
+	wan := net.ParseIP("10.0.0.1")
+	proto := uint8(6)
+	sport, dport := uint16(40000), uint16(443)
+	c := ct.Con{
+		Info: &ct.InfoSource{NetlinkGroup: group},
+		ID:   &id,
+		Origin: &ct.IPTuple{
+			Src:   &src,
+			Dst:   &dstIP,
+			Proto: &ct.ProtoTuple{Number: &proto, SrcPort: &sport, DstPort: &dport},
+		},
+		Reply: &ct.IPTuple{
+			Src:   &dstIP,
+			Dst:   &wan,
+			Proto: &ct.ProtoTuple{Number: &proto, SrcPort: &dport, DstPort: &sport},
+		},
+	}
+	if bytes != 0 {
+		packets := uint64(1)
+		c.CounterOrigin = &ct.Counter{Bytes: &bytes, Packets: &packets}
+		c.CounterReply = &ct.Counter{Bytes: &bytes, Packets: &packets}
+	}
+	return c
+}
+
+func TestFlowTrackerEvents(t *testing.T) {
+	is := is.New(t)
+	tr := NewFlowTracker(0)
+	tr.dump = func() (FlowSlice, error) { return nil, nil }
+	is.NoErr(tr.sync())
+
+	tr.handleEvent(testCon(ct.NetlinkCtNew, 1, "1.1.1.1", 0))
+	tr.handleEvent(testCon(ct.NetlinkCtUpdate, 1, "1.1.1.1", 100))
+	fs, err := tr.Flows()
+	is.NoErr(err)
+	is.Equal(len(fs), 1)
+	is.Equal(fs[0].Orig.Bytes, uint64(100))
+
+	// update events without counters keep the counters
+	tr.handleEvent(testCon(ct.NetlinkCtUpdate, 1, "1.1.1.1", 0))
+	fs, _ = tr.Flows()
+	is.Equal(fs[0].Orig.Bytes, uint64(100))
+
+	tr.handleEvent(testCon(ct.NetlinkCtDestroy, 1, "1.1.1.1", 0))
+	is.Equal(tr.Len(), 0)
+}
+
+func TestFlowTrackerSync(t *testing.T) {
+	is := is.New(t)
+	tr := NewFlowTracker(0)
+	tr.handleEvent(testCon(ct.NetlinkCtNew, 1, "1.1.1.1", 0))
+	tr.handleEvent(testCon(ct.NetlinkCtNew, 2, "8.8.8.8", 0))
+	_, err := tr.Flows()
+	is.True(err != nil) // not synced yet
+
+	tr.dump = func() (FlowSlice, error) {
+		dumped := FlowSlice{
+			newFlow(testCon(ct.NetlinkCtNew, 1, "1.1.1.1", 500)),
+			newFlow(testCon(ct.NetlinkCtNew, 2, "8.8.8.8", 10)),
+		}
+		// events received while the dump runs
+		tr.handleEvent(testCon(ct.NetlinkCtUpdate, 1, "1.1.1.1", 400))
+		tr.handleEvent(testCon(ct.NetlinkCtDestroy, 2, "8.8.8.8", 0))
+		tr.handleEvent(testCon(ct.NetlinkCtNew, 3, "9.9.9.9", 0))
+		return dumped, nil
+	}
+	is.NoErr(tr.sync())
+	fs, err := tr.Flows()
+	is.NoErr(err)
+	is.Equal(len(fs), 2)
+	byID := make(map[uint32]Flow)
+	for _, f := range fs {
+		byID[f.ID] = f
+	}
+	is.Equal(byID[1].Orig.Bytes, uint64(500)) // the newer dumped counters
+	_, ok := byID[2]
+	is.True(!ok) // destroyed during the dump
+	_, ok = byID[3]
+	is.True(ok) // created during the dump
+	is.Equal(len(tr.pending), 0)
+}
+
+func TestFlowTrackerEnded(t *testing.T) {
+	is := is.New(t)
+	tr := NewFlowTracker(0)
+	tr.dump = func() (FlowSlice, error) { return nil, nil }
+	is.NoErr(tr.sync())
+
+	// nothing is kept before Ended is called
+	tr.handleEvent(testCon(ct.NetlinkCtNew, 1, "1.1.1.1", 0))
+	tr.handleEvent(testCon(ct.NetlinkCtDestroy, 1, "1.1.1.1", 100))
+	is.Equal(len(tr.Ended()), 0)
+
+	tr.handleEvent(testCon(ct.NetlinkCtNew, 2, "1.1.1.1", 0))
+	tr.handleEvent(testCon(ct.NetlinkCtDestroy, 2, "1.1.1.1", 100))
+	// without counters in the event the last known counters are final
+	tr.handleEvent(testCon(ct.NetlinkCtUpdate, 3, "8.8.8.8", 50))
+	tr.handleEvent(testCon(ct.NetlinkCtDestroy, 3, "8.8.8.8", 0))
+	fs := tr.Ended()
+	is.Equal(len(fs), 2)
+	is.Equal(fs[0].ID, uint32(2))
+	is.Equal(fs[0].Orig.Bytes, uint64(100))
+	is.Equal(fs[1].ID, uint32(3))
+	is.Equal(fs[1].Reply.Bytes, uint64(50))
+	is.Equal(tr.Len(), 0)
+	is.Equal(len(tr.Ended()), 0)
+}
+
+func TestFlowTrackerOnNew(t *testing.T) {
+	is := is.New(t)
+	tr := NewFlowTracker(0)
+	var added []Flow
+	tr.OnNew(func(f Flow) {
+		is.Equal(tr.Len(), 1) // already in the table
+		added = append(added, f)
+	})
+	tr.handleEvent(testCon(ct.NetlinkCtNew, 1, "1.1.1.1", 0))
+	tr.handleEvent(testCon(ct.NetlinkCtUpdate, 1, "1.1.1.1", 100))
+	tr.handleEvent(testCon(ct.NetlinkCtDestroy, 1, "1.1.1.1", 0))
+	is.Equal(len(added), 1)
+	is.Equal(added[0].ID, uint32(1))
+}
@@ -0,0 +1,211 @@
+// Package neigh reads the kernel neighbor table (ARP and NDP) over rtnetlink.
+package neigh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/mdlayher/netlink"
+	"github.com/some-programs/natbwmon/internal/arp"
+	"golang.org/x/sys/unix"
+)
+
+// State is the neighbor unreachability detection state of an entry.
+type State uint16
+
+const (
+	Incomplete State = unix.NUD_INCOMPLETE
+	Reachable  State = unix.NUD_REACHABLE
+	Stale      State = unix.NUD_STALE
+	Delay      State = unix.NUD_DELAY
+	Probe      State = unix.NUD_PROBE
+	Failed     State = unix.NUD_FAILED
+	NoARP      State = unix.NUD_NOARP
+	Permanent  State = unix.NUD_PERMANENT
+)
+
+var stateNames = []struct {
+	state State
+	name  string
+}{
+	{Incomplete, "INCOMPLETE"},
+	{Reachable, "REACHABLE"},
+	{Stale, "STALE"},
+	{Delay, "DELAY"},
+	{Probe, "PROBE"},
+	{Failed, "FAILED"},
+	{NoARP, "NOARP"},
+	{Permanent, "PERMANENT"},
+}
+
+func (s State) String() string {
+	var names []string
+	for _, v := range stateNames {
+		if s&v.state != 0 {
+			names = append(names, v.name)
+		}
+	}
+	if len(names) == 0 {
+		return "NONE"
+	}
+	return strings.Join(names, ",")
+}
+
+// Entry is a neighbor table entry.
+type Entry struct {
+	IPAddress string
+	HWAddress string
+	Device    string
+	State     State
+	Router    bool
+
+	// Confirmed is the time since the entry was last confirmed to be reachable.
+	Confirmed time.Duration
+	// Used is the time since the entry was last used.
+	Used time.Duration
+	// Updated is the time since the entry was last updated.
+	Updated time.Duration
+}
+
+// Usable returns true if the entry has a link layer address that can be
+// used to identify the neighbor.
+func (e Entry) Usable() bool {
+	return e.HWAddress != "" && e.State&(Incomplete|Failed|NoARP) == 0
+}
+
+// Arp returns e as an arp.Entry, the flags field is set to the state name.
+func (e Entry) Arp() arp.Entry {
+	return arp.Entry{
+		IPAddress: e.IPAddress,
+		HWAddress: e.HWAddress,
+		Device:    e.Device,
+		Flags:     e.State.String(),
+	}
+}
+
+type Entries []Entry
+
+// FilterUsable returns the entries that are usable.
+func (es Entries) FilterUsable() Entries {
+	filtered := make(Entries, 0, len(es))
+	for _, v := range es {
+		if v.Usable() {
+			filtered = append(filtered, v)
+		}
+	}
+	return filtered
+}
+
+// Arp returns the entries as arp.Entries.
+func (es Entries) Arp() arp.Entries {
+	as := make(arp.Entries, 0, len(es))
+	for _, v := range es {
+		as = append(as, v.Arp())
+	}
+	return as
+}
+
+// Get returns the IPv4 and IPv6 neighbor table entries.
+func Get() (Entries, error) {
+	conn, err := rtnetlink.Dial(nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not dial rtnetlink: %w", err)
+	}
+	defer conn.Close()
+	msgs, err := conn.Neigh.List()
+	if err != nil {
+		return nil, fmt.Errorf("could not list neighbors: %w", err)
+	}
+	names := make(ifNames)
+	es := make(Entries, 0, len(msgs))
+	for _, m := range msgs {
+		if e, ok := newEntry(&m, names); ok {
+			es = append(es, e)
+		}
+	}
+	return es, nil
+}
+
+// Subscribe calls fn with every neighbor table change until ctx is done.
+// deleted is true if the entry was removed from the table.
+func Subscribe(ctx context.Context, fn func(e Entry, deleted bool)) error {
+	conn, err := rtnetlink.Dial(&netlink.Config{Groups: unix.RTMGRP_NEIGH})
+	if err != nil {
+		return fmt.Errorf("could not dial rtnetlink: %w", err)
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now().Add(-time.Second))
+		case <-done:
+		}
+	}()
+	names := make(ifNames)
+	for {
+		msgs, nlmsgs, err := conn.Receive()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("could not receive neighbor events: %w", err)
+		}
+		for i, m := range msgs {
+			nm, ok := m.(*rtnetlink.NeighMessage)
+			if !ok {
+				continue
+			}
+			if e, ok := newEntry(nm, names); ok {
+				fn(e, nlmsgs[i].Header.Type == unix.RTM_DELNEIGH)
+			}
+		}
+	}
+}
+
+// tick is the unit of the neighbor cache info ages (USER_HZ).
+const tick = time.Second / 100
+
+func newEntry(m *rtnetlink.NeighMessage, names ifNames) (Entry, bool) {
+	if m.Attributes == nil || m.Attributes.Address == nil {
+		return Entry{}, false
+	}
+	if m.Family != unix.AF_INET && m.Family != unix.AF_INET6 {
+		return Entry{}, false
+	}
+	e := Entry{
+		IPAddress: m.Attributes.Address.String(),
+		Device:    names.get(m.Index),
+		State:     State(m.State),
+		Router:    m.Flags&unix.NTF_ROUTER != 0,
+	}
+	if len(m.Attributes.LLAddress) > 0 {
+		e.HWAddress = m.Attributes.LLAddress.String()
+	}
+	if ci := m.Attributes.CacheInfo; ci != nil {
+		e.Confirmed = time.Duration(ci.Confirmed) * tick
+		e.Used = time.Duration(ci.Used) * tick
+		e.Updated = time.Duration(ci.Updated) * tick
+	}
+	return e, true
+}
+
+// ifNames caches interface names by index.
+type ifNames map[uint32]string
+
+func (n ifNames) get(index uint32) string {
+	if name, ok := n[index]; ok {
+		return name
+	}
+	var name string
+	if iface, err := net.InterfaceByIndex(int(index)); err == nil {
+		name = iface.Name
+	}
+	n[index] = name
+	return name
+}
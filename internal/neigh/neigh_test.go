@@ -0,0 +1,69 @@
+package neigh
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jsimonetti/rtnetlink"
+	"github.com/matryer/is"
+	"golang.org/x/sys/unix"
+)
+
+func TestStateString(t *testing.T) {
+	is := is.New(t)
+	is.Equal(Reachable.String(), "REACHABLE")
+	is.Equal((Stale | Probe).String(), "STALE,PROBE")
+	is.Equal(State(0).String(), "NONE")
+}
+
+func TestNewEntry(t *testing.T) {
+	is := is.New(t)
+	names := ifNames{2: "br0"}
+	hwa, err := net.ParseMAC("00:11:22:33:44:55")
+	is.NoErr(err)
+
+	e, ok := newEntry(&rtnetlink.NeighMessage{
+		Family: unix.AF_INET6,
+		Index:  2,
+		State:  unix.NUD_REACHABLE,
+		Flags:  unix.NTF_ROUTER,
+		Attributes: &rtnetlink.NeighAttributes{
+			Address:   net.ParseIP("2001:db8::1"),
+			LLAddress: hwa,
+			CacheInfo: &rtnetlink.NeighCacheInfo{Confirmed: 150, Used: 20, Updated: 1000},
+		},
+	}, names)
+	is.True(ok)
+	is.Equal(e, Entry{
+		IPAddress: "2001:db8::1",
+		HWAddress: "00:11:22:33:44:55",
+		Device:    "br0",
+		State:     Reachable,
+		Router:    true,
+		Confirmed: 1500 * time.Millisecond,
+		Used:      200 * time.Millisecond,
+		Updated:   10 * time.Second,
+	})
+	is.True(e.Usable())
+	is.Equal(e.Arp().Flags, "REACHABLE")
+
+	// incomplete entries have no link layer address
+	e, ok = newEntry(&rtnetlink.NeighMessage{
+		Family:     unix.AF_INET,
+		Index:      2,
+		State:      unix.NUD_INCOMPLETE,
+		Attributes: &rtnetlink.NeighAttributes{Address: net.ParseIP("192.168.0.2")},
+	}, names)
+	is.True(ok)
+	is.Equal(e.HWAddress, "")
+	is.True(!e.Usable())
+
+	for _, m := range []*rtnetlink.NeighMessage{
+		{Family: unix.AF_INET, Index: 2},
+		{Family: unix.AF_BRIDGE, Index: 2, Attributes: &rtnetlink.NeighAttributes{Address: net.ParseIP("192.168.0.2")}},
+	} {
+		_, ok := newEntry(m, names)
+		is.True(!ok)
+	}
+}
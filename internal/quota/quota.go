@@ -0,0 +1,430 @@
+// Package quota counts the traffic of devices and device groups against daily
+// or monthly data quotas.
+//
+// Devices are identified by their hardware address so that the traffic of a
+// device is counted across address changes. When a quota has an action the
+// clients it applies to are restricted while it is exceeded, the restriction
+// is lifted when the quota period ends or the quotas are reset.
+package quota
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/some-programs/natbwmon/internal/clientstats"
+	"github.com/some-programs/natbwmon/internal/mon"
+)
+
+// Period is how often a quota starts over.
+type Period string
+
+const (
+	Day   Period = "day"
+	Month Period = "month"
+)
+
+// start returns the start of the period that t is in.
+func (p Period) start(t time.Time) time.Time {
+	y, m, d := t.Date()
+	if p == Month {
+		d = 1
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// Quota is a data quota of a device or a group of devices.
+type Quota struct {
+	HWAddr  string // empty for group quotas
+	Group   string // empty for device quotas
+	Limit   uint64 // bytes in both directions per period
+	Period  Period
+	Enforce bool   // restrict the clients while the quota is exceeded
+	Rate    uint64 // when enforced, bytes per second the clients are limited to, 0 drops all traffic
+}
+
+// Name returns the hardware address of a device quota or group:NAME for a
+// group quota.
+func (q Quota) Name() string {
+	if q.Group != "" {
+		return "group:" + q.Group
+	}
+	return q.HWAddr
+}
+
+// Applies returns true if the quota counts the traffic of the client.
+func (q Quota) Applies(s clientstats.Stat) bool {
+	if q.Group != "" {
+		return s.Group == q.Group
+	}
+	return s.HWAddr == q.HWAddr
+}
+
+// Parse parses a quota from SUBJECT=LIMIT/PERIOD[:ACTION] where SUBJECT is a
+// hardware address or group:NAME, PERIOD is day or month and ACTION is drop or
+// a rate such as 256KiB/s. Without an action the quota is only monitored.
+//
+// ex: group:kids=2GiB/day:drop, 00:11:22:33:44:55=20GiB/month:256KiB/s
+func Parse(s string) (Quota, error) {
+	var q Quota
+	subject, rest, ok := strings.Cut(s, "=")
+	if !ok {
+		return q, fmt.Errorf("expected SUBJECT=LIMIT/PERIOD[:ACTION]: %s", s)
+	}
+	if group, ok := strings.CutPrefix(subject, "group:"); ok {
+		if group == "" {
+			return q, fmt.Errorf("empty group name: %s", s)
+		}
+		q.Group = group
+	} else {
+		hwa, err := net.ParseMAC(subject)
+		if err != nil {
+			return q, fmt.Errorf("invalid hardware address: %s", subject)
+		}
+		q.HWAddr = hwa.String()
+	}
+	amount, action, hasAction := strings.Cut(rest, ":")
+	limit, period, ok := strings.Cut(amount, "/")
+	if !ok {
+		return q, fmt.Errorf("expected LIMIT/PERIOD: %s", amount)
+	}
+	q.Period = Period(period)
+	if q.Period != Day && q.Period != Month {
+		return q, fmt.Errorf("period must be day or month: %s", period)
+	}
+	n, err := clientstats.ParseBytes(limit)
+	if err != nil {
+		return q, err
+	}
+	if n < 1 {
+		return q, fmt.Errorf("limit must be at least 1 byte: %s", limit)
+	}
+	q.Limit = uint64(n)
+	if !hasAction {
+		return q, nil
+	}
+	q.Enforce = true
+	if action == "drop" {
+		return q, nil
+	}
+	rate, ok := strings.CutSuffix(action, "/s")
+	if !ok {
+		return q, fmt.Errorf("action must be drop or a rate ending with /s: %s", action)
+	}
+	if n, err = clientstats.ParseBytes(rate); err != nil {
+		return q, err
+	}
+	if n < 1 {
+		return q, fmt.Errorf("rate must be at least 1 byte per second: %s", action)
+	}
+	q.Rate = uint64(n)
+	return q, nil
+}
+
+// Status is the consumption of a quota in the current period.
+type Status struct {
+	Name     string    `json:"name"`
+	HWAddr   string    `json:"hwaddr,omitempty"`
+	Group    string    `json:"group,omitempty"`
+	Period   Period    `json:"period"`
+	Start    time.Time `json:"start"`
+	Limit    uint64    `json:"limit"`
+	Used     uint64    `json:"used"`
+	Exceeded bool      `json:"exceeded"`
+	Enforce  bool      `json:"enforce"` // the clients are restricted when exceeded
+	Rate     uint64    `json:"rate"`    // the enforced rate, 0 drops all traffic
+}
+
+// usage is the traffic counted for a quota since Start.
+type usage struct {
+	Start time.Time `json:"start"`
+	Bytes uint64    `json:"bytes"`
+}
+
+// totals are the traffic counters of a client when it was last counted.
+type totals struct {
+	In  uint64 `json:"in"`
+	Out uint64 `json:"out"`
+}
+
+// state is what is saved to the state file.
+type state struct {
+	Used map[string]*usage `json:"used"` // by quota name
+	Last map[string]totals `json:"last"` // by client IP address
+}
+
+// Manager counts traffic against quotas and enforces them.
+type Manager struct {
+	quotas   []Quota
+	path     string
+	enforcer mon.Enforcer // nil if no quota is enforced
+
+	mu       sync.Mutex
+	state    state
+	enforced []mon.Limit // sorted by IP
+	dirty    bool        // changed since the last save
+	prev     time.Time   // time of the previous update
+}
+
+// NewManager returns a manager for quotas that keeps its counts in the file at
+// path, a missing file starts from zero. If path is empty the counts are only
+// kept in memory. Exceeded quotas with an action are enforced by enforcer,
+// which may be nil if no quota has an action.
+func NewManager(quotas []Quota, path string, enforcer mon.Enforcer) (*Manager, error) {
+	m := &Manager{
+		quotas:   quotas,
+		path:     path,
+		enforcer: enforcer,
+		state: state{
+			Used: make(map[string]*usage),
+			Last: make(map[string]totals),
+		},
+	}
+	if path == "" {
+		return m, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &m.state); err != nil {
+		return nil, err
+	}
+	if m.state.Used == nil {
+		m.state.Used = make(map[string]*usage)
+	}
+	if m.state.Last == nil {
+		m.state.Last = make(map[string]totals)
+	}
+	return m, nil
+}
+
+// Update counts the traffic of the clients in stats since the previous update
+// against the quotas and enforces the quotas that are exceeded at t. Clients
+// without a hardware address are not counted.
+//
+// The counters of each client address are compared separately because a
+// device with several IPv4 addresses is several clients with the same
+// hardware address. An address that has not been counted before is only
+// counted from zero if the client was first seen after the previous update,
+// otherwise the client has changed its primary address and only its current
+// totals are kept to count from. The counters of addresses that are gone are
+// forgotten when a quota period starts.
+func (m *Manager) Update(stats clientstats.Stats, t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prev := m.prev
+	m.prev = t
+	var started bool
+	for _, q := range m.quotas {
+		start := q.Period.start(t)
+		if u := m.state.Used[q.Name()]; u == nil || !u.Start.Equal(start) {
+			m.state.Used[q.Name()] = &usage{Start: start}
+			m.dirty = true
+			started = true
+		}
+	}
+	if started {
+		m.prune(stats)
+	}
+	for _, s := range stats {
+		if s.HWAddr == "" {
+			continue
+		}
+		cur := totals{In: s.InBytes, Out: s.OutBytes}
+		last, ok := m.state.Last[s.IP]
+		if ok && cur == last {
+			continue
+		}
+		m.state.Last[s.IP] = cur
+		m.dirty = true
+		if !ok && !prev.IsZero() && !s.FirstSeen.After(prev) {
+			continue
+		}
+		var n uint64
+		if cur.In >= last.In && cur.Out >= last.Out {
+			n = cur.In - last.In + cur.Out - last.Out
+		} else {
+			// the counters have started over, such as after a restart
+			n = cur.In + cur.Out
+		}
+		for _, q := range m.quotas {
+			if q.Applies(s) {
+				m.state.Used[q.Name()].Bytes += n
+			}
+		}
+	}
+	return m.enforce(m.limits(stats))
+}
+
+// prune forgets the counters of the addresses that are not in stats.
+func (m *Manager) prune(stats clientstats.Stats) {
+	current := make(map[string]bool, len(stats))
+	for _, s := range stats {
+		current[s.IP] = true
+	}
+	for ip := range m.state.Last {
+		if !current[ip] {
+			delete(m.state.Last, ip)
+			m.dirty = true
+		}
+	}
+}
+
+// limits returns the limits of the clients in stats that are restricted by an
+// exceeded quota.
+func (m *Manager) limits(stats clientstats.Stats) []mon.Limit {
+	byIP := make(map[string]uint64)
+	for _, s := range stats {
+		for _, q := range m.quotas {
+			if !q.Enforce || !q.Applies(s) || !m.exceeded(q) {
+				continue
+			}
+			for _, ip := range append([]string{s.IP}, s.IP6...) {
+				if ip == "" {
+					continue
+				}
+				// dropping wins over any rate, otherwise the lowest rate
+				if rate, ok := byIP[ip]; !ok || q.Rate < rate {
+					byIP[ip] = q.Rate
+				}
+			}
+		}
+	}
+	limits := make([]mon.Limit, 0, len(byIP))
+	for ip, rate := range byIP {
+		limits = append(limits, mon.Limit{IP: ip, Rate: rate})
+	}
+	slices.SortFunc(limits, func(a, b mon.Limit) int {
+		return cmp.Compare(a.IP, b.IP)
+	})
+	return limits
+}
+
+// enforce applies limits if they differ from the enforced limits.
+func (m *Manager) enforce(limits []mon.Limit) error {
+	if m.enforcer == nil || slices.Equal(limits, m.enforced) {
+		return nil
+	}
+	if err := m.enforcer.Enforce(limits); err != nil {
+		return err
+	}
+	m.enforced = limits
+	return nil
+}
+
+// exceeded returns true if q has been used up in the current period.
+func (m *Manager) exceeded(q Quota) bool {
+	u := m.state.Used[q.Name()]
+	return u != nil && u.Bytes >= q.Limit
+}
+
+// Reset starts all quotas over from zero and lifts all restrictions.
+func (m *Manager) Reset() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.state.Used {
+		u.Bytes = 0
+	}
+	m.dirty = true
+	return m.enforce(nil)
+}
+
+// Status returns the status of every quota in the order they were configured.
+func (m *Manager) Status() []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := make([]Status, 0, len(m.quotas))
+	for _, q := range m.quotas {
+		res = append(res, m.status(q))
+	}
+	return res
+}
+
+func (m *Manager) status(q Quota) Status {
+	st := Status{
+		Name:     q.Name(),
+		HWAddr:   q.HWAddr,
+		Group:    q.Group,
+		Period:   q.Period,
+		Limit:    q.Limit,
+		Exceeded: m.exceeded(q),
+		Enforce:  q.Enforce,
+		Rate:     q.Rate,
+	}
+	if u := m.state.Used[q.Name()]; u != nil {
+		st.Start = u.Start
+		st.Used = u.Bytes
+	}
+	return st
+}
+
+// Stat returns the quota of the client that is closest to being used up, nil
+// is returned if no quota applies to the client.
+func (m *Manager) Stat(s clientstats.Stat) *clientstats.Quota {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var res *clientstats.Quota
+	var max float64
+	for _, q := range m.quotas {
+		if !q.Applies(s) {
+			continue
+		}
+		st := m.status(q)
+		if r := float64(st.Used) / float64(st.Limit); res == nil || r > max {
+			max = r
+			res = &clientstats.Quota{
+				Name:     st.Name,
+				Period:   string(st.Period),
+				Start:    st.Start,
+				Limit:    st.Limit,
+				Used:     st.Used,
+				Exceeded: st.Exceeded,
+				Enforced: st.Exceeded && st.Enforce && m.enforcer != nil,
+			}
+		}
+	}
+	return res
+}
+
+// Save writes the counts to the state file if they have changed.
+func (m *Manager) Save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.path == "" || !m.dirty {
+		return nil
+	}
+	data, err := json.MarshalIndent(&m.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(m.path), filepath.Base(m.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), m.path); err != nil {
+		return err
+	}
+	m.dirty = false
+	return nil
+}
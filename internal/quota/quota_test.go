@@ -0,0 +1,182 @@
+package quota
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/some-programs/natbwmon/internal/clientstats"
+	"github.com/some-programs/natbwmon/internal/mon"
+)
+
+func TestParse(t *testing.T) {
+	is := is.New(t)
+
+	q, err := Parse("group:kids=2GiB/day:drop")
+	is.NoErr(err)
+	is.Equal(q, Quota{Group: "kids", Limit: 2 << 30, Period: Day, Enforce: true})
+	is.Equal(q.Name(), "group:kids")
+
+	q, err = Parse("00:11:22:33:44:AA=20GB/month:256KiB/s")
+	is.NoErr(err)
+	is.Equal(q, Quota{HWAddr: "00:11:22:33:44:aa", Limit: 20e9, Period: Month, Enforce: true, Rate: 256 << 10})
+
+	q, err = Parse("00:11:22:33:44:55=1GiB/month")
+	is.NoErr(err)
+	is.True(!q.Enforce)
+
+	for _, s := range []string{
+		"00:11:22:33:44:55",
+		"kids=1GiB/day",
+		"group:=1GiB/day",
+		"00:11:22:33:44:55=1GiB",
+		"00:11:22:33:44:55=1GiB/week",
+		"00:11:22:33:44:55=1XB/day",
+		"00:11:22:33:44:55=0/day",
+		"00:11:22:33:44:55=1GiB/day:block",
+		"00:11:22:33:44:55=1GiB/day:1MiB",
+	} {
+		_, err := Parse(s)
+		is.True(err != nil) // invalid quota
+	}
+}
+
+type enforcer struct {
+	calls  int
+	limits []mon.Limit
+}
+
+func (e *enforcer) Enforce(limits []mon.Limit) error {
+	e.calls++
+	e.limits = limits
+	return nil
+}
+
+func TestManager(t *testing.T) {
+	is := is.New(t)
+	var quotas []Quota
+	for _, s := range []string{
+		"group:kids=1000/day:drop",
+		"00:00:00:00:00:01=1500/month:10KiB/s",
+	} {
+		q, err := Parse(s)
+		is.NoErr(err)
+		quotas = append(quotas, q)
+	}
+	path := filepath.Join(t.TempDir(), "quota.json")
+	e := &enforcer{}
+	m, err := NewManager(quotas, path, e)
+	is.NoErr(err)
+
+	t0 := time.Date(2024, 1, 30, 12, 0, 0, 0, time.Local)
+	tablet := clientstats.Stat{IP: "192.168.0.10", IP6: []string{"2001:db8::10"}, HWAddr: "00:00:00:00:00:01", Group: "kids", InBytes: 600}
+	phone := clientstats.Stat{IP: "192.168.0.11", HWAddr: "00:00:00:00:00:02", Group: "kids", InBytes: 300}
+	nas := clientstats.Stat{IP: "192.168.0.12", InBytes: 1 << 20}
+
+	is.NoErr(m.Update(clientstats.Stats{tablet, phone, nas}, t0))
+	st := m.Status()
+	is.Equal(st[0].Used, uint64(900))
+	is.Equal(st[1].Used, uint64(600))
+	is.True(!st[0].Exceeded)
+	is.Equal(e.calls, 0) // nothing to enforce
+
+	tablet.OutBytes = 100
+	is.NoErr(m.Update(clientstats.Stats{tablet, phone}, t0.Add(time.Minute)))
+	is.True(m.Status()[0].Exceeded)
+	is.Equal(e.calls, 1)
+	is.Equal(e.limits, []mon.Limit{
+		{IP: "192.168.0.10", Rate: 0},
+		{IP: "192.168.0.11", Rate: 0},
+		{IP: "2001:db8::10", Rate: 0},
+	})
+	q := m.Stat(tablet)
+	is.Equal(q.Name, "group:kids")
+	is.True(q.Enforced)
+	is.True(m.Stat(nas) == nil)
+
+	// unchanged limits are not enforced again
+	is.NoErr(m.Update(clientstats.Stats{tablet, phone}, t0.Add(2*time.Minute)))
+	is.Equal(e.calls, 1)
+
+	// the counts are kept across restarts, the counters of the clients start over
+	is.NoErr(m.Save())
+	m, err = NewManager(quotas, path, e)
+	is.NoErr(err)
+	tablet.InBytes, tablet.OutBytes = 500, 0
+	is.NoErr(m.Update(clientstats.Stats{tablet}, t0.Add(3*time.Minute)))
+	st = m.Status()
+	is.Equal(st[0].Used, uint64(1500))
+	is.Equal(st[1].Used, uint64(1200))
+
+	// a new day lifts the daily quota but the monthly quota still applies
+	tablet.InBytes = 800
+	is.NoErr(m.Update(clientstats.Stats{tablet, phone}, t0.Add(12*time.Hour)))
+	st = m.Status()
+	is.Equal(st[0].Used, uint64(300))
+	is.Equal(st[1].Used, uint64(1500))
+	is.Equal(e.limits, []mon.Limit{
+		{IP: "192.168.0.10", Rate: 10 << 10},
+		{IP: "2001:db8::10", Rate: 10 << 10},
+	})
+
+	is.NoErr(m.Reset())
+	is.Equal(len(e.limits), 0)
+	is.Equal(m.Status()[1].Used, uint64(0))
+}
+
+func TestManagerSharedHWAddr(t *testing.T) {
+	is := is.New(t)
+	q, err := Parse("00:00:00:00:00:01=1MiB/day:drop")
+	is.NoErr(err)
+	e := &enforcer{}
+	m, err := NewManager([]Quota{q}, "", e)
+	is.NoErr(err)
+
+	// a device with two IPv4 addresses is two clients
+	t0 := time.Date(2024, 1, 30, 12, 0, 0, 0, time.Local)
+	a := clientstats.Stat{IP: "192.168.0.10", HWAddr: "00:00:00:00:00:01", InBytes: 1000}
+	b := clientstats.Stat{IP: "192.168.0.20", HWAddr: "00:00:00:00:00:01", InBytes: 10}
+	for i := range 10 {
+		is.NoErr(m.Update(clientstats.Stats{a, b}, t0.Add(time.Duration(i)*time.Second)))
+	}
+	is.Equal(m.Status()[0].Used, uint64(1010))
+
+	b.OutBytes = 5
+	is.NoErr(m.Update(clientstats.Stats{a, b}, t0.Add(time.Minute)))
+	is.Equal(m.Status()[0].Used, uint64(1015))
+	is.Equal(e.calls, 0)
+}
+
+func TestManagerAddressChange(t *testing.T) {
+	is := is.New(t)
+	q, err := Parse("00:00:00:00:00:01=1MiB/day")
+	is.NoErr(err)
+	m, err := NewManager([]Quota{q}, "", nil)
+	is.NoErr(err)
+
+	t0 := time.Date(2024, 1, 30, 12, 0, 0, 0, time.Local)
+	tv := clientstats.Stat{IP: "2001:db8::10", HWAddr: "00:00:00:00:00:01", InBytes: 1000, FirstSeen: t0}
+	is.NoErr(m.Update(clientstats.Stats{tv}, t0))
+
+	// the IPv4 address becomes the primary address of the client
+	tv.IP = "192.168.0.10"
+	tv.InBytes = 1200
+	is.NoErr(m.Update(clientstats.Stats{tv}, t0.Add(time.Second)))
+	is.Equal(m.Status()[0].Used, uint64(1000)) // the totals are not counted again
+	tv.InBytes = 1300
+	is.NoErr(m.Update(clientstats.Stats{tv}, t0.Add(2*time.Second)))
+	is.Equal(m.Status()[0].Used, uint64(1100))
+
+	// a client that was first seen since is counted from zero
+	laptop := clientstats.Stat{IP: "192.168.0.20", HWAddr: "00:00:00:00:00:01", InBytes: 50, FirstSeen: t0.Add(2500 * time.Millisecond)}
+	is.NoErr(m.Update(clientstats.Stats{tv, laptop}, t0.Add(3*time.Second)))
+	is.Equal(m.Status()[0].Used, uint64(1150))
+	is.Equal(len(m.state.Last), 3)
+
+	// the addresses that are gone are forgotten when the next period starts
+	is.NoErr(m.Update(clientstats.Stats{tv}, t0.Add(24*time.Hour)))
+	is.Equal(len(m.state.Last), 1)
+	_, ok := m.state.Last["192.168.0.10"]
+	is.True(ok)
+}
@@ -0,0 +1,205 @@
+// Package report summarizes the usage history per device for a day, week or
+// month.
+package report
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/some-programs/natbwmon/internal/clientstats"
+	"github.com/some-programs/natbwmon/internal/history"
+)
+
+// Period is the length of time a report covers.
+type Period string
+
+const (
+	Day   Period = "day"
+	Week  Period = "week"
+	Month Period = "month"
+)
+
+// ParsePeriod returns the named period.
+func ParsePeriod(s string) (Period, error) {
+	switch p := Period(s); p {
+	case Day, Week, Month:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown report period: %s", s)
+}
+
+// Range returns the start and end of the period that t is in. Weeks start on
+// monday.
+func (p Period) Range(t time.Time) (from, to time.Time) {
+	y, m, d := t.Date()
+	switch p {
+	case Week:
+		wd := (int(t.Weekday()) + 6) % 7
+		from = time.Date(y, m, d-wd, 0, 0, 0, 0, t.Location())
+		return from, from.AddDate(0, 0, 7)
+	case Month:
+		from = time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+		return from, from.AddDate(0, 1, 0)
+	default:
+		from = time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+		return from, from.AddDate(0, 0, 1)
+	}
+}
+
+// Options configures a report.
+type Options struct {
+	Period Period
+	At     time.Time // any time within the reported period
+	Top    int       // maximum number of devices, 0 means all
+
+	// Alias and Group return the configured alias and group of a hardware
+	// address, they may be nil.
+	Alias func(hwaddr string) (string, bool)
+	Group func(hwaddr string) string
+}
+
+// Report is the usage per device during a period.
+type Report struct {
+	Period   Period
+	From     time.Time
+	To       time.Time
+	Step     time.Duration // resolution of the history the peaks are from
+	InBytes  uint64
+	OutBytes uint64
+	Devices  []Device // ordered by total bytes
+}
+
+// Device is the usage of a single device during the period of a report.
+// Clients with a known hardware address are reported as one device
+// regardless of their IP addresses.
+type Device struct {
+	HWAddr   string
+	Name     string
+	Group    string
+	IPs      []string
+	InBytes  uint64
+	OutBytes uint64
+	PeakRate float64 // highest in and out bytes per second during a step
+	PeakTime time.Time
+}
+
+// TotalBytes returns the sum of the in and out bytes.
+func (d Device) TotalBytes() uint64 {
+	return d.InBytes + d.OutBytes
+}
+
+// TotalBytes returns the sum of the in and out bytes of all devices.
+func (r Report) TotalBytes() uint64 {
+	return r.InBytes + r.OutBytes
+}
+
+// Prev returns a time within the previous period.
+func (r Report) Prev() time.Time {
+	return r.From.Add(-time.Second)
+}
+
+// Next returns a time within the next period.
+func (r Report) Next() time.Time {
+	return r.To
+}
+
+// Generate creates a report from the history.
+func Generate(store *history.Store, opts Options) (Report, error) {
+	from, to := opts.Period.Range(opts.At)
+	samples, step, err := store.Query(from, to, 0)
+	if err != nil {
+		return Report{}, err
+	}
+	r := Report{
+		Period: opts.Period,
+		From:   from,
+		To:     to,
+		Step:   step,
+	}
+	type stepKey struct {
+		device string
+		t      int64
+	}
+	byKey := make(map[string]*Device)
+	stepBytes := make(map[stepKey]uint64) // traffic of each device per step
+	for _, s := range samples {
+		key := s.HWAddr
+		if key == "" {
+			key = s.IP
+		}
+		d, ok := byKey[key]
+		if !ok {
+			d = &Device{HWAddr: s.HWAddr}
+			byKey[key] = d
+		}
+		d.Name = s.Name
+		if !slices.Contains(d.IPs, s.IP) {
+			d.IPs = append(d.IPs, s.IP)
+		}
+		d.InBytes += s.InBytes
+		d.OutBytes += s.OutBytes
+		r.InBytes += s.InBytes
+		r.OutBytes += s.OutBytes
+		// the peak is of all addresses of the device together, the sum of a
+		// step only grows while its samples are added
+		sk := stepKey{device: key, t: s.Time.Unix()}
+		stepBytes[sk] += s.InBytes + s.OutBytes
+		if rate := float64(stepBytes[sk]) / step.Seconds(); rate > d.PeakRate {
+			d.PeakRate = rate
+			d.PeakTime = s.Time
+		}
+	}
+	for _, d := range byKey {
+		if opts.Alias != nil {
+			if alias, ok := opts.Alias(d.HWAddr); ok {
+				d.Name = alias
+			}
+		}
+		if opts.Group != nil {
+			d.Group = opts.Group(d.HWAddr)
+		}
+		r.Devices = append(r.Devices, *d)
+	}
+	slices.SortFunc(r.Devices, func(a, b Device) int {
+		switch {
+		case a.TotalBytes() > b.TotalBytes():
+			return -1
+		case a.TotalBytes() < b.TotalBytes():
+			return 1
+		}
+		return 0
+	})
+	if opts.Top > 0 && len(r.Devices) > opts.Top {
+		r.Devices = r.Devices[:opts.Top]
+	}
+	return r, nil
+}
+
+// WriteText writes the report as a plain text table.
+func (r Report) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "%s report %s - %s\n", r.Period, r.From.Format(time.DateOnly), r.To.Add(-time.Second).Format(time.DateOnly))
+	fmt.Fprintf(w, "total in: %s out: %s\n\n", fmtBytes(r.InBytes), fmtBytes(r.OutBytes))
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "name\thwaddr\tgroup\tip\tin\tout\ttotal\tpeak rate\tpeak time")
+	for _, d := range r.Devices {
+		ip := ""
+		if len(d.IPs) > 0 {
+			ip = d.IPs[0]
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			d.Name, d.HWAddr, d.Group, ip,
+			fmtBytes(d.InBytes), fmtBytes(d.OutBytes), fmtBytes(d.TotalBytes()),
+			clientstats.FmtBytes(d.PeakRate, "/s"), d.PeakTime.Format(time.DateTime))
+	}
+	return tw.Flush()
+}
+
+func fmtBytes(n uint64) string {
+	if n == 0 {
+		return "0 B"
+	}
+	return clientstats.FmtBytes(float64(n), "")
+}
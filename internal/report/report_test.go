@@ -0,0 +1,88 @@
+package report
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/some-programs/natbwmon/internal/clientstats"
+	"github.com/some-programs/natbwmon/internal/history"
+)
+
+func TestPeriodRange(t *testing.T) {
+	is := is.New(t)
+	at := time.Date(2024, 2, 29, 15, 4, 5, 0, time.UTC) // thursday
+	from, to := Day.Range(at)
+	is.Equal(time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), from)
+	is.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), to)
+	from, to = Week.Range(at)
+	is.Equal(time.Date(2024, 2, 26, 0, 0, 0, 0, time.UTC), from)
+	is.Equal(time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), to)
+	from, to = Month.Range(at)
+	is.Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), from)
+	is.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), to)
+}
+
+func TestGenerate(t *testing.T) {
+	is := is.New(t)
+	tiers, err := history.ParseTiers("10s:24h")
+	is.NoErr(err)
+	store, err := history.Open(t.TempDir(), tiers, 0)
+	is.NoErr(err)
+
+	start := time.Now().Truncate(time.Minute)
+	record := func(t time.Time, laptop, phone uint64) {
+		is.NoErr(store.Record(t, clientstats.Stats{
+			{IP: "192.168.0.2", HWAddr: "00:00:00:00:00:01", InBytes: laptop},
+			{IP: "192.168.0.3", HWAddr: "00:00:00:00:00:02", InBytes: phone},
+		}))
+	}
+	record(start, 1000, 100)
+	record(start.Add(10*time.Second), 1500, 200)
+	record(start.Add(20*time.Second), 1600, 10000)
+
+	rep, err := Generate(store, Options{
+		Period: Day,
+		At:     start,
+		Alias: func(hwaddr string) (string, bool) {
+			return "laptop", hwaddr == "00:00:00:00:00:01"
+		},
+	})
+	is.NoErr(err)
+	is.Equal(2, len(rep.Devices))
+	is.Equal(uint64(11600), rep.InBytes)
+	is.Equal("00:00:00:00:00:02", rep.Devices[0].HWAddr)
+	is.Equal(uint64(10000), rep.Devices[0].InBytes)
+	is.Equal(float64(980), rep.Devices[0].PeakRate)
+	is.Equal("laptop", rep.Devices[1].Name)
+	is.Equal(float64(100), rep.Devices[1].PeakRate)
+	is.True(rep.Devices[1].PeakTime.Equal(start))
+}
+
+func TestGeneratePeakOfDevice(t *testing.T) {
+	is := is.New(t)
+	tiers, err := history.ParseTiers("10s:24h")
+	is.NoErr(err)
+	store, err := history.Open(t.TempDir(), tiers, 0)
+	is.NoErr(err)
+
+	// a device with two addresses, the peak is of both together
+	start := time.Now().Truncate(time.Minute)
+	record := func(t time.Time, a, b uint64) {
+		is.NoErr(store.Record(t, clientstats.Stats{
+			{IP: "192.168.0.2", HWAddr: "00:00:00:00:00:01", InBytes: a},
+			{IP: "192.168.0.3", HWAddr: "00:00:00:00:00:01", InBytes: b},
+		}))
+	}
+	record(start, 600, 0)
+	record(start.Add(10*time.Second), 600, 500)
+	record(start.Add(20*time.Second), 1100, 1000)
+
+	rep, err := Generate(store, Options{Period: Day, At: start})
+	is.NoErr(err)
+	is.Equal(1, len(rep.Devices))
+	is.Equal(uint64(2100), rep.Devices[0].InBytes)
+	is.Equal([]string{"192.168.0.2", "192.168.0.3"}, rep.Devices[0].IPs)
+	is.Equal(float64(100), rep.Devices[0].PeakRate)
+	is.True(rep.Devices[0].PeakTime.Equal(start.Add(20 * time.Second)))
+}
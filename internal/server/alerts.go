@@ -0,0 +1,36 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"github.com/some-programs/natbwmon/internal/alert"
+	"github.com/some-programs/natbwmon/internal/log"
+)
+
+// AlertsV1 is an API resource that returns the pending, firing and recently
+// resolved alerts, the firing alerts first. state filters by state and may
+// be repeated.
+func (s *Server) AlertsV1() AppHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logger := log.FromRequest(r)
+		as := s.Alerts.Alerts()
+		if states := r.URL.Query()["state"]; len(states) > 0 {
+			as = slices.DeleteFunc(as, func(a alert.Alert) bool {
+				return !slices.Contains(states, string(a.State))
+			})
+		}
+		data, err := json.Marshal(&as)
+		if err != nil {
+			logger.Info().Err(err).Msg("")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return nil
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+		return nil
+	}
+}
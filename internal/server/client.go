@@ -0,0 +1,243 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/some-programs/natbwmon/assets"
+	"github.com/some-programs/natbwmon/internal/clientstats"
+	"github.com/some-programs/natbwmon/internal/geoip"
+	"github.com/some-programs/natbwmon/internal/log"
+	"github.com/some-programs/natbwmon/internal/mon"
+	"github.com/some-programs/natbwmon/internal/services"
+)
+
+// findStat returns the stats of the client that has the address ip or nil if
+// there is no such client.
+func findStat(stats clientstats.Stats, ip string) *clientstats.Stat {
+	addr := net.ParseIP(ip)
+	for i, s := range stats {
+		if net.ParseIP(s.IP).Equal(addr) || slices.ContainsFunc(s.IP6, func(v string) bool {
+			return net.ParseIP(v).Equal(addr)
+		}) {
+			return &stats[i]
+		}
+	}
+	return nil
+}
+
+// clientDetail is the data about a single client shown on the client page
+// and returned by the client API resources.
+type clientDetail struct {
+	IP    string
+	Stat  *clientstats.Stat // nil if the address is not a known client
+	Addrs []net.IP          // the addresses of the client
+}
+
+// clientDetail looks up the client with the address ip. An address that
+// does not belong to a known client is still accepted.
+func (s *Server) clientDetail(logger *zerolog.Logger, ip string) (clientDetail, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return clientDetail{}, fmt.Errorf("invalid ip: %s", ip)
+	}
+	d := clientDetail{IP: ip, Addrs: []net.IP{addr}}
+	if stat := findStat(s.MonClients.Stats(), ip); stat != nil {
+		stats := s.withDeviceInfo(logger, clientstats.Stats{*stat})
+		d.Stat = &stats[0]
+		d.Addrs = []net.IP{net.ParseIP(stat.IP)}
+		for _, v := range stat.IP6 {
+			d.Addrs = append(d.Addrs, net.ParseIP(v))
+		}
+	}
+	return d, nil
+}
+
+// services returns the connections of the client grouped by service.
+func (s *Server) services(d clientDetail) ([]services.Usage, error) {
+	fs, err := s.Flows.Flows()
+	if err != nil {
+		return nil, err
+	}
+	return s.Services.Breakdown(fs, d.Addrs), nil
+}
+
+// defaultDestinationLimit is the default number of destinations returned.
+const defaultDestinationLimit = 25
+
+// clientDestination is a remote host of a client with its location and
+// autonomous system.
+type clientDestination struct {
+	mon.Destination
+	Geo geoip.Info `json:"geo"`
+}
+
+// destinations returns the remote hosts the client talks to the most with
+// their cached host names, at most limit unless it is 0.
+func (s *Server) destinations(d clientDetail, limit int) ([]clientDestination, error) {
+	fs, err := s.Flows.Flows()
+	if err != nil {
+		return nil, err
+	}
+	ds := fs.Destinations(d.Addrs)
+	if limit > 0 && len(ds) > limit {
+		ds = ds[:limit]
+	}
+	res := make([]clientDestination, 0, len(ds))
+	for _, v := range ds {
+		if s.DNS != nil {
+			v.Name = s.DNS.Lookup(v.IP)
+		}
+		res = append(res, clientDestination{
+			Destination: v,
+			Geo:         s.GeoIP.Lookup(net.ParseIP(v.IP)),
+		})
+	}
+	return res, nil
+}
+
+type clientTemplateData struct {
+	Title        string
+	NMAP         bool
+	Reports      bool
+	IP           string
+	Stat         *clientstats.Stat
+	Services     []services.Usage
+	Destinations []clientDestination
+}
+
+// Client is a page with the details of a single client.
+func (s *Server) Client() AppHandler {
+	templ, err := template.New("base.html").
+		Funcs(template.FuncMap{
+			"static": assets.StaticHashFS.HashName,
+			"bytes": func(n uint64) string {
+				return clientstats.FmtBytes(float64(n), "")
+			},
+			"time": func(t time.Time) string {
+				return t.Format("2006-01-02 15:04")
+			},
+		},
+		).
+		ParseFS(assets.TemplateFS, "template/base.html", "template/client.html")
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to parse client template")
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logger := log.FromRequest(r)
+		d, err := s.clientDetail(logger, r.URL.Query().Get("ip"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil
+		}
+		svcs, err := s.services(d)
+		if err != nil {
+			logger.Info().Err(err).Msg("")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return nil
+		}
+		dests, err := s.destinations(d, defaultDestinationLimit)
+		if err != nil {
+			logger.Info().Err(err).Msg("")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return nil
+		}
+		data := clientTemplateData{
+			Title:        d.IP,
+			NMAP:         s.NmapEnabled,
+			Reports:      s.History != nil,
+			IP:           d.IP,
+			Stat:         d.Stat,
+			Services:     svcs,
+			Destinations: dests,
+		}
+		if err := templ.Execute(w, &data); err != nil {
+			logger.Info().Err(err).Msg("render client")
+			return err
+		}
+		return nil
+	}
+}
+
+// ClientServicesV1 is an API resource that returns the connections of a
+// client grouped by protocol and service port with their byte and
+// connection counts.
+func (s *Server) ClientServicesV1() AppHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logger := log.FromRequest(r)
+		d, err := s.clientDetail(logger, r.PathValue("ip"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil
+		}
+		svcs, err := s.services(d)
+		if err != nil {
+			logger.Info().Err(err).Msg("")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return nil
+		}
+		data, err := json.Marshal(&svcs)
+		if err != nil {
+			logger.Info().Err(err).Msg("")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return nil
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+		return nil
+	}
+}
+
+// ClientDestinationsV1 is an API resource that returns the remote hosts a
+// client exchanges the most bytes with according to the conntrack counters.
+// Host names are resolved in the background and are empty until they are
+// known. limit is the number of hosts (default 25), 0 returns all.
+func (s *Server) ClientDestinationsV1() AppHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logger := log.FromRequest(r)
+		d, err := s.clientDetail(logger, r.PathValue("ip"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil
+		}
+		limit := defaultDestinationLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			limit, err = strconv.Atoi(v)
+			if err != nil || limit < 0 {
+				http.Error(w, fmt.Sprintf("invalid limit: %s", v), http.StatusBadRequest)
+				return nil
+			}
+		}
+		dests, err := s.destinations(d, limit)
+		if err != nil {
+			logger.Info().Err(err).Msg("")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return nil
+		}
+		data, err := json.Marshal(&dests)
+		if err != nil {
+			logger.Info().Err(err).Msg("")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return nil
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+		return nil
+	}
+}
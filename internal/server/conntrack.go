@@ -0,0 +1,196 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/some-programs/natbwmon/internal/geoip"
+	"github.com/some-programs/natbwmon/internal/log"
+	"github.com/some-programs/natbwmon/internal/mon"
+)
+
+// defaultFlowLimit is the default page size of ConntrackV1.
+const defaultFlowLimit = 1000
+
+// flowQuery is the filtering, ordering and pagination of flows shared by the
+// conntrack page and ConntrackV1.
+type flowQuery struct {
+	IP     net.IP     // ip: any address of the flow
+	Port   int        // port: any port of the flow
+	Proto  string     // proto: protocol name or number
+	Net    *net.IPNet // cidr: any address of the flow is in the network
+	Order  []string   // o: orderings, the first one is the primary order
+	Offset int        // offset: number of flows to skip
+	Limit  int        // limit: maximum number of flows
+}
+
+func parseFlowQuery(q url.Values) (flowQuery, error) {
+	fq := flowQuery{
+		Proto: q.Get("proto"),
+		Order: q["o"],
+		Limit: defaultFlowLimit,
+	}
+	if v := q.Get("ip"); v != "" {
+		fq.IP = net.ParseIP(v)
+		if fq.IP == nil {
+			return fq, fmt.Errorf("invalid ip: %s", v)
+		}
+	}
+	if v := q.Get("cidr"); v != "" {
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return fq, fmt.Errorf("invalid cidr: %s", v)
+		}
+		fq.Net = n
+	}
+	for _, v := range []struct {
+		name string
+		dst  *int
+	}{
+		{"port", &fq.Port},
+		{"offset", &fq.Offset},
+		{"limit", &fq.Limit},
+	} {
+		s := q.Get(v.name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return fq, fmt.Errorf("invalid %s: %s", v.name, s)
+		}
+		*v.dst = n
+	}
+	return fq, nil
+}
+
+// apply returns the flows that match the filters in order, the pagination is
+// not applied.
+func (fq flowQuery) apply(fs mon.FlowSlice) mon.FlowSlice {
+	if fq.IP != nil {
+		fs = fs.FilterByIP(fq.IP)
+	}
+	if fq.Port != 0 {
+		fs = fs.FilterByPort(fq.Port)
+	}
+	if fq.Proto != "" {
+		fs = fs.FilterByProto(fq.Proto)
+	}
+	if fq.Net != nil {
+		fs = fs.FilterByNet(fq.Net)
+	}
+	for i := len(fq.Order) - 1; i >= 0; i-- {
+		switch fq.Order[i] {
+		case "ttl":
+			fs.OrderByTTL()
+		case "proto":
+			fs.OrderByProto()
+		case "orig_src":
+			fs.OrderByOriginalSPort()
+			fs.OrderByOriginalSource()
+		case "orig_dst":
+			fs.OrderByOriginalDPort()
+			fs.OrderByOriginalDestination()
+		case "orig_bytes":
+			fs.OrderByOriginalBytes()
+		case "reply_src":
+			fs.OrderByReplySPort()
+			fs.OrderByReplySource()
+		case "reply_dst":
+			fs.OrderByReplyDPort()
+			fs.OrderByReplyDestination()
+		case "reply_bytes":
+			fs.OrderByReplyBytes()
+		}
+	}
+	return fs
+}
+
+// page returns the flows of the requested page.
+func (fq flowQuery) page(fs mon.FlowSlice) mon.FlowSlice {
+	if fq.Offset >= len(fs) {
+		return mon.FlowSlice{}
+	}
+	fs = fs[fq.Offset:]
+	if fq.Limit < len(fs) {
+		fs = fs[:fq.Limit]
+	}
+	return fs
+}
+
+// conntrackResponse is the JSON response of ConntrackV1.
+type conntrackResponse struct {
+	Total  int                   `json:"total"` // number of flows matching the filters
+	Offset int                   `json:"offset"`
+	Limit  int                   `json:"limit"`
+	Flows  mon.FlowSlice         `json:"flows"`
+	Geo    map[string]geoip.Info `json:"geo,omitempty"` // by address of the flows
+}
+
+// flowsGeo returns the geoip info of the public addresses of the flows.
+func (s *Server) flowsGeo(fs mon.FlowSlice) map[string]geoip.Info {
+	if s.GeoIP == nil {
+		return nil
+	}
+	res := make(map[string]geoip.Info)
+	for _, f := range fs {
+		for _, ip := range []net.IP{f.Orig.Source, f.Orig.Destination, f.Reply.Source, f.Reply.Destination} {
+			if ip.IsPrivate() || !ip.IsGlobalUnicast() {
+				continue
+			}
+			k := ip.String()
+			if _, ok := res[k]; ok {
+				continue
+			}
+			if info := s.GeoIP.Lookup(ip); info != (geoip.Info{}) {
+				res[k] = info
+			}
+		}
+	}
+	return res
+}
+
+// ConntrackV1 is an API resource that returns the tracked connections as
+// JSON. It accepts the ip and o parameters of the conntrack page and
+// additionally port, proto (ex: tcp or 6) and cidr filters and offset and
+// limit (default 1000) for pagination.
+func (s *Server) ConntrackV1() AppHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logger := log.FromRequest(r)
+		fq, err := parseFlowQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil
+		}
+		fs, err := s.Flows.Flows()
+		if err != nil {
+			logger.Info().Err(err).Msg("")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return nil
+		}
+		fs = fq.apply(fs)
+		page := fq.page(fs)
+		data, err := json.Marshal(&conntrackResponse{
+			Total:  len(fs),
+			Offset: fq.Offset,
+			Limit:  fq.Limit,
+			Flows:  page,
+			Geo:    s.flowsGeo(page),
+		})
+		if err != nil {
+			logger.Info().Err(err).Msg("")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return nil
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+		return nil
+	}
+}
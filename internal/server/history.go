@@ -0,0 +1,184 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/some-programs/natbwmon/internal/clientstats"
+	"github.com/some-programs/natbwmon/internal/history"
+	"github.com/some-programs/natbwmon/internal/log"
+)
+
+// historyResponse is the JSON response of HistoryV1.
+type historyResponse struct {
+	From   time.Time       `json:"from"`
+	To     time.Time       `json:"to"`
+	Step   float64         `json:"step"` // seconds
+	Series []historySeries `json:"series"`
+}
+
+// historySeries is the traffic of a single client over time.
+type historySeries struct {
+	IP       string         `json:"ip"`
+	HWAddr   string         `json:"hwaddr"`
+	Name     string         `json:"name"`
+	Group    string         `json:"group"`
+	InBytes  uint64         `json:"in_bytes"`  // total during the queried range
+	OutBytes uint64         `json:"out_bytes"` // total during the queried range
+	Points   []historyPoint `json:"points"`
+}
+
+// historyPoint is the traffic of a client during a step.
+type historyPoint struct {
+	Time time.Time `json:"time"`
+	history.Counts
+}
+
+// HistoryV1 is an API resource that returns the per client traffic between
+// from and to summed up per step as JSON or as CSV if format=csv.
+//
+// from and to are RFC 3339 times, unix seconds or durations relative to now
+// (ex: -24h), they default to the last hour. step is a duration, the step of
+// the stored history is used by default. The clients can be filtered and
+// ordered using the same parameters as StatsV1, the bytes_in and bytes_out
+// orders use the totals during the queried range.
+func (s *Server) HistoryV1() AppHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logger := log.FromRequest(r)
+		q := r.URL.Query()
+		now := time.Now()
+		to, err := parseTime(q.Get("to"), now, now)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid to: %v", err), http.StatusBadRequest)
+			return nil
+		}
+		from, err := parseTime(q.Get("from"), now, to.Add(-time.Hour))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid from: %v", err), http.StatusBadRequest)
+			return nil
+		}
+		var step time.Duration
+		if v := q.Get("step"); v != "" {
+			step, err = time.ParseDuration(v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid step: %v", err), http.StatusBadRequest)
+				return nil
+			}
+		}
+
+		samples, step, err := s.History.Query(from, to, step)
+		if err != nil {
+			logger.Info().Err(err).Msg("history query failed")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return nil
+		}
+		filter := clientstats.ParseFilter(q)
+		filter.Interfaces = nil // the interfaces of clients are not recorded
+		series := s.historySeries(samples, filter, q.Get("order_by"))
+
+		if q.Get("format") == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			cw := csv.NewWriter(w)
+			cw.Write([]string{"time", "ip", "hwaddr", "name", "group", "in_bytes", "out_bytes", "in_packets", "out_packets"})
+			for _, v := range series {
+				for _, p := range v.Points {
+					cw.Write([]string{
+						p.Time.Format(time.RFC3339),
+						v.IP, v.HWAddr, v.Name, v.Group,
+						strconv.FormatUint(p.InBytes, 10),
+						strconv.FormatUint(p.OutBytes, 10),
+						strconv.FormatUint(p.InPackets, 10),
+						strconv.FormatUint(p.OutPackets, 10),
+					})
+				}
+			}
+			cw.Flush()
+			return cw.Error()
+		}
+
+		data, err := json.Marshal(&historyResponse{
+			From:   from,
+			To:     to,
+			Step:   step.Seconds(),
+			Series: series,
+		})
+		if err != nil {
+			logger.Info().Err(err).Msg("")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return nil
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+		return nil
+	}
+}
+
+// historySeries groups the samples per client and returns the clients that
+// match filter in the named order.
+func (s *Server) historySeries(samples []history.Sample, filter clientstats.Filter, orderBy string) []historySeries {
+	type key struct{ ip, hwaddr string }
+	byClient := make(map[key]*historySeries)
+	var stats clientstats.Stats
+	for _, sample := range samples {
+		k := key{ip: sample.IP, hwaddr: sample.HWAddr}
+		v, ok := byClient[k]
+		if !ok {
+			v = &historySeries{
+				IP:     sample.IP,
+				HWAddr: sample.HWAddr,
+				Group:  s.MonClients.Group(sample.HWAddr),
+			}
+			byClient[k] = v
+		}
+		v.Name = sample.Name
+		if alias, ok := s.MonClients.Alias(sample.HWAddr); ok {
+			v.Name = alias
+		}
+		v.InBytes += sample.InBytes
+		v.OutBytes += sample.OutBytes
+		v.Points = append(v.Points, historyPoint{Time: sample.Time, Counts: sample.Counts})
+	}
+	for _, v := range byClient {
+		stats = append(stats, clientstats.Stat{
+			IP:       v.IP,
+			HWAddr:   v.HWAddr,
+			Name:     v.Name,
+			Group:    v.Group,
+			InBytes:  v.InBytes,
+			OutBytes: v.OutBytes,
+		})
+	}
+	stats = stats.Filter(filter)
+	stats.OrderBy(orderBy)
+	res := make([]historySeries, 0, len(stats))
+	for _, st := range stats {
+		res = append(res, *byClient[key{ip: st.IP, hwaddr: st.HWAddr}])
+	}
+	return res
+}
+
+// parseTime parses an RFC 3339 time, unix seconds or a duration relative to
+// now. def is returned for an empty string.
+func parseTime(s string, now, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected RFC 3339 time, unix seconds or duration: %s", s)
+	}
+	return now.Add(d), nil
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/some-programs/natbwmon/internal/clientstats"
+	"github.com/some-programs/natbwmon/internal/history"
+	"github.com/some-programs/natbwmon/internal/mon"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	def := now.Add(-time.Hour)
+	tests := []struct {
+		s    string
+		want time.Time
+		err  bool
+	}{
+		{s: "", want: def},
+		{s: "2024-03-09T08:30:00Z", want: time.Date(2024, 3, 9, 8, 30, 0, 0, time.UTC)},
+		{s: "1710000000", want: time.Unix(1710000000, 0)},
+		{s: "-24h", want: now.Add(-24 * time.Hour)},
+		{s: "30m", want: now.Add(30 * time.Minute)},
+		{s: "yesterday", err: true},
+		{s: "2024-03-09", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			is := is.New(t)
+			got, err := parseTime(tt.s, now, def)
+			if tt.err {
+				is.True(err != nil)
+				return
+			}
+			is.NoErr(err)
+			is.True(got.Equal(tt.want))
+		})
+	}
+}
+
+func TestHistorySeries(t *testing.T) {
+	is := is.New(t)
+	s := &Server{
+		MonClients: mon.NewClients(1,
+			map[string]string{"00:00:00:00:00:02": "nas"},
+			map[string]string{"00:00:00:00:00:01": "media", "00:00:00:00:00:02": "media"},
+			nil),
+	}
+	t0 := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	sample := func(dt time.Duration, ip, hwaddr, name string, in, out uint64) history.Sample {
+		return history.Sample{
+			Time:   t0.Add(dt),
+			Series: history.Series{IP: ip, HWAddr: hwaddr, Name: name},
+			Counts: history.Counts{InBytes: in, OutBytes: out},
+		}
+	}
+	samples := []history.Sample{
+		sample(0, "192.168.0.10", "00:00:00:00:00:01", "tv", 100, 10),
+		sample(0, "192.168.0.20", "00:00:00:00:00:02", "storage", 500, 50),
+		sample(0, "192.168.0.30", "00:00:00:00:00:03", "phone", 10, 1000),
+		sample(time.Minute, "192.168.0.10", "00:00:00:00:00:01", "living-room-tv", 300, 10),
+		sample(time.Minute, "192.168.0.20", "00:00:00:00:00:02", "storage", 100, 50),
+	}
+
+	series := s.historySeries(samples, clientstats.Filter{}, "bytes_in")
+	is.Equal(len(series), 3)
+	is.Equal(series[0].IP, "192.168.0.20")
+	is.Equal(series[0].Name, "nas") // the alias wins
+	is.Equal(series[0].Group, "media")
+	is.Equal(series[0].InBytes, uint64(600))
+	is.Equal(series[0].OutBytes, uint64(100))
+	is.Equal(len(series[0].Points), 2)
+	is.Equal(series[1].IP, "192.168.0.10")
+	is.Equal(series[1].Name, "living-room-tv") // the latest name
+	is.Equal(series[1].InBytes, uint64(400))
+	is.Equal(series[1].Points[1].Time, t0.Add(time.Minute))
+	is.Equal(series[1].Points[1].InBytes, uint64(300))
+	is.Equal(series[2].IP, "192.168.0.30")
+	is.Equal(series[2].Group, "")
+
+	series = s.historySeries(samples, clientstats.Filter{}, "")
+	is.Equal(series[0].IP, "192.168.0.10") // by IP
+
+	series = s.historySeries(samples, clientstats.Filter{Groups: []string{"media"}}, "bytes_out")
+	is.Equal(len(series), 2)
+	is.Equal(series[0].IP, "192.168.0.20")
+	is.Equal(series[1].IP, "192.168.0.10")
+
+	series = s.historySeries(samples, clientstats.Filter{Names: []string{"nas"}}, "")
+	is.Equal(len(series), 1)
+	is.Equal(series[0].HWAddr, "00:00:00:00:00:02")
+
+	is.Equal(len(s.historySeries(nil, clientstats.Filter{}, "")), 0)
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/some-programs/natbwmon/assets"
+	"github.com/some-programs/natbwmon/internal/inventory"
+	"github.com/some-programs/natbwmon/internal/log"
+)
+
+// newDeviceAge is how long a device is highlighted as new on the inventory
+// page.
+const newDeviceAge = 24 * time.Hour
+
+// filterDevices returns the devices with any of the hwaddr query parameters
+// that were first seen within the since duration, if they are set.
+func filterDevices(ds []inventory.Device, q url.Values) ([]inventory.Device, error) {
+	if hwaddrs := q["hwaddr"]; len(hwaddrs) > 0 {
+		ds = slices.DeleteFunc(ds, func(d inventory.Device) bool {
+			return !slices.Contains(hwaddrs, d.HWAddr)
+		})
+	}
+	if v := q.Get("since"); v != "" {
+		since, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid since: %s", v)
+		}
+		ds = slices.DeleteFunc(ds, func(d inventory.Device) bool {
+			return time.Since(d.FirstSeen) > since
+		})
+	}
+	return ds, nil
+}
+
+// inventoryTemplateData .
+type inventoryTemplateData struct {
+	Title   string
+	Reports bool
+	Since   string
+	Devices []inventory.Device
+}
+
+// Inventory serves the web page with every device that has been seen on the
+// network, the most recently first seen first.
+func (s *Server) Inventory() AppHandler {
+	templ, err := template.New("base.html").
+		Funcs(template.FuncMap{
+			"static": assets.StaticHashFS.HashName,
+			"time": func(t time.Time) string {
+				return t.Format("2006-01-02 15:04")
+			},
+			"isnew": func(t time.Time) bool {
+				return time.Since(t) < newDeviceAge
+			},
+		},
+		).
+		ParseFS(assets.TemplateFS, "template/base.html", "template/inventory.html")
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to parse inventory template")
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logger := log.FromRequest(r)
+		ds, err := filterDevices(s.Devices.Devices(), r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil
+		}
+		data := inventoryTemplateData{
+			Title:   "inventory",
+			Reports: s.History != nil,
+			Since:   r.URL.Query().Get("since"),
+			Devices: ds,
+		}
+		if err := templ.Execute(w, &data); err != nil {
+			logger.Info().Err(err).Msg("render inventory")
+			return err
+		}
+		return nil
+	}
+}
+
+// DevicesV1 is an API resource that returns every device that has been seen
+// on the network with the addresses and host names it has used, the most
+// recently first seen first. The devices can be filtered by hwaddr, which may
+// be repeated, and since, ex: since=24h returns the devices first seen
+// during the last day.
+func (s *Server) DevicesV1() AppHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logger := log.FromRequest(r)
+		ds, err := filterDevices(s.Devices.Devices(), r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil
+		}
+		data, err := json.Marshal(&ds)
+		if err != nil {
+			logger.Info().Err(err).Msg("")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return nil
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+		return nil
+	}
+}
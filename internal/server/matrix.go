@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/some-programs/natbwmon/internal/geoip"
+	"github.com/some-programs/natbwmon/internal/log"
+	"github.com/some-programs/natbwmon/internal/mon"
+)
+
+const defaultMatrixTop = 100
+
+// remoteTraffic is the traffic between a client and a remote network with
+// the geo information of the network.
+type remoteTraffic struct {
+	mon.RemoteTraffic
+	Geo geoip.Info `json:"geo"`
+}
+
+// remoteTraffic returns the top n entries of the remote traffic matrix,
+// only the entries of clients if it is not empty.
+func (s *Server) remoteTraffic(n int, clients ...string) []remoteTraffic {
+	ts := s.Matrix.Top(n, clients...)
+	res := make([]remoteTraffic, 0, len(ts))
+	for _, t := range ts {
+		v := remoteTraffic{RemoteTraffic: t}
+		if ip, _, err := net.ParseCIDR(t.Remote); err == nil {
+			v.Geo = s.GeoIP.Lookup(ip)
+		}
+		res = append(res, v)
+	}
+	return res
+}
+
+// RemoteMatrixV1 is an API resource that returns the traffic of clients per
+// remote network (/24 or /48) and protocol, ordered by the bytes during the
+// rolling window. ip filters by client address and may be repeated, top is
+// the number of entries (default 100), 0 returns all.
+func (s *Server) RemoteMatrixV1() AppHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logger := log.FromRequest(r)
+		q := r.URL.Query()
+		clients := q["ip"]
+		for _, ip := range clients {
+			if net.ParseIP(ip) == nil {
+				http.Error(w, fmt.Sprintf("invalid ip address: %s", ip), http.StatusBadRequest)
+				return nil
+			}
+		}
+		top := defaultMatrixTop
+		if v := q.Get("top"); v != "" {
+			var err error
+			top, err = strconv.Atoi(v)
+			if err != nil || top < 0 {
+				http.Error(w, fmt.Sprintf("invalid top: %s", v), http.StatusBadRequest)
+				return nil
+			}
+		}
+		data, err := json.Marshal(s.remoteTraffic(top, clients...))
+		if err != nil {
+			logger.Info().Err(err).Msg("")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return nil
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+		return nil
+	}
+}
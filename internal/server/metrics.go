@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/some-programs/natbwmon/internal/clientstats"
+	"github.com/some-programs/natbwmon/internal/log"
+	"github.com/some-programs/natbwmon/internal/metrics"
+)
+
+// Metrics serves the client, conntrack and collector metrics in the
+// Prometheus text exposition format.
+//
+// The client byte and packet counters are the totals since the client was
+// first seen which never decrease when the accounting rules change.
+func (s *Server) Metrics() AppHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logger := log.FromRequest(r)
+		stats := s.withDeviceInfo(logger, s.MonClients.Stats())
+		stats.OrderByIP()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		mw := metrics.NewWriter(w)
+		writeClientMetrics(mw, stats)
+
+		mw.Family("natbwmon_conntrack_tracked_flows", "gauge", "Number of forwarded connections in the conntrack table of natbwmon.")
+		mw.Sample("natbwmon_conntrack_tracked_flows", float64(s.Flows.Len()))
+		for _, v := range []struct{ name, help, path string }{
+			{"natbwmon_conntrack_entries", "Number of entries in the kernel conntrack table.", "/proc/sys/net/netfilter/nf_conntrack_count"},
+			{"natbwmon_conntrack_entries_limit", "Maximum number of entries in the kernel conntrack table.", "/proc/sys/net/netfilter/nf_conntrack_max"},
+		} {
+			n, err := readProcUint(v.path)
+			if err != nil {
+				continue
+			}
+			mw.Family(v.name, "gauge", v.help)
+			mw.Sample(v.name, float64(n))
+		}
+
+		if s.Health != nil {
+			s.Health.Write(mw)
+		}
+		if err := mw.Err(); err != nil {
+			logger.Info().Err(err).Msg("write metrics")
+		}
+		return nil
+	}
+}
+
+func writeClientMetrics(mw *metrics.Writer, stats clientstats.Stats) {
+	labels := func(s clientstats.Stat, extra ...string) []string {
+		return append([]string{
+			"ip", s.IP,
+			"hwaddr", s.HWAddr,
+			"name", s.Name,
+			"manufacturer", s.Manufacturer,
+			"interface", s.Interface,
+		}, extra...)
+	}
+
+	mw.Family("natbwmon_clients", "gauge", "Number of known clients.")
+	mw.Sample("natbwmon_clients", float64(len(stats)))
+
+	mw.Family("natbwmon_client_bytes_total", "counter", "Bytes forwarded for the client since it was first seen.")
+	for _, s := range stats {
+		mw.Sample("natbwmon_client_bytes_total", float64(s.InBytes), labels(s, "direction", "in")...)
+		mw.Sample("natbwmon_client_bytes_total", float64(s.OutBytes), labels(s, "direction", "out")...)
+	}
+	mw.Family("natbwmon_client_packets_total", "counter", "Packets forwarded for the client since it was first seen.")
+	for _, s := range stats {
+		mw.Sample("natbwmon_client_packets_total", float64(s.InPackets), labels(s, "direction", "in")...)
+		mw.Sample("natbwmon_client_packets_total", float64(s.OutPackets), labels(s, "direction", "out")...)
+	}
+	mw.Family("natbwmon_client_rate_bytes", "gauge", "Current moving average of the bytes per second forwarded for the client.")
+	for _, s := range stats {
+		mw.Sample("natbwmon_client_rate_bytes", s.InRate, labels(s, "direction", "in")...)
+		mw.Sample("natbwmon_client_rate_bytes", s.OutRate, labels(s, "direction", "out")...)
+	}
+	mw.Family("natbwmon_client_rate_packets", "gauge", "Current moving average of the packets per second forwarded for the client.")
+	for _, s := range stats {
+		mw.Sample("natbwmon_client_rate_packets", s.InPacketRate, labels(s, "direction", "in")...)
+		mw.Sample("natbwmon_client_rate_packets", s.OutPacketRate, labels(s, "direction", "out")...)
+	}
+}
+
+func readProcUint(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
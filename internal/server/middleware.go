@@ -1,6 +1,8 @@
 package server
 
 import (
+	"bufio"
+	"net"
 	"net/http"
 
 	"github.com/some-programs/natbwmon/internal/log"
@@ -38,6 +40,17 @@ func (r *responseWriter) Write(b []byte) (int, error) {
 	return r.ResponseWriter.Write(b)
 }
 
+// Unwrap returns the wrapped ResponseWriter for http.ResponseController.
+func (r *responseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Hijack lets the connection be taken over, as done by websocket upgrades.
+func (r *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.hasWritten = true
+	return http.NewResponseController(r.ResponseWriter).Hijack()
+}
+
 // maxBytesReaderMiddleware .
 type maxBytesReaderMiddleware struct {
 	h http.Handler
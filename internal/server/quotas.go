@@ -0,0 +1,44 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/some-programs/natbwmon/internal/log"
+)
+
+// QuotasV1 is an API resource that returns the consumption of every
+// configured quota in the current period.
+func (s *Server) QuotasV1() AppHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logger := log.FromRequest(r)
+		qs := s.Quotas.Status()
+		data, err := json.Marshal(&qs)
+		if err != nil {
+			logger.Info().Err(err).Msg("")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return nil
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(data)
+		return nil
+	}
+}
+
+// ResetQuotasV1 starts all quotas over and lifts their enforcement.
+func (s *Server) ResetQuotasV1() AppHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logger := log.FromRequest(r)
+		if err := s.Quotas.Reset(); err != nil {
+			logger.Info().Err(err).Msg("")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return nil
+		}
+		logger.Info().Msg("quotas reset")
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/some-programs/natbwmon/assets"
+	"github.com/some-programs/natbwmon/internal/clientstats"
+	"github.com/some-programs/natbwmon/internal/log"
+	"github.com/some-programs/natbwmon/internal/report"
+)
+
+// reportsTemplateData .
+type reportsTemplateData struct {
+	Title  string
+	Report report.Report
+	At     time.Time // the requested date
+	Last   time.Time // the last moment of the reported period
+}
+
+// Reports serves the usage report web page.
+func (s *Server) Reports() AppHandler {
+	templ, err := template.New("base.html").
+		Funcs(template.FuncMap{
+			"static": assets.StaticHashFS.HashName,
+			"bytes": func(n uint64) string {
+				return clientstats.FmtBytes(float64(n), "")
+			},
+			"rate": func(n float64) string {
+				return clientstats.FmtBytes(n, "/s")
+			},
+			"date": func(t time.Time) string {
+				return t.Format(time.DateOnly)
+			},
+		},
+		).
+		ParseFS(assets.TemplateFS, "template/base.html", "template/reports.html")
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to parse reports template")
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logger := log.FromRequest(r)
+		q := r.URL.Query()
+		var err error
+		period := report.Month
+		if v := q.Get("period"); v != "" {
+			period, err = report.ParsePeriod(v)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return nil
+			}
+		}
+		at := time.Now()
+		if v := q.Get("date"); v != "" {
+			at, err = time.ParseInLocation(time.DateOnly, v, time.Local)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return nil
+			}
+		}
+		rep, err := report.Generate(s.History, report.Options{
+			Period: period,
+			At:     at,
+			Alias:  s.MonClients.Alias,
+			Group:  s.MonClients.Group,
+		})
+		if err != nil {
+			logger.Info().Err(err).Msg("")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return nil
+		}
+		data := reportsTemplateData{
+			Title:  "reports",
+			Report: rep,
+			At:     at,
+			Last:   rep.To.Add(-time.Second),
+		}
+		if err := templ.Execute(w, &data); err != nil {
+			logger.Info().Err(err).Msg("render reports")
+			return err
+		}
+		return nil
+	}
+}
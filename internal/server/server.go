@@ -9,23 +9,44 @@ import (
 	"net/http"
 	"os/exec"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/benbjohnson/hashfs"
 	"github.com/justinas/alice"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/hlog"
 	"github.com/some-programs/natbwmon/assets"
+	"github.com/some-programs/natbwmon/internal/alert"
 	"github.com/some-programs/natbwmon/internal/clientstats"
+	"github.com/some-programs/natbwmon/internal/events"
+	"github.com/some-programs/natbwmon/internal/geoip"
+	"github.com/some-programs/natbwmon/internal/history"
+	"github.com/some-programs/natbwmon/internal/inventory"
 	"github.com/some-programs/natbwmon/internal/log"
+	"github.com/some-programs/natbwmon/internal/metrics"
 	"github.com/some-programs/natbwmon/internal/mon"
+	"github.com/some-programs/natbwmon/internal/quota"
+	"github.com/some-programs/natbwmon/internal/services"
 )
 
 // Server contains the web page and JSON API routes.
 type Server struct {
 	MonClients  *mon.Clients
+	Flows       *mon.FlowTracker
+	Events      *events.Bus
+	Health      *metrics.Health
+	History     *history.Store // nil if the history is disabled
 	NmapEnabled bool
 	OUILookup   func(s string) (string, error)
+	Services    *services.DB
+	DNS         *mon.DNSCache     // reverse DNS names of remote hosts, may be nil
+	GeoIP       *geoip.DB         // may be nil
+	Matrix      *mon.RemoteMatrix // nil if the remote traffic matrix is disabled
+	Devices     *inventory.Store
+	Alerts      *alert.Manager // nil if there are no alert rules
+	Quotas      *quota.Manager // nil if there are no quotas
+
+	stream statsStream
 }
 
 // Routes returns a *http.ServeMux with all the application request handlers.
@@ -53,7 +74,31 @@ func (s *Server) Routes() *http.ServeMux {
 
 	mux.Handle("/", c.Then(s.Clients()))
 	mux.Handle("/conntrack", c.Then(s.Conntrack()))
+	mux.Handle("/client", c.Then(s.Client()))
+	mux.Handle("/inventory", c.Then(s.Inventory()))
+	mux.Handle("/v1/devices/", c.Then(s.DevicesV1()))
+	mux.Handle("GET /v1/clients/{ip}/services", c.Then(s.ClientServicesV1()))
+	mux.Handle("GET /v1/clients/{ip}/destinations", c.Then(s.ClientDestinationsV1()))
+	mux.Handle("/v1/conntrack/", c.Then(s.ConntrackV1()))
 	mux.Handle("/v1/stats/", c.Then(s.StatsV1()))
+	mux.Handle("/v1/stats/stream", c.Then(s.StatsStreamV1()))
+	mux.Handle("/v1/ws", c.Then(s.WebSocketV1()))
+	mux.Handle("/metrics", c.Then(s.Metrics()))
+	mux.Handle("POST /v1/stats/reset", c.Then(s.ResetStatsV1()))
+	if s.Alerts != nil {
+		mux.Handle("/v1/alerts", c.Then(s.AlertsV1()))
+	}
+	if s.Quotas != nil {
+		mux.Handle("GET /v1/quotas", c.Then(s.QuotasV1()))
+		mux.Handle("POST /v1/quotas/reset", c.Then(s.ResetQuotasV1()))
+	}
+	if s.Matrix != nil {
+		mux.Handle("/v1/matrix/", c.Then(s.RemoteMatrixV1()))
+	}
+	if s.History != nil {
+		mux.Handle("/v1/history/", c.Then(s.HistoryV1()))
+		mux.Handle("/reports", c.Then(s.Reports()))
+	}
 	if s.NmapEnabled {
 		mux.Handle("/v0/nmap/", c.Then(s.NmapV0()))
 	}
@@ -96,9 +141,16 @@ type conntrackTemplateData struct {
 	IPFilter    string
 	OrderFilter string
 	NMAP        bool
+	Reports     bool
 	IP          string
+	Matrix      []remoteTraffic // nil if the matrix is disabled
+	Window      time.Duration
 }
 
+// conntrackMatrixTop is the number of remote traffic matrix entries on the
+// conntrack page.
+const conntrackMatrixTop = 20
+
 // Clients serves the connection tracking web page.
 func (s *Server) Conntrack() AppHandler {
 	templ, err := template.New("base.html").
@@ -116,6 +168,18 @@ func (s *Server) Conntrack() AppHandler {
 			"bytes": func(n uint64) string {
 				return clientstats.FmtBytes(float64(n), "")
 			},
+			"geo": func(ip net.IP) string {
+				if ip.IsPrivate() {
+					return ""
+				}
+				return s.GeoIP.Lookup(ip).String()
+			},
+			"hostname": func(ip net.IP) string {
+				if s.DNS == nil || ip.IsPrivate() {
+					return ""
+				}
+				return s.DNS.Lookup(ip.String())
+			},
 		},
 		).
 		ParseFS(assets.TemplateFS, "template/base.html", "template/conntrack.html")
@@ -123,69 +187,39 @@ func (s *Server) Conntrack() AppHandler {
 		log.Fatal().Err(err).Msg("failed to parse conntrack template")
 	}
 
-	filter := func(fs mon.FlowSlice, r *http.Request) mon.FlowSlice {
-		q := r.URL.Query()
-		ip := q.Get("ip")
-		if ip != "" {
-			ip := net.ParseIP(ip)
-			fs = fs.FilterByIP(ip)
-		}
-		return fs
-	}
-
-	order := func(fs mon.FlowSlice, r *http.Request) {
-		q := r.URL.Query()
-		ords := q["o"]
-		for i := len(ords) - 1; i >= 0; i-- {
-			orderBy := ords[i]
-			switch orderBy {
-			case "ttl":
-				fs.OrderByTTL()
-			case "orig_src":
-				fs.OrderByOriginalSPort()
-				fs.OrderByOriginalSource()
-			case "orig_dst":
-				fs.OrderByOriginalDPort()
-				fs.OrderByOriginalDestination()
-			case "orig_bytes":
-				fs.OrderByOriginalBytes()
-			case "reply_src":
-				fs.OrderByReplySPort()
-				fs.OrderByReplySource()
-			case "reply_dst":
-				fs.OrderByReplyDPort()
-				fs.OrderByReplyDestination()
-			case "reply_bytes":
-				fs.OrderByReplyBytes()
-
-			}
-		}
-	}
-
-	var conntrackMu sync.Mutex // limit to a single concurrent to deter abuse
-
 	return func(w http.ResponseWriter, r *http.Request) error {
-		conntrackMu.Lock()
-		defer conntrackMu.Unlock()
 		logger := log.FromRequest(r)
-		fs, err := mon.Flows()
+		fs, err := s.Flows.Flows()
 		if err != nil {
 			logger.Info().Err(err).Msg("")
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
 			return nil
 		}
-		fs = filter(fs, r)
-		order(fs, r)
+		fq, err := parseFlowQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil
+		}
+		fs = fq.apply(fs)
 
 		data := conntrackTemplateData{
 			NMAP:        s.NmapEnabled,
+			Reports:     s.History != nil,
 			IP:          r.URL.Query().Get("ip"),
 			FS:          fs,
 			Title:       "conntrack",
 			IPFilter:    r.URL.Query().Get("ip"),
 			OrderFilter: r.URL.Query().Get("o"),
 		}
+		if s.Matrix != nil {
+			var clients []string
+			if fq.IP != nil {
+				clients = append(clients, fq.IP.String())
+			}
+			data.Matrix = s.remoteTraffic(conntrackMatrixTop, clients...)
+			data.Window = s.Matrix.Window()
+		}
 		if err := templ.Execute(w, &data); err != nil {
 			logger.Info().Err(err).Msg("render conntrack")
 			return err
@@ -197,86 +231,12 @@ func (s *Server) Conntrack() AppHandler {
 // StatsV1 is an API resource that returns a JSON encoded respons with the
 // current list of identified network devices and their current bandwidth rate.
 func (s *Server) StatsV1() AppHandler {
-	order := func(s clientstats.Stats, r *http.Request) {
-		s.OrderByIP()
-		orderBy := r.URL.Query().Get("order_by")
-		switch orderBy {
-		case "rate_in":
-			s.OrderByInRate()
-		case "rate_out":
-			s.OrderByOutRate()
-		case "hwaddr":
-			s.OrderByHWAddr()
-		case "name":
-			s.OrderByName()
-		case "manufacturer":
-			s.OrderByHWAddr()
-			s.OrderByManufacturer()
-		}
-	}
-
-	filter := func(ss clientstats.Stats, r *http.Request) clientstats.Stats {
-		q := r.URL.Query()
-		IPs := q["ip"]
-		HWAddrs := q["hwaddr"]
-		names := q["name"]
-
-		if len(IPs) == 0 && len(HWAddrs) == 0 && len(names) == 0 {
-			return ss
-		}
-		res := make(clientstats.Stats, 0, len(ss))
-	loop:
-		for _, s := range ss {
-			for _, v := range IPs {
-				if s.IP == v {
-					res = append(res, s)
-					continue loop
-				}
-			}
-			for _, v := range names {
-				if s.Name == v {
-					res = append(res, s)
-					continue loop
-				}
-			}
-			for _, v := range HWAddrs {
-				if s.HWAddr == v {
-					res = append(res, s)
-					continue loop
-				}
-			}
-		}
-		return res
-	}
-
 	return func(w http.ResponseWriter, r *http.Request) error {
 		logger := log.FromRequest(r)
 		c := s.MonClients.Stats()
-		c = filter(c, r)
-		res := make(clientstats.Stats, 0, len(c))
-		for _, stat := range c {
-			v, err := s.OUILookup(stat.HWAddr)
-			if err != nil {
-				logger.Warn().Err(err).Msg("lookup error")
-			} else {
-				stat.Manufacturer = v
-			}
-			if stat.Manufacturer == "" {
-				hwa, err := net.ParseMAC(stat.HWAddr)
-				if err != nil {
-					logger.Error().Err(err).Msg("error parsing hardware addr")
-				} else {
-					switch {
-					case (hwa[0] & 1) > 0:
-						stat.Manufacturer = "{multicast}"
-					case (hwa[0] & 2) > 0:
-						stat.Manufacturer = "{local/random}"
-					}
-				}
-			}
-			res = append(res, stat)
-		}
-		order(res, r)
+		c = c.Filter(clientstats.ParseFilter(r.URL.Query()))
+		res := s.withDeviceInfo(logger, c)
+		res.OrderBy(r.URL.Query().Get("order_by"))
 		data, err := json.Marshal(&res)
 		if err != nil {
 			logger.Info().Err(err).Msg("")
@@ -291,6 +251,55 @@ func (s *Server) StatsV1() AppHandler {
 	}
 }
 
+// withDeviceInfo returns the stats with the manufacturers looked up from
+// the hardware addresses, the first seen times from the inventory and the
+// quota consumption.
+func (s *Server) withDeviceInfo(logger *zerolog.Logger, c clientstats.Stats) clientstats.Stats {
+	res := make(clientstats.Stats, 0, len(c))
+	for _, stat := range c {
+		v, err := s.OUILookup(stat.HWAddr)
+		if err != nil {
+			logger.Warn().Err(err).Msg("lookup error")
+		} else {
+			stat.Manufacturer = v
+		}
+		if stat.Manufacturer == "" {
+			hwa, err := net.ParseMAC(stat.HWAddr)
+			if err != nil {
+				logger.Error().Err(err).Msg("error parsing hardware addr")
+			} else {
+				switch {
+				case (hwa[0] & 1) > 0:
+					stat.Manufacturer = "{multicast}"
+				case (hwa[0] & 2) > 0:
+					stat.Manufacturer = "{local/random}"
+				}
+			}
+		}
+		if s.Devices != nil {
+			if d, ok := s.Devices.Device(stat.HWAddr); ok && d.FirstSeen.Before(stat.FirstSeen) {
+				stat.FirstSeen = d.FirstSeen
+			}
+		}
+		if s.Quotas != nil {
+			stat.Quota = s.Quotas.Stat(stat)
+		}
+		res = append(res, stat)
+	}
+	return res
+}
+
+// ResetStatsV1 starts a new period for the per client traffic totals.
+func (s *Server) ResetStatsV1() AppHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logger := log.FromRequest(r)
+		s.MonClients.ResetPeriod()
+		logger.Info().Msg("traffic totals reset")
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
+
 // NmapV0 runs a predefined nmap qiery against a single IP address.
 func (s *Server) NmapV0() AppHandler {
 	return func(w http.ResponseWriter, r *http.Request) error {
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/some-programs/natbwmon/internal/clientstats"
+	"github.com/some-programs/natbwmon/internal/log"
+)
+
+// statsStream caches the stats of the latest accounting update so that the
+// manufacturer lookups and the JSON encoding are done once per update and
+// query regardless of the number of viewers.
+type statsStream struct {
+	mu     sync.Mutex
+	tick   <-chan struct{} // the Clients.Updated channel the stats were read for
+	stats  clientstats.Stats
+	frames map[string][]byte // encoded stats by query
+}
+
+// refreshStream reads the stats if tick is not the channel the cached stats
+// were read for, s.stream.mu must be held.
+func (s *Server) refreshStream(logger *zerolog.Logger, tick <-chan struct{}) {
+	st := &s.stream
+	if st.tick != tick || st.frames == nil {
+		st.tick = tick
+		st.stats = s.withDeviceInfo(logger, s.MonClients.Stats())
+		st.frames = make(map[string][]byte)
+	}
+}
+
+// streamStats returns the stats of all clients. tick is the channel returned
+// by Clients.Updated before the stats are read, the cached stats are replaced
+// when it changes. The returned stats must not be modified.
+func (s *Server) streamStats(logger *zerolog.Logger, tick <-chan struct{}) clientstats.Stats {
+	s.stream.mu.Lock()
+	defer s.stream.mu.Unlock()
+	s.refreshStream(logger, tick)
+	return s.stream.stats
+}
+
+// streamFrame returns the JSON encoded stats for the query, see streamStats.
+func (s *Server) streamFrame(logger *zerolog.Logger, tick <-chan struct{}, q url.Values) ([]byte, error) {
+	st := &s.stream
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s.refreshStream(logger, tick)
+	key := url.Values{
+		"interface": q["interface"],
+		"ip":        q["ip"],
+		"hwaddr":    q["hwaddr"],
+		"name":      q["name"],
+		"group":     q["group"],
+		"order_by":  q["order_by"],
+	}.Encode()
+	if data, ok := st.frames[key]; ok {
+		return data, nil
+	}
+	res := st.stats.Filter(clientstats.ParseFilter(q))
+	res.OrderBy(q.Get("order_by"))
+	data, err := json.Marshal(&res)
+	if err != nil {
+		return nil, err
+	}
+	st.frames[key] = data
+	return data, nil
+}
+
+// StatsStreamV1 is a Server-Sent Events resource that sends the same stats as
+// StatsV1 as a data event each time the accounting counters have been read.
+// It accepts the same filter and order_by parameters as StatsV1.
+func (s *Server) StatsStreamV1() AppHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logger := log.FromRequest(r)
+		q := r.URL.Query()
+		rc := http.NewResponseController(w)
+		// the stream is kept open until the client goes away.
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			logger.Debug().Err(err).Msg("could not clear write deadline")
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+
+		tick := s.MonClients.Updated()
+		for {
+			data, err := s.streamFrame(logger, tick, q)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return nil
+			}
+			if err := rc.Flush(); err != nil {
+				return err
+			}
+			select {
+			case <-tick:
+				tick = s.MonClients.Updated()
+			case <-r.Context().Done():
+				return nil
+			}
+		}
+	}
+}
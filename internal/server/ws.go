@@ -0,0 +1,212 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/some-programs/natbwmon/internal/log"
+)
+
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsFlowInterval = time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// wsRequest is a message sent by a websocket client.
+type wsRequest struct {
+	Type   string   `json:"type"` // subscribe or unsubscribe
+	Topics []string `json:"topics"`
+}
+
+// wsMessage is a message sent to a websocket client.
+type wsMessage struct {
+	Topic string `json:"topic"`
+	Data  any    `json:"data"`
+}
+
+// wsTopics is the set of topics a websocket client is subscribed to.
+type wsTopics map[string]bool
+
+// clientIPs returns the addresses of the subscribed topics with the prefix.
+func (t wsTopics) clientIPs(prefix string) []string {
+	var ips []string
+	for topic := range t {
+		if ip, ok := strings.CutPrefix(topic, prefix); ok {
+			ips = append(ips, ip)
+		}
+	}
+	slices.Sort(ips)
+	return ips
+}
+
+// validTopic returns an error if topic is not one of the supported topics.
+func validTopic(topic string) error {
+	switch topic {
+	case "clients", "events":
+		return nil
+	}
+	for _, prefix := range []string{"client:", "flows:"} {
+		if ip, ok := strings.CutPrefix(topic, prefix); ok {
+			if net.ParseIP(ip) == nil {
+				return fmt.Errorf("invalid ip address in topic: %s", topic)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown topic: %s", topic)
+}
+
+// WebSocketV1 is a websocket resource that pushes the topics the client has
+// subscribed to. Subscriptions are changed at any time by sending
+//
+//	{"type": "subscribe", "topics": ["clients", "client:192.168.0.10"]}
+//	{"type": "unsubscribe", "topics": ["client:192.168.0.10"]}
+//
+// and each message sent to the client is {"topic": topic, "data": data}.
+//
+// The topics are:
+//
+//	clients      the stats of all clients after each accounting update
+//	client:IP    the stats of the client with the address after each update
+//	flows:IP     the conntrack flows of the address every second
+//	events       client join/leave and device events as they happen
+//
+// Invalid requests are answered with a message with the topic error.
+func (s *Server) WebSocketV1() AppHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		logger := log.FromRequest(r)
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// the upgrader has already responded with an error.
+			logger.Debug().Err(err).Msg("websocket upgrade failed")
+			return nil
+		}
+		defer conn.Close()
+
+		requests := make(chan wsRequest)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				var req wsRequest
+				if err := conn.ReadJSON(&req); err != nil {
+					return
+				}
+				select {
+				case requests <- req:
+				case <-r.Context().Done():
+					return
+				}
+			}
+		}()
+
+		send := func(topic string, data any) error {
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			return conn.WriteJSON(&wsMessage{Topic: topic, Data: data})
+		}
+		sendFlows := func(ips []string) error {
+			if len(ips) == 0 {
+				return nil
+			}
+			fs, err := s.Flows.Flows()
+			if err != nil {
+				return send("error", err.Error())
+			}
+			for _, ip := range ips {
+				if err := send("flows:"+ip, fs.FilterByIP(net.ParseIP(ip))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		evs, cancel := s.Events.Subscribe(64)
+		defer cancel()
+		topics := make(wsTopics)
+		tick := s.MonClients.Updated()
+		flowTicker := time.NewTicker(wsFlowInterval)
+		defer flowTicker.Stop()
+
+		for {
+			var err error
+			select {
+			case req := <-requests:
+				added, rerr := topics.apply(req)
+				if rerr != nil {
+					err = send("error", rerr.Error())
+					break
+				}
+				// new flow subscriptions do not wait for the next interval.
+				err = sendFlows(added.clientIPs("flows:"))
+
+			case <-tick:
+				tick = s.MonClients.Updated()
+				ips := topics.clientIPs("client:")
+				if !topics["clients"] && len(ips) == 0 {
+					break
+				}
+				stats := s.streamStats(logger, tick)
+				if topics["clients"] {
+					if err = send("clients", stats); err != nil {
+						break
+					}
+				}
+				for _, ip := range ips {
+					if err = send("client:"+ip, findStat(stats, ip)); err != nil {
+						break
+					}
+				}
+
+			case <-flowTicker.C:
+				err = sendFlows(topics.clientIPs("flows:"))
+
+			case e := <-evs:
+				if topics["events"] {
+					err = send("events", e)
+				}
+
+			case <-done:
+				return nil
+			}
+			if err != nil {
+				logger.Debug().Err(err).Msg("websocket write failed")
+				return nil
+			}
+		}
+	}
+}
+
+// apply applies the subscription request and returns the topics that were
+// added. Nothing is changed if the request is invalid.
+func (t wsTopics) apply(req wsRequest) (wsTopics, error) {
+	if req.Type != "subscribe" && req.Type != "unsubscribe" {
+		return nil, fmt.Errorf("unknown request type: %s", req.Type)
+	}
+	for _, topic := range req.Topics {
+		if err := validTopic(topic); err != nil {
+			return nil, err
+		}
+	}
+	added := make(wsTopics)
+	for _, topic := range req.Topics {
+		if req.Type == "unsubscribe" {
+			delete(t, topic)
+			continue
+		}
+		if !t[topic] {
+			t[topic] = true
+			added[topic] = true
+		}
+	}
+	return added, nil
+}
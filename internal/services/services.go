@@ -0,0 +1,130 @@
+// Package services names the well known service ports and breaks down the
+// connections of a client by service.
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/some-programs/natbwmon/internal/mon"
+)
+
+// DB is a table of service names by protocol and port.
+type DB struct {
+	names map[key]string
+}
+
+type key struct {
+	proto string
+	port  int
+}
+
+// NewDB reads a services table in the format of /etc/services.
+func NewDB(data []byte) (*DB, error) {
+	db := &DB{names: make(map[key]string)}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("services line %d: missing port", n)
+		}
+		port, proto, ok := strings.Cut(fields[1], "/")
+		if !ok {
+			return nil, fmt.Errorf("services line %d: missing protocol: %s", n, fields[1])
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("services line %d: invalid port: %s", n, port)
+		}
+		db.names[key{proto: proto, port: p}] = fields[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Lookup returns the name of the service on the port or an empty string if
+// it is unknown.
+func (db *DB) Lookup(proto string, port int) string {
+	return db.names[key{proto: proto, port: port}]
+}
+
+// Usage is the traffic of a client to a service.
+type Usage struct {
+	Service  string `json:"service"` // empty if the port is not in the table
+	Proto    string `json:"proto"`
+	Port     int    `json:"port"` // 0 for protocols without ports and other unknown ports
+	Flows    int    `json:"flows"`
+	InBytes  uint64 `json:"in_bytes"`  // to the client
+	OutBytes uint64 `json:"out_bytes"` // from the client
+}
+
+// TotalBytes returns the sum of the in and out bytes.
+func (u Usage) TotalBytes() uint64 {
+	return u.InBytes + u.OutBytes
+}
+
+// Breakdown groups the flows of the client with the addresses by protocol and
+// service port, the destination port of the connection. Unknown ports above
+// 1023 are grouped together as port 0. The result is ordered by total bytes.
+func (db *DB) Breakdown(fs mon.FlowSlice, addrs []net.IP) []Usage {
+	byKey := make(map[key]*Usage)
+	for _, f := range fs {
+		var out bool
+		switch {
+		case containsIP(addrs, f.Orig.Source):
+			out = true
+		case containsIP(addrs, f.Orig.Destination), containsIP(addrs, f.Reply.Source):
+		default:
+			continue
+		}
+		k := key{proto: f.Proto, port: f.Orig.DPort}
+		name := db.Lookup(k.proto, k.port)
+		if name == "" && k.port > 1023 {
+			k.port = 0
+		}
+		u, ok := byKey[k]
+		if !ok {
+			u = &Usage{Service: name, Proto: k.proto, Port: k.port}
+			byKey[k] = u
+		}
+		u.Flows++
+		if out {
+			u.OutBytes += f.Orig.Bytes
+			u.InBytes += f.Reply.Bytes
+		} else {
+			u.InBytes += f.Orig.Bytes
+			u.OutBytes += f.Reply.Bytes
+		}
+	}
+	res := make([]Usage, 0, len(byKey))
+	for _, u := range byKey {
+		res = append(res, *u)
+	}
+	slices.SortFunc(res, func(a, b Usage) int {
+		switch {
+		case a.TotalBytes() > b.TotalBytes():
+			return -1
+		case a.TotalBytes() < b.TotalBytes():
+			return 1
+		case a.Proto != b.Proto:
+			return strings.Compare(a.Proto, b.Proto)
+		}
+		return a.Port - b.Port
+	})
+	return res
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	return slices.ContainsFunc(ips, ip.Equal)
+}
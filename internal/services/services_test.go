@@ -0,0 +1,62 @@
+package services
+
+import (
+	"net"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/some-programs/natbwmon/assets"
+	"github.com/some-programs/natbwmon/internal/mon"
+)
+
+func TestNewDB(t *testing.T) {
+	is := is.New(t)
+	db, err := NewDB(assets.ServicesTxt)
+	is.NoErr(err)
+	is.Equal("https", db.Lookup("tcp", 443))
+	is.Equal("quic", db.Lookup("udp", 443))
+	is.Equal("", db.Lookup("tcp", 12345))
+
+	_, err = NewDB([]byte("http 80\n"))
+	is.True(err != nil)
+}
+
+func TestBreakdown(t *testing.T) {
+	is := is.New(t)
+	db, err := NewDB([]byte("https 443/tcp\ndns 53/udp\n"))
+	is.NoErr(err)
+	client := net.ParseIP("192.168.0.2")
+	wan := net.ParseIP("10.0.0.1")
+	out := func(proto, dst string, dport int, sent, received uint64) mon.Flow {
+		return mon.Flow{
+			Proto: proto,
+			Orig:  mon.Subflow{Source: client, Destination: net.ParseIP(dst), SPort: 40000, DPort: dport, Bytes: sent},
+			Reply: mon.Subflow{Source: net.ParseIP(dst), Destination: wan, SPort: dport, DPort: 40000, Bytes: received},
+		}
+	}
+	fs := mon.FlowSlice{
+		out("tcp", "1.1.1.1", 443, 100, 1000),
+		out("tcp", "8.8.8.8", 443, 50, 500),
+		out("udp", "1.1.1.1", 53, 10, 20),
+		out("udp", "9.9.9.9", 50000, 1, 1),
+		out("udp", "9.9.9.9", 50001, 1, 1),
+		out("tcp", "9.9.9.9", 22, 5, 5),
+		// a forwarded port
+		{
+			Proto: "tcp",
+			Orig:  mon.Subflow{Source: net.ParseIP("2.2.2.2"), Destination: wan, SPort: 50000, DPort: 8080, Bytes: 7},
+			Reply: mon.Subflow{Source: client, Destination: net.ParseIP("2.2.2.2"), SPort: 8080, DPort: 50000, Bytes: 70},
+		},
+		// another client
+		out("tcp", "1.1.1.1", 443, 1, 1),
+	}
+	fs[len(fs)-1].Orig.Source = net.ParseIP("192.168.0.3")
+
+	res := db.Breakdown(fs, []net.IP{client})
+	is.Equal(5, len(res))
+	is.Equal(Usage{Service: "https", Proto: "tcp", Port: 443, Flows: 2, InBytes: 1500, OutBytes: 150}, res[0])
+	is.Equal(Usage{Service: "", Proto: "tcp", Port: 0, Flows: 1, InBytes: 7, OutBytes: 70}, res[1])
+	is.Equal(Usage{Service: "dns", Proto: "udp", Port: 53, Flows: 1, InBytes: 20, OutBytes: 10}, res[2])
+	is.Equal(Usage{Service: "", Proto: "tcp", Port: 22, Flows: 1, InBytes: 5, OutBytes: 5}, res[3])
+	is.Equal(Usage{Service: "", Proto: "udp", Port: 0, Flows: 2, InBytes: 2, OutBytes: 2}, res[4])
+}
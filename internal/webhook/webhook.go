@@ -0,0 +1,139 @@
+// Package webhook delivers events to HTTP endpoints.
+//
+// Each event is POSTed as a JSON encoded events.Event, other JSON payloads can
+// be delivered with Deliver. Failed deliveries are retried with an
+// exponentially increasing delay. Every endpoint has its own queue so that a
+// slow or unreachable endpoint does not delay the others.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/some-programs/natbwmon/internal/events"
+	"github.com/some-programs/natbwmon/internal/log"
+)
+
+// queueSize is the number of events that can wait for delivery to an
+// endpoint, later events are dropped.
+const queueSize = 64
+
+// Sender delivers events to webhook endpoints.
+type Sender struct {
+	urls    []string
+	types   []events.Type
+	retries int
+	backoff time.Duration
+	client  *http.Client
+}
+
+// New returns a Sender that delivers the events of the types, or all events if
+// types is empty, to the urls. A failed delivery is retried at most retries
+// times, first after backoff and then after twice the previous delay.
+func New(urls []string, types []events.Type, retries int, backoff time.Duration) *Sender {
+	return &Sender{
+		urls:    urls,
+		types:   types,
+		retries: retries,
+		backoff: backoff,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Run delivers the events published to bus until ctx is cancelled.
+func (s *Sender) Run(ctx context.Context, bus *events.Bus) {
+	evs, cancel := bus.Subscribe(queueSize)
+	defer cancel()
+
+	queues := make([]chan events.Event, len(s.urls))
+	for i, url := range s.urls {
+		queues[i] = make(chan events.Event, queueSize)
+		go s.deliverAll(ctx, url, queues[i])
+	}
+	for {
+		select {
+		case e := <-evs:
+			if len(s.types) > 0 && !slices.Contains(s.types, e.Type) {
+				continue
+			}
+			for i, q := range queues {
+				select {
+				case q <- e:
+				default:
+					log.Warn().Str("url", s.urls[i]).Str("type", string(e.Type)).Msg("webhook queue full, dropping event")
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// deliverAll delivers the events from queue to url until ctx is cancelled.
+func (s *Sender) deliverAll(ctx context.Context, url string, queue <-chan events.Event) {
+	for {
+		select {
+		case e := <-queue:
+			if err := s.Deliver(ctx, url, e); err != nil && ctx.Err() == nil {
+				log.Warn().Err(err).Str("url", url).Str("type", string(e.Type)).Msg("webhook delivery failed")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Deliver posts v encoded as JSON to url, retrying failed attempts.
+func (s *Sender) Deliver(ctx context.Context, url string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	delay := s.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(ctx, url, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= s.retries {
+			return err
+		}
+		log.Debug().Err(err).Str("url", url).Dur("delay", delay).Msg("retrying webhook")
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// post makes a single delivery attempt. retry is false if the endpoint
+// rejected the event and trying again would not help.
+func (s *Sender) post(ctx context.Context, url string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "natbwmon")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("%s: %s", url, resp.Status)
+	retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusRequestTimeout
+	return retry, err
+}
@@ -0,0 +1,103 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/some-programs/natbwmon/internal/clientstats"
+	"github.com/some-programs/natbwmon/internal/events"
+)
+
+func TestDeliverRetries(t *testing.T) {
+	is := is.New(t)
+	var attempts atomic.Int32
+	received := make(chan events.Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		is.Equal(r.Header.Get("Content-Type"), "application/json")
+		var e events.Event
+		is.NoErr(json.NewDecoder(r.Body).Decode(&e))
+		received <- e
+	}))
+	defer srv.Close()
+
+	s := New([]string{srv.URL}, nil, 3, time.Millisecond)
+	e := events.Event{
+		Type:   events.DeviceNew,
+		Time:   time.Now(),
+		Client: clientstats.Stat{HWAddr: "00:00:00:00:00:01", Manufacturer: "Acme"},
+	}
+	is.NoErr(s.Deliver(context.Background(), srv.URL, e))
+	is.Equal(attempts.Load(), int32(3))
+	got := <-received
+	is.Equal(got.Type, events.DeviceNew)
+	is.Equal(got.Client.Manufacturer, "Acme")
+}
+
+func TestDeliverGivesUp(t *testing.T) {
+	is := is.New(t)
+	var attempts atomic.Int32
+	var status atomic.Int32
+	status.Store(http.StatusInternalServerError)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer srv.Close()
+
+	s := New([]string{srv.URL}, nil, 2, time.Millisecond)
+	is.True(s.Deliver(context.Background(), srv.URL, events.Event{}) != nil)
+	is.Equal(attempts.Load(), int32(3))
+
+	// client errors are not retried
+	attempts.Store(0)
+	status.Store(http.StatusBadRequest)
+	is.True(s.Deliver(context.Background(), srv.URL, events.Event{}) != nil)
+	is.Equal(attempts.Load(), int32(1))
+}
+
+func TestRun(t *testing.T) {
+	is := is.New(t)
+	received := make(chan events.Type, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e events.Event
+		is.NoErr(json.NewDecoder(r.Body).Decode(&e))
+		received <- e.Type
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := events.NewBus()
+	s := New([]string{srv.URL}, []events.Type{events.DeviceNew, events.DeviceOffline}, 0, time.Millisecond)
+	go s.Run(ctx, bus)
+
+	// wait for the sender to subscribe
+	for {
+		bus.Publish(events.Event{Type: events.DeviceNew})
+		select {
+		case typ := <-received:
+			is.Equal(typ, events.DeviceNew)
+		case <-time.After(10 * time.Millisecond):
+			continue
+		}
+		break
+	}
+	bus.Publish(events.Event{Type: events.Join})
+	bus.Publish(events.Event{Type: events.DeviceOffline})
+	for typ := range received {
+		if typ == events.DeviceOffline {
+			break
+		}
+		is.Equal(typ, events.DeviceNew) // only filtered event types are delivered
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"mime"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -17,17 +18,33 @@ import (
 	"github.com/go-pa/flagutil"
 	"github.com/peterbourgon/ff/v3"
 	"github.com/some-programs/natbwmon/assets"
+	"github.com/some-programs/natbwmon/internal/alert"
 	"github.com/some-programs/natbwmon/internal/arp"
+	"github.com/some-programs/natbwmon/internal/events"
+	"github.com/some-programs/natbwmon/internal/geoip"
+	"github.com/some-programs/natbwmon/internal/history"
+	"github.com/some-programs/natbwmon/internal/inventory"
 	"github.com/some-programs/natbwmon/internal/log"
+	"github.com/some-programs/natbwmon/internal/metrics"
 	"github.com/some-programs/natbwmon/internal/mon"
+	"github.com/some-programs/natbwmon/internal/neigh"
 	"github.com/some-programs/natbwmon/internal/oui"
+	"github.com/some-programs/natbwmon/internal/quota"
 	"github.com/some-programs/natbwmon/internal/server"
+	"github.com/some-programs/natbwmon/internal/services"
+	"github.com/some-programs/natbwmon/internal/webhook"
 )
 
 // Flags contains the top level program configuration.
 type Flags struct {
+	backend                  string
 	chain                    string
-	LANIface                 string
+	nftTable                 string
+	LANIfaces                flagutil.StringSliceFlag
+	LANCIDRs                 flagutil.StringSliceFlag
+	lan                      mon.LAN
+	neighSource              string
+	ipv6                     bool
 	listen                   string
 	clear                    bool
 	avgSamples               int
@@ -35,37 +52,285 @@ type Flags struct {
 	iptablesRulesInterval    time.Duration
 	arpInterval              time.Duration
 	resolveHostnamesInterval time.Duration
+	dnsCacheTTL              time.Duration
+	geoipDBs                 flagutil.StringSliceFlag
+	conntrackResyncInterval  time.Duration
+	matrixInterval           time.Duration
+	matrixWindow             time.Duration
+	matrixMax                int
+	clientExpire             time.Duration
+	historyDir               string
+	inventoryFile            string
+	inventoryInterval        time.Duration
+	offlineAfter             time.Duration
+	webhookURLs              flagutil.StringSliceFlag
+	webhookEvents            flagutil.StringSliceFlag
+	webhookRetries           int
+	alertRules               string
+	alertWebhooks            flagutil.StringSliceFlag
+	alertSyslog              string
+	alertSMTPAddr            string
+	alertSMTPFrom            string
+	alertSMTPTo              flagutil.StringSliceFlag
+	quotas                   flagutil.StringSliceFlag
+	quotaFile                string
+	historyTiers             string
+	historyMaxMB             int64
 	aliases                  flagutil.StringSliceFlag
+	groups                   flagutil.StringSliceFlag
 	nmap                     bool
 	log                      log.Flags
 }
 
 // Register registers the flags into a FlagSet.
 func (flags *Flags) Register(fs *flag.FlagSet) {
-	fs.BoolVar(&flags.clear, "clear", false, "just clear iptables rules and chains and exit")
-	fs.StringVar(&flags.LANIface, "lan.if", "br0", "The 'LAN' interface")
+	fs.BoolVar(&flags.clear, "clear", false, "just clear accounting rules and chains and exit")
+	flags.LANIfaces = flagutil.StringSliceFlag{"br0"}
+	fs.Var(&flags.LANIfaces, "lan.if", "The 'LAN' interfaces comma separated. ex: -lan.if=br0,br2,br3")
+	fs.Var(&flags.LANCIDRs, "lan.cidr", "LAN networks comma separated, every address within them is accounted as a client when it is first seen in a connection. ex: -lan.cidr=192.168.2.0/24,10.1.0.0/16")
+	fs.StringVar(&flags.neighSource, "neigh.source", "netlink", "where neighbors are read from: netlink or proc (/proc/net/arp and ip -6 neigh)")
+	fs.BoolVar(&flags.ipv6, "ipv6", true, "also track IPv6 traffic")
 	fs.StringVar(&flags.listen, "listen", "0.0.0.0:8833", "where web server listens")
+	fs.StringVar(&flags.backend, "backend", "iptables", "accounting backend: iptables, ipset (iptables with hashed sets for large networks), nft or conntrack (no firewall rules)")
 	fs.StringVar(&flags.chain, "iptables.chain", "NATBW", "name of iptables chain to create")
+	fs.StringVar(&flags.nftTable, "nft.table", "natbwmon", "name of nftables table to create")
 	fs.IntVar(&flags.avgSamples, "avg.samples", 8, "number of samples to create bitrate averages from")
 	fs.DurationVar(&flags.iptablesReadInterval, "iptables.read.delay", 400*time.Millisecond, "delay between reading counters from iptables rules")
 	fs.DurationVar(&flags.iptablesRulesInterval, "iptables.rules.delay", 10*time.Second, "delay between updating ip tables rules and adding new new clients")
 	fs.DurationVar(&flags.arpInterval, "arp.delay", 5*time.Second, "delay between rereading arp table to update client hardware addresses")
 	fs.DurationVar(&flags.resolveHostnamesInterval, "dns.delay", time.Minute, "delay between reresolving host names.")
+	fs.DurationVar(&flags.dnsCacheTTL, "dns.cache.ttl", time.Hour, "how long reverse DNS names of remote hosts are cached, 0 disables resolving remote hosts")
+	fs.DurationVar(&flags.conntrackResyncInterval, "conntrack.resync", 30*time.Second, "delay between full conntrack table reads, the table is otherwise kept up to date by conntrack events")
+	fs.DurationVar(&flags.matrixInterval, "matrix.delay", 10*time.Second, "delay between updating the per remote network traffic of clients from conntrack, 0 disables it")
+	fs.DurationVar(&flags.matrixWindow, "matrix.window", 24*time.Hour, "length of the rolling window of the per remote network traffic of clients")
+	fs.IntVar(&flags.matrixMax, "matrix.max", 10000, "maximum number of client, remote network and protocol combinations to keep, those with the least traffic are evicted first")
+	fs.DurationVar(&flags.clientExpire, "client.expire", 0, "remove clients that have had no traffic and no neighbor entry for this long, 0 disables expiry")
+	fs.StringVar(&flags.inventoryFile, "inventory.file", "", "file to keep the inventory of every device seen on the network in, the inventory is only kept in memory if empty")
+	fs.DurationVar(&flags.inventoryInterval, "inventory.delay", 30*time.Second, "delay between updating and saving the device inventory, 0 disables updating it")
+	fs.DurationVar(&flags.offlineAfter, "inventory.offline", 5*time.Minute, "a device is offline when it has not been seen for this long")
+	fs.Var(&flags.webhookURLs, "webhook.url", "URLs to POST device events to as JSON comma separated. ex: -webhook.url=http://192.168.0.2:8080/natbwmon")
+	flags.webhookEvents = flagutil.StringSliceFlag{string(events.DeviceNew), string(events.DeviceOnline), string(events.DeviceOffline), string(events.DeviceIPChanged)}
+	fs.Var(&flags.webhookEvents, "webhook.events", "event types sent to the webhooks comma separated: device.new, device.online, device.offline, device.ip_changed, join or leave")
+	fs.IntVar(&flags.webhookRetries, "webhook.retries", 5, "number of times a failed webhook delivery is retried with an increasing delay")
+	fs.StringVar(&flags.alertRules, "alert.rules", "", "file with bandwidth alert rules, one per line as name: [selector=value ...] metric op threshold [for duration] [between HH:MM-HH:MM]. ex: download: in_rate > 50MiB/s for 2m")
+	fs.Var(&flags.alertWebhooks, "alert.webhook", "URLs to POST firing and resolved alerts to as JSON comma separated")
+	fs.StringVar(&flags.alertSyslog, "alert.syslog", "", "send alerts to syslog: local or network://host:port, ex: udp://192.168.0.2:514")
+	fs.StringVar(&flags.alertSMTPAddr, "alert.smtp.addr", "", "SMTP server host:port to mail alerts through")
+	fs.StringVar(&flags.alertSMTPFrom, "alert.smtp.from", "natbwmon@localhost", "sender address of alert mails")
+	fs.Var(&flags.alertSMTPTo, "alert.smtp.to", "recipients of alert mails comma separated")
+	fs.Var(&flags.quotas, "quota", "data quotas comma separated as SUBJECT=LIMIT/PERIOD[:ACTION] where SUBJECT is a hardware address or group:NAME, PERIOD is day or month and the optional ACTION drop or a rate limit enforced while the quota is exceeded. ex: -quota=group:kids=2GiB/day:drop,00:11:22:33:44:55=20GiB/month:256KiB/s")
+	fs.StringVar(&flags.quotaFile, "quota.file", "", "file to keep the quota consumption in across restarts, it is only kept in memory if empty")
+	fs.StringVar(&flags.historyDir, "history.dir", "", "directory to store the usage history in, history is disabled if empty")
+	fs.StringVar(&flags.historyTiers, "history.tiers", "10s:24h,5m:720h,1h:8760h", "history resolutions and how long they are kept as step:retention comma separated")
+	fs.Int64Var(&flags.historyMaxMB, "history.max-mb", 1024, "maximum size of the usage history in MiB, the oldest high resolution data is removed first. 0 means no limit")
 	fs.Var(&flags.aliases, "aliases", "hardware address aliases comma separated. ex: -aliases=00:00:00:00:00:00=nas.alias,00:00:00:00:00:01=server.alias")
+	fs.Var(&flags.groups, "groups", "hardware address groups comma separated. ex: -groups=00:00:00:00:00:00=kids,00:00:00:00:00:01=servers")
+	fs.Var(&flags.geoipDBs, "geoip.db", "MaxMind format (mmdb) country and ASN databases comma separated, reloaded on SIGHUP. ex: -geoip.db=GeoLite2-Country.mmdb,GeoLite2-ASN.mmdb")
 	fs.BoolVar(&flags.nmap, "nmap", false, "enable nmap api")
 	flags.log.Register(fs)
 }
 
+// NewAccounting returns the configured accounting backend. The conntrack
+// backend dumps the conntrack table on every read and gets the final counters
+// of the flows that end between reads from flows.
+func (flags *Flags) NewAccounting(flows *mon.FlowTracker) (mon.Accounting, error) {
+	switch flags.backend {
+	case "iptables":
+		return mon.NewIPTables(flags.chain, flags.lan, flags.ipv6)
+	case "ipset":
+		return mon.NewIPSet(flags.chain, flags.lan, flags.ipv6)
+	case "nft":
+		return mon.NewNFTables(flags.nftTable, flags.lan, flags.ipv6)
+	case "conntrack":
+		return mon.NewConntrackAccounting(mon.Flows, flows.Ended, flags.lan, flags.ipv6)
+	default:
+		return nil, fmt.Errorf("unknown accounting backend: %s", flags.backend)
+	}
+}
+
 // Setup must be run after the flags are parsed.
 func (flags *Flags) Setup(out io.Writer) error {
 	if err := flags.log.Setup(); err != nil {
 		fmt.Fprintln(out, err)
 		return err
 	}
+	lan, err := mon.ParseLAN(flags.LANIfaces, flags.LANCIDRs)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return err
+	}
+	flags.lan = lan
 	return nil
 }
 
+// OpenHistory opens the usage history store, nil is returned if the history
+// is disabled.
+func (flags *Flags) OpenHistory() (*history.Store, error) {
+	if flags.historyDir == "" {
+		return nil, nil
+	}
+	tiers, err := history.ParseTiers(flags.historyTiers)
+	if err != nil {
+		return nil, err
+	}
+	return history.Open(flags.historyDir, tiers, flags.historyMaxMB<<20)
+}
+
+// NewAlerts returns the alert manager with the configured rules and
+// notifiers, nil is returned if there are no alert rules.
+func (flags *Flags) NewAlerts() (*alert.Manager, error) {
+	if flags.alertRules == "" {
+		return nil, nil
+	}
+	f, err := os.Open(flags.alertRules)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules, err := alert.ParseRules(f)
+	if err != nil {
+		return nil, err
+	}
+	var notifiers []alert.Notifier
+	if len(flags.alertWebhooks) > 0 {
+		notifiers = append(notifiers, alert.NewWebhook(flags.alertWebhooks, flags.webhookRetries, time.Second))
+	}
+	if flags.alertSyslog != "" {
+		s, err := alert.NewSyslog(flags.alertSyslog)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, s)
+	}
+	if flags.alertSMTPAddr != "" {
+		if len(flags.alertSMTPTo) == 0 {
+			return nil, fmt.Errorf("alert.smtp.to is required with alert.smtp.addr")
+		}
+		notifiers = append(notifiers, &alert.SMTP{
+			Addr: flags.alertSMTPAddr,
+			From: flags.alertSMTPFrom,
+			To:   flags.alertSMTPTo,
+		})
+	}
+	return alert.NewManager(rules, notifiers...), nil
+}
+
+// NewQuotas returns the quota manager with the configured quotas, nil is
+// returned if there are no quotas. Exceeded quotas with an action are
+// enforced by acc.
+func (flags *Flags) NewQuotas(acc mon.Accounting) (*quota.Manager, error) {
+	if len(flags.quotas) == 0 {
+		return nil, nil
+	}
+	var quotas []quota.Quota
+	enforce := false
+	for _, v := range flags.quotas {
+		q, err := quota.Parse(v)
+		if err != nil {
+			return nil, err
+		}
+		enforce = enforce || q.Enforce
+		quotas = append(quotas, q)
+	}
+	var enforcer mon.Enforcer
+	if enforce {
+		var ok bool
+		if enforcer, ok = acc.(mon.Enforcer); !ok {
+			return nil, fmt.Errorf("the %s backend can not enforce quotas, use iptables or ipset", flags.backend)
+		}
+	}
+	return quota.NewManager(quotas, flags.quotaFile, enforcer)
+}
+
+// WebhookTypes returns the event types that are sent to the webhooks.
+func (flags *Flags) WebhookTypes() ([]events.Type, error) {
+	types := make([]events.Type, 0, len(flags.webhookEvents))
+	for _, v := range flags.webhookEvents {
+		t, err := events.ParseType(v)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+// Neighbors returns the neighbor table entries from the configured source.
+func (flags *Flags) Neighbors() (arp.Entries, error) {
+	switch flags.neighSource {
+	case "netlink":
+		es, err := neigh.Get()
+		if err != nil {
+			return nil, err
+		}
+		arps := make(arp.Entries, 0, len(es))
+		for _, e := range es {
+			if flags.trackNeighbor(e) {
+				arps = append(arps, e.Arp())
+			}
+		}
+		return arps, nil
+	case "proc":
+		return procNeighbors(flags.ipv6)
+	default:
+		return nil, fmt.Errorf("unknown neighbor source: %s", flags.neighSource)
+	}
+}
+
+// trackNeighbor returns true if e is a neighbor whose traffic can be tracked.
+func (flags *Flags) trackNeighbor(e neigh.Entry) bool {
+	if !e.Usable() {
+		return false
+	}
+	ip := net.ParseIP(e.IPAddress)
+	if ip.To4() != nil {
+		return true
+	}
+	return flags.ipv6 && ip.IsGlobalUnicast()
+}
+
+// procNeighbors returns the ARP table entries and optionally the IPv6
+// neighbors.
+//
+// Failing to read the IPv6 neighbors is logged but is not an error.
+func procNeighbors(ipv6 bool) (arp.Entries, error) {
+	arps, err := arp.Get()
+	if err != nil {
+		return nil, err
+	}
+	if ipv6 {
+		arps6, err := arp.GetIPv6()
+		if err != nil {
+			log.Warn().Err(err).Msg("could not read ipv6 neighbors")
+			return arps, nil
+		}
+		arps = append(arps, arps6...)
+	}
+	return arps, nil
+}
+
+// parseHWAddrMap parses hwaddr=value pairs.
+func parseHWAddrMap(vs []string) (map[string]string, error) {
+	m := make(map[string]string, len(vs))
+	for _, v := range vs {
+		ss := strings.SplitN(v, "=", 2)
+		if len(ss) != 2 {
+			return nil, fmt.Errorf("%s", v)
+		}
+		m[ss[0]] = ss[1]
+	}
+	return m, nil
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		if err := reportCommand(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var flags Flags
 	flags.Register(flag.CommandLine)
 	ff.Parse(flag.CommandLine, os.Args[1:],
@@ -89,17 +354,15 @@ func main() {
 		}
 	}(ctx)
 
-	hostAliases := make(map[string]string, 0)
-	for _, v := range flags.aliases {
-		ss := strings.SplitN(v, "=", 2)
-		if len(ss) != 2 {
-			fmt.Println("invalid alias specification:", v)
-			os.Exit(1)
-		}
-		hostAliases[ss[0]] = ss[1]
+	hostAliases, err := parseHWAddrMap(flags.aliases)
+	if err != nil {
+		fmt.Println("invalid alias specification:", err)
+		os.Exit(1)
 	}
 
-	ipt, err := mon.NewIPTables(flags.chain, flags.LANIface)
+	flows := mon.NewFlowTracker(flags.conntrackResyncInterval)
+
+	ipt, err := flags.NewAccounting(flows)
 	if err != nil {
 		log.Fatal().Err(err).Msg("")
 	}
@@ -116,7 +379,7 @@ func main() {
 		log.Fatal().Err(err).Msg("")
 	}
 
-	arps, err := arp.Get()
+	arps, err := flags.Neighbors()
 	if err != nil {
 		log.Fatal().Err(err).Msg("")
 	}
@@ -130,24 +393,121 @@ func main() {
 		log.Fatal().Err(err).Msg("")
 	}
 
-	clients := mon.NewClients(flags.avgSamples, hostAliases)
+	servicesDB, err := services.NewDB(assets.ServicesTxt)
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	hostGroups, err := parseHWAddrMap(flags.groups)
+	if err != nil {
+		fmt.Println("invalid group specification:", err)
+		os.Exit(1)
+	}
+
+	bus := events.NewBus()
+	clients := mon.NewClients(flags.avgSamples, hostAliases, hostGroups, bus)
+
+	// health keeps track of the runs and failures of the periodic collectors.
+	health := metrics.NewHealth()
+
+	go flows.Run(ctx)
+
+	// newNeighCh is signaled when a new neighbor is seen on the LAN interface.
+	newNeighCh := make(chan struct{}, 1)
+
+	if flags.neighSource == "netlink" {
+		go func(ctx context.Context) {
+			// known is the hardware address of each tracked neighbor, entries
+			// are forgotten when they are removed or become unusable so that a
+			// device that comes back is picked up again.
+			known := make(map[string]string)
+			handle := func(e neigh.Entry, deleted bool) {
+				if deleted || !flags.trackNeighbor(e) {
+					delete(known, e.IPAddress)
+					return
+				}
+				if !flags.lan.Includes(e.Arp()) {
+					return
+				}
+				if known[e.IPAddress] == e.HWAddress {
+					return
+				}
+				known[e.IPAddress] = e.HWAddress
+				if err := clients.UpdateArp(arp.Entries{e.Arp()}); err != nil {
+					log.Info().Err(err).Msg("update arp failed")
+				}
+				select {
+				case newNeighCh <- struct{}{}:
+				default:
+				}
+			}
+			for {
+				err := neigh.Subscribe(ctx, handle)
+				if ctx.Err() != nil {
+					return
+				}
+				log.Warn().Err(err).Msg("neighbor subscription failed")
+				select {
+				case <-time.After(10 * time.Second):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(ctx)
+	}
 
 	if flags.iptablesRulesInterval > 0 {
+		// newFlowCh receives new connections so that clients within the LAN
+		// networks are added as soon as they make their first connection.
+		newFlowCh := make(chan mon.Flow, 64)
+		if len(flags.lan.Networks) > 0 {
+			flows.OnNew(func(f mon.Flow) {
+				select {
+				case newFlowCh <- f:
+				default:
+				}
+			})
+		}
 		go func(ctx context.Context) {
-			ipt, err := mon.NewIPTables(flags.chain, flags.LANIface)
+			ipt, err := flags.NewAccounting(flows)
 			if err != nil {
 				log.Fatal().Err(err).Msg("")
 			}
+			collector := health.Collector("rules")
+			// added is the addresses of the previous update.
+			added := make(map[string]bool)
+			update := func(discovered arp.Entries) {
+				arps, err := flags.Neighbors()
+				if err != nil {
+					log.Warn().Err(err).Msg("")
+				}
+				if len(flags.lan.Networks) > 0 {
+					fs, err := flows.Flows()
+					if err != nil {
+						log.Info().Err(err).Msg("client discovery failed")
+					} else {
+						arps = append(arps, flags.lan.Discover(fs)...)
+					}
+				}
+				arps = append(arps, discovered...)
+				added = make(map[string]bool, len(arps))
+				for _, a := range arps {
+					added[a.IPAddress] = true
+				}
+				if err := collector.Run(func() error { return ipt.Update(arps) }); err != nil {
+					log.Info().Err(err).Msg("")
+				}
+			}
 			ticker := time.NewTicker(flags.iptablesRulesInterval)
 			for {
 				select {
 				case <-ticker.C:
-					arps, err := arp.Get()
-					if err != nil {
-						log.Warn().Err(err).Msg("")
-					}
-					if err := ipt.Update(arps); err != nil {
-						log.Info().Err(err).Msg("")
+					update(nil)
+				case <-newNeighCh:
+					update(nil)
+				case f := <-newFlowCh:
+					if arps := flags.lan.Discover(mon.FlowSlice{f}); len(arps) > 0 && !added[arps[0].IPAddress] {
+						update(arps)
 					}
 				case <-ctx.Done():
 					return
@@ -158,13 +518,18 @@ func main() {
 
 	if flags.arpInterval > 0 {
 		go func(ctx context.Context) {
+			collector := health.Collector("neighbors")
 			update := func() {
-				arps, err := arp.Get()
+				var arps arp.Entries
+				err := collector.Run(func() (err error) {
+					arps, err = flags.Neighbors()
+					return err
+				})
 				if err != nil {
 					log.Info().Err(err).Msg("")
 					return
 				}
-				arps = arps.FilterDeviceName(flags.LANIface)
+				arps = flags.lan.Filter(arps)
 				if err := clients.UpdateArp(arps); err != nil {
 					log.Info().Err(err).Msg("update arp failed")
 				}
@@ -184,20 +549,25 @@ func main() {
 
 	if flags.resolveHostnamesInterval > 0 {
 		go func(ctx context.Context) {
+			collector := health.Collector("dns")
 			ticker := time.NewTicker(flags.resolveHostnamesInterval)
 		loop:
 			for {
 				select {
 				case <-ticker.C:
-					arps, err := arp.Get()
+					arps, err := flags.Neighbors()
 					if err != nil {
 						log.Info().Err(err).Msg("")
 						continue loop
 					}
-					arps = arps.FilterDeviceName(flags.LANIface)
+					arps = flags.lan.Filter(arps)
 					names := make(map[string]string, len(arps))
 					for _, v := range arps {
-						name, err := mon.ResolveHostname(v.IPAddress)
+						var name string
+						err := collector.Run(func() (err error) {
+							name, err = mon.ResolveHostname(v.IPAddress)
+							return err
+						})
 						if err != nil {
 							log.Info().Err(err).Msg("")
 						}
@@ -215,19 +585,167 @@ func main() {
 
 	if flags.iptablesReadInterval > 0 {
 		go func(ctx context.Context) {
+			collector := health.Collector("accounting")
 			ticker := time.NewTicker(flags.iptablesReadInterval)
-		loop:
 			for {
 				select {
 				case <-ticker.C:
-					next, err := ipt.Stats()
+					err := collector.Run(func() error {
+						next, err := ipt.Stats()
+						if err != nil {
+							return err
+						}
+						return clients.UpdateIPTables(next)
+					})
 					if err != nil {
 						log.Info().Err(err).Msg("")
-						continue loop
 					}
-					if err = clients.UpdateIPTables(next); err != nil {
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(ctx)
+	}
+
+	if flags.clientExpire > 0 {
+		go func(ctx context.Context) {
+			ticker := time.NewTicker(min(flags.clientExpire, time.Minute))
+			for {
+				select {
+				case <-ticker.C:
+					ips := clients.Idle(flags.clientExpire)
+					if len(ips) == 0 {
+						continue
+					}
+					if err := ipt.Remove(ips); err != nil {
+						log.Warn().Err(err).Msg("could not remove expired clients from accounting")
+						continue
+					}
+					clients.Expire(ips)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(ctx)
+	}
+
+	hist, err := flags.OpenHistory()
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+	if hist != nil {
+		go func(ctx context.Context) {
+			collector := health.Collector("history")
+			ticker := time.NewTicker(hist.Interval())
+			for {
+				select {
+				case t := <-ticker.C:
+					err := collector.Run(func() error { return hist.Record(t, clients.Stats()) })
+					if err != nil {
+						log.Warn().Err(err).Msg("could not record history")
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(ctx)
+	}
+
+	devices, err := inventory.Open(flags.inventoryFile, flags.offlineAfter, bus)
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+
+	if len(flags.webhookURLs) > 0 {
+		types, err := flags.WebhookTypes()
+		if err != nil {
+			log.Fatal().Err(err).Msg("")
+		}
+		go webhook.New(flags.webhookURLs, types, flags.webhookRetries, time.Second).Run(ctx, bus)
+	}
+	if flags.inventoryInterval > 0 {
+		go func(ctx context.Context) {
+			collector := health.Collector("inventory")
+			ticker := time.NewTicker(flags.inventoryInterval)
+			for {
+				select {
+				case t := <-ticker.C:
+					stats := clients.Stats()
+					for i, v := range stats {
+						stats[i].Manufacturer, _ = ouiDB.Lookup(v.HWAddr)
+					}
+					for _, d := range devices.Update(stats, t) {
+						log.Info().
+							Str("event", "new_device").
+							Str("hwaddr", d.HWAddr).
+							Str("name", d.Name).
+							Str("manufacturer", d.Manufacturer).
+							Msg("new device")
+					}
+					if err := collector.Run(devices.Save); err != nil {
+						log.Warn().Err(err).Msg("could not save inventory")
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(ctx)
+	}
+
+	alerts, err := flags.NewAlerts()
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+	if alerts != nil {
+		go alerts.Run(ctx)
+		go func(ctx context.Context) {
+			for {
+				select {
+				case <-clients.Updated():
+					alerts.Evaluate(clients.Stats(), time.Now())
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(ctx)
+	}
+
+	quotas, err := flags.NewQuotas(ipt)
+	if err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+	if quotas != nil {
+		go func(ctx context.Context) {
+			collector := health.Collector("quota")
+			ticker := time.NewTicker(time.Minute)
+			for {
+				select {
+				case <-clients.Updated():
+					if err := collector.Run(func() error { return quotas.Update(clients.Stats(), time.Now()) }); err != nil {
+						log.Warn().Err(err).Msg("could not enforce quotas")
+					}
+				case <-ticker.C:
+					if err := quotas.Save(); err != nil {
+						log.Warn().Err(err).Msg("could not save quotas")
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(ctx)
+	}
+
+	var matrix *mon.RemoteMatrix
+	if flags.matrixInterval > 0 && flags.matrixMax > 0 {
+		matrix = mon.NewRemoteMatrix(flows.Flows, flags.lan, flags.matrixWindow, flags.matrixMax)
+		go func(ctx context.Context) {
+			collector := health.Collector("matrix")
+			ticker := time.NewTicker(flags.matrixInterval)
+			for {
+				select {
+				case <-ticker.C:
+					if err := collector.Run(matrix.Update); err != nil {
 						log.Info().Err(err).Msg("")
-						continue loop
 					}
 				case <-ctx.Done():
 					return
@@ -236,13 +754,55 @@ func main() {
 		}(ctx)
 	}
 
+	var dnsCache *mon.DNSCache
+	if flags.dnsCacheTTL > 0 {
+		dnsCache = mon.NewDNSCache(flags.dnsCacheTTL, 10000)
+		go dnsCache.Run(ctx, 4)
+	}
+
+	var geoDB *geoip.DB
+	if len(flags.geoipDBs) > 0 {
+		geoDB, err = geoip.Open(flags.geoipDBs...)
+		if err != nil {
+			log.Fatal().Err(err).Msg("")
+		}
+		go func(ctx context.Context) {
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+			defer signal.Stop(hup)
+			for {
+				select {
+				case <-hup:
+					if err := geoDB.Reload(); err != nil {
+						log.Warn().Err(err).Msg("could not reload geoip databases")
+						continue
+					}
+					log.Info().Msg("geoip databases reloaded")
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(ctx)
+	}
+
 	mime.AddExtensionType(".woff", "font/woff")
 	mime.AddExtensionType(".woff2", "font/woff2")
 
 	srv := &server.Server{
 		NmapEnabled: flags.nmap,
 		OUILookup:   ouiDB.Lookup,
+		Services:    servicesDB,
+		DNS:         dnsCache,
+		GeoIP:       geoDB,
+		Matrix:      matrix,
+		Devices:     devices,
+		Alerts:      alerts,
+		Quotas:      quotas,
 		MonClients:  clients,
+		Flows:       flows,
+		Events:      bus,
+		History:     hist,
+		Health:      health,
 	}
 	hs := &http.Server{
 		Addr:           flags.listen,
@@ -258,6 +818,19 @@ func main() {
 
 	<-ctx.Done()
 	log.Info().Msg("shutting down...")
+	if hist != nil {
+		if err := hist.Close(); err != nil {
+			log.Error().Err(err).Msg("could not close history")
+		}
+	}
+	if err := devices.Save(); err != nil {
+		log.Error().Err(err).Msg("could not save inventory")
+	}
+	if quotas != nil {
+		if err := quotas.Save(); err != nil {
+			log.Error().Err(err).Msg("could not save quotas")
+		}
+	}
 	if err := ipt.Delete(); err != nil {
 		log.Fatal().Err(err).Msg("")
 	}
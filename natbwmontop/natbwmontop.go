@@ -1,17 +1,17 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
-	"time"
 
 	ui "github.com/gizak/termui/v3"
 	"github.com/gizak/termui/v3/widgets"
@@ -19,35 +19,45 @@ import (
 	"github.com/some-programs/natbwmon/internal/clientstats"
 )
 
-func readStats(ctx context.Context, baseurl string) (clientstats.Stats, error) {
-	url := fmt.Sprintf("%s/v1/stats/", baseurl)
-	ctx, cancel := context.WithTimeout(ctx, time.Second)
-	defer cancel()
+// streamStats reads the stats stream and sends each received frame to ch
+// until the stream ends or ctx is cancelled.
+func streamStats(ctx context.Context, baseurl string, ch chan<- clientstats.Stats) error {
+	url := fmt.Sprintf("%s/v1/stats/stream", baseurl)
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		log.Println(err)
-		return nil, err
+		return err
 	}
+	req.Header.Set("Accept", "text/event-stream")
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		log.Println(err)
-		return nil, err
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
 	}
 
-	data, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
-	if err != nil {
-		log.Println(err)
-		return nil, err
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var stats clientstats.Stats
+		if err := json.Unmarshal([]byte(data), &stats); err != nil {
+			return err
+		}
+		select {
+		case ch <- stats:
+		case <-ctx.Done():
+			return nil
+		}
 	}
-	var stats clientstats.Stats
-	err = json.Unmarshal(data, &stats)
-	if err != nil {
-		log.Println(err)
-		return nil, err
+	if err := scanner.Err(); err != nil {
+		return err
 	}
-
-	return stats, nil
+	return fmt.Errorf("%s: stream closed", url)
 }
 
 func main() {
@@ -85,24 +95,13 @@ func main() {
 	w, h := ui.TerminalDimensions()
 	table.SetRect(0, 0, w, h)
 	table.RowSeparator = false
-	table.ColumnWidths = []int{5, 5, 5, 5, 5}
+	table.ColumnWidths = []int{5, 5, 5, 5, 5, 5, 5}
 
 	statsCh := make(chan clientstats.Stats)
 
 	go func(ctx context.Context) {
-		ticker := time.NewTicker(200 * time.Millisecond)
-		for {
-			select {
-			case <-ticker.C:
-				stats, err := readStats(ctx, baseURL)
-				if err != nil {
-					errCh <- err
-					return
-				}
-				statsCh <- stats
-			case <-ctx.Done():
-				return
-			}
+		if err := streamStats(ctx, baseURL, statsCh); err != nil && ctx.Err() == nil {
+			errCh <- err
 		}
 	}(ctx)
 
@@ -116,7 +115,7 @@ loop:
 			case "q", "<C-c>", "<Escape>":
 				break loop
 
-			case "r", "t", "h", "n", "i":
+			case "r", "t", "R", "T", "h", "n", "i":
 				orderBy = e.ID
 			case "<Resize>":
 				payload := e.Payload.(ui.Resize)
@@ -130,6 +129,10 @@ loop:
 				stats.OrderByInRate()
 			case "t":
 				stats.OrderByOutRate()
+			case "R":
+				stats.OrderByPeriodInBytes()
+			case "T":
+				stats.OrderByPeriodOutBytes()
 			case "h":
 				stats.OrderByHWAddr()
 			case "n":
@@ -138,9 +141,9 @@ loop:
 				stats.OrderByIP()
 			}
 			var rows [][]string
-			rows = append(rows, []string{"ip (i)", "rx rate (r)", "tx rate (t)", "name (n)", "hwaddr (h)"})
+			rows = append(rows, []string{"ip (i)", "rx rate (r)", "tx rate (t)", "rx total (R)", "tx total (T)", "name (n)", "hwaddr (h)"})
 			for _, v := range stats {
-				row := []string{v.IP, v.InFmt(), v.OutFmt(), v.Name, v.HWAddr}
+				row := []string{v.IP, v.InFmt(), v.OutFmt(), v.PeriodInFmt(), v.PeriodOutFmt(), v.Name, v.HWAddr}
 				rows = append(rows, row)
 				for idx, v := range row {
 					l := len(v) + 2
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"time"
+
+	"github.com/peterbourgon/ff/v3"
+	"github.com/some-programs/natbwmon/internal/report"
+)
+
+// reportCommand writes a usage report from the history to out.
+//
+// It accepts the same flags and environment variables as the daemon so that
+// the history location, aliases and groups are shared.
+func reportCommand(args []string, out io.Writer) error {
+	var flags Flags
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	flags.Register(fs)
+	period := fs.String("period", "month", "report period: day, week or month")
+	date := fs.String("date", "", "a date within the reported period, ex: 2024-01-31. defaults to today")
+	top := fs.Int("top", 0, "only show the top devices, 0 shows all")
+	if err := ff.Parse(fs, args, ff.WithEnvVarPrefix("NATBWMON")); err != nil {
+		return err
+	}
+	if err := flags.Setup(os.Stderr); err != nil {
+		return err
+	}
+
+	p, err := report.ParsePeriod(*period)
+	if err != nil {
+		return err
+	}
+	at := time.Now()
+	if *date != "" {
+		at, err = time.ParseInLocation(time.DateOnly, *date, time.Local)
+		if err != nil {
+			return err
+		}
+	}
+	aliases, err := parseHWAddrMap(flags.aliases)
+	if err != nil {
+		return err
+	}
+	groups, err := parseHWAddrMap(flags.groups)
+	if err != nil {
+		return err
+	}
+
+	hist, err := flags.OpenHistory()
+	if err != nil {
+		return err
+	}
+	if hist == nil {
+		return errors.New("the history is disabled, set -history.dir")
+	}
+	rep, err := report.Generate(hist, report.Options{
+		Period: p,
+		At:     at,
+		Top:    *top,
+		Alias: func(hwaddr string) (string, bool) {
+			v, ok := aliases[hwaddr]
+			return v, ok
+		},
+		Group: func(hwaddr string) string {
+			return groups[hwaddr]
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return rep.WriteText(out)
+}
@@ -5,13 +5,45 @@ interface Row {
   name: string;
   ip: string;
   manufacturer: string;
+  interface: string;
+  in_packet_rate: number;
+  out_packet_rate: number;
+  in_bytes: number;
+  out_bytes: number;
+  period_in_bytes: number;
+  period_out_bytes: number;
+  period_start: string;
+  quota?: Quota;
+}
+
+interface Quota {
+  name: string;
+  period: string;
+  limit: number;
+  used: number;
+  exceeded: boolean;
+  enforced: boolean;
+}
+
+interface Alert {
+  rule: string;
+  expr: string;
+  metric: string;
+  subject: string;
+  ip: string;
+  name: string;
+  value: number;
 }
 
 var orderBy = "ip";
 
+const filterInterface = new URLSearchParams(window.location.search).get(
+  "interface"
+);
+
 export const setOrderBy = (o: string) => {
   orderBy = o;
-  updateData();
+  subscribe();
 };
 
 const fmtRate = function (bytes: number, decimals = 2): string {
@@ -24,10 +56,68 @@ const fmtRate = function (bytes: number, decimals = 2): string {
   return `${v} ${sizes[i]}/s`;
 };
 
-const updateData = async () => {
-  const resp = await fetch(`/v1/stats/?order_by=${orderBy}`);
-  const data: Array<Row> = await resp.json();
+export const resetPeriod = async () => {
+  if (!confirm("Reset the traffic totals of all clients?")) {
+    return;
+  }
+  await fetch("/v1/stats/reset", { method: "POST" });
+};
+
+const fmtBytes = function (bytes: number, decimals = 2): string {
+  if (bytes < 1) return "";
+  const k = 1024;
+  const dm = decimals < 0 ? 0 : decimals;
+  const sizes = ["B", "KB", "MB", "GB", "TB", "PB", "EB", "ZB", "YB"];
+  const i = Math.floor(Math.log(bytes) / Math.log(k));
+  const v = parseFloat((bytes / Math.pow(k, i)).toFixed(dm));
+  return `${v} ${sizes[i]}`;
+};
+
+const fmtPacketRate = function (packets: number): string {
+  if (packets < 0.01) return "";
+  return `${packets.toFixed(1)} p/s`;
+};
+
+// quotaCell shows how much of the quota of a client has been used.
+const quotaCell = (q?: Quota): string => {
+  if (!q) {
+    return "<td></td>";
+  }
+  const cls = q.exceeded ? ' class="failed"' : "";
+  const title = `${q.name} per ${q.period}${q.enforced ? ", restricted" : ""}`;
+  return `<td${cls} title="${title}">${fmtBytes(q.used) || "0 B"} / ${fmtBytes(q.limit)}</td>`;
+};
+
+// renderAlerts shows a banner for each firing alert.
+const renderAlerts = (alerts: Array<Alert>) => {
+  const container = document.getElementById("alerts")!;
+  container.textContent = "";
+  for (const a of alerts) {
+    const div = document.createElement("div");
+    div.className = "failed-invert banner";
+    const value = a.metric.endsWith("rate")
+      ? fmtRate(a.value)
+      : fmtBytes(a.value);
+    const client = a.ip
+      ? `<a href="/client?ip=${a.ip}">${a.name || a.ip}</a>`
+      : a.subject;
+    div.innerHTML = `${a.rule}: ${client} ${a.expr} (${value})`;
+    container.appendChild(div);
+  }
+};
 
+// pollAlerts refreshes the alert banners until the alerts are not available,
+// the API only exists when alert rules are configured.
+const pollAlerts = async () => {
+  const resp = await fetch("/v1/alerts?state=firing");
+  if (!resp.ok) {
+    return;
+  }
+  renderAlerts(await resp.json());
+  setTimeout(pollAlerts, 10000);
+};
+
+const render = (data: Array<Row>) => {
   const el = document.createElement("tbody");
   const header = document.createElement("tr");
   header.innerHTML = `
@@ -35,20 +125,32 @@ const updateData = async () => {
 <th><button onclick="app.setOrderBy('name')">Hostname</a></th>
 <th><button onclick="app.setOrderBy('rate_in')">IN rate</a></th>
 <th><button onclick="app.setOrderBy('rate_out')">OUT rate</a></th>
+<th><button onclick="app.setOrderBy('period_in')">IN total</a></th>
+<th><button onclick="app.setOrderBy('period_out')">OUT total</a></th>
+<th>Quota</th>
+<th>IN pkts</th>
+<th>OUT pkts</th>
 <th><button onclick="app.setOrderBy('hwaddr')">MAC</a></th>
 <th><button onclick="app.setOrderBy('manufacturer')">Manufacturer</a></th>
+<th><button onclick="app.setOrderBy('interface')">Interface</a></th>
 `;
   el.appendChild(header);
 
   for (const v of data) {
     const tr = document.createElement("tr");
     tr.innerHTML = `
- <td><a href="/conntrack?ip=${v.ip}">${v.ip}</a></td>
+ <td><a href="/client?ip=${v.ip}">${v.ip}</a></td>
  <td>${v.name}</td>
  <td class="success">${fmtRate(v.in_rate)}</td>
  <td class="failed">${fmtRate(v.out_rate)}</td>
+ <td class="success" title="${fmtBytes(v.in_bytes)} since first seen">${fmtBytes(v.period_in_bytes)}</td>
+ <td class="failed" title="${fmtBytes(v.out_bytes)} since first seen">${fmtBytes(v.period_out_bytes)}</td>
+ ${quotaCell(v.quota)}
+ <td>${fmtPacketRate(v.in_packet_rate)}</td>
+ <td>${fmtPacketRate(v.out_packet_rate)}</td>
  <td>${v.hwaddr}</td>
  <td>${v.manufacturer}</td>
+ <td><a href="/?interface=${v.interface}">${v.interface}</a></td>
 `;
     el.appendChild(tr);
   }
@@ -58,15 +160,28 @@ const updateData = async () => {
   container.appendChild(el);
 };
 
-updateData();
+var source: EventSource | null = null;
 
-setInterval(async function () {
-  if (document.getSelection()?.type !== "Range") {
-    if (!document.hidden) {
-      await updateData();
-    }
+// subscribe (re)opens the stats stream with the current order.
+const subscribe = () => {
+  if (source) {
+    source.close();
   }
-}, 900);
+  const params = new URLSearchParams({ order_by: orderBy });
+  if (filterInterface) {
+    params.set("interface", filterInterface);
+  }
+  source = new EventSource(`/v1/stats/stream?${params}`);
+  source.onmessage = (e: MessageEvent) => {
+    if (document.getSelection()?.type === "Range" || document.hidden) {
+      return;
+    }
+    render(JSON.parse(e.data));
+  };
+};
+
+subscribe();
+pollAlerts();
 
 declare global {
   interface Window {